@@ -4,9 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/Erikqwerty/KronosKeeper/internal/app/manager"
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/restore"
 )
 
 var (
@@ -15,10 +18,77 @@ var (
 
 func init() {
 	flag.StringVar(&configPath, "config-path", "configs/kronoskeeper.toml", "Path to configure file")
+	flag.Usage = usage
+}
+
+// usage выводит справку по командам kk.
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Использование: kk [-config-path path] <команда> [аргументы]
+
+Команды:
+  storages                          Список удаленных хранилищ из конфигурации
+  list <unit>                       Список резервных копий юнита в каждом из его хранилищ
+  decrypt <unit> <file> [output]    Расшифровать архив юнита
+  inspect <unit> <YYYY-MM/archive> [storage]
+                                    Сведения о резервной копии и список файлов в ней по манифесту
+  restore <unit> [YYYY-MM/archive] [--from storage] [--at time|--latest] --target dir [--force] [--path glob] [--dry-run]
+                                    Скачать, проверить, расшифровать и распаковать архив юнита.
+                                    --at "2024-03-15 13:37" или 2024-03-15 - последний архив не позже
+                                    указанного времени, по умолчанию самый новый. Без --from архив
+                                    ищется на локальном диске, затем в хранилищах юнита.
+                                    --path nginx.conf или --path "nginx/conf.d/*" - восстановить только
+                                    подходящие файлы, флаг можно повторять. --dry-run - показать, какие
+                                    файлы будут созданы и перезаписаны, ничего не записывая
+  ls-archive <unit> [YYYY-MM/archive] [--from storage] [--at time] [--path glob]
+                                    Список файлов архива юнита, по умолчанию самого нового
+  diff <unit> [YYYY-MM/archive] [--from storage] [--at time] [--path glob]
+                                    Сравнить архив юнита, по умолчанию самый новый, с текущими файлами
+                                    inputPaths: добавленные, удаленные и измененные файлы
+
+Флаги:
+`)
+	flag.PrintDefaults()
+}
+
+// pathsFlag - флаг --path, который можно повторять или передавать список шаблонов через запятую.
+type pathsFlag []string
+
+func (p *pathsFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathsFlag) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			*p = append(*p, pattern)
+		}
+	}
+	return nil
+}
+
+// parseFlags разбирает флаги команды в args, которые могут стоять и до, и после аргументов команды,
+// и возвращает аргументы без флагов.
+func parseFlags(flags *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return rest
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
 }
 
 func main() {
 	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
 	conf, err := config.NewConfig(configPath)
 	if err != nil {
 		log.Fatal(err)
@@ -28,8 +98,98 @@ func main() {
 		log.Fatal(err)
 	}
 
-	err = kkmanager.ListBackupsUnit("nginx")
+	switch args[0] {
+	case "storages":
+		err = kkmanager.ListStorages()
+	case "list":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		err = kkmanager.ListBackupsUnit(args[1])
+	case "decrypt":
+		if len(args) < 3 || len(args) > 4 {
+			usage()
+			os.Exit(2)
+		}
+		var output string
+		if len(args) == 4 {
+			output = args[3]
+		}
+		err = kkmanager.DecryptBackup(args[1], args[2], output)
+	case "inspect":
+		if len(args) < 3 || len(args) > 4 {
+			usage()
+			os.Exit(2)
+		}
+		var from string
+		if len(args) == 4 {
+			from = args[3]
+		}
+		err = kkmanager.InspectBackup(args[1], args[2], from)
+	case "restore":
+		var opts restore.Options
+		var at string
+		var latest, dryRun bool
+		flags := flag.NewFlagSet("restore", flag.ExitOnError)
+		flags.Usage = usage
+		flags.StringVar(&opts.From, "from", "", "Хранилище, из которого восстанавливать")
+		flags.StringVar(&at, "at", "", "Время архива")
+		flags.BoolVar(&latest, "latest", false, "Самый новый архив")
+		flags.StringVar(&opts.Target, "target", "", "Папка восстановления")
+		flags.BoolVar(&opts.Force, "force", false, "Перезаписывать существующие файлы")
+		flags.Var((*pathsFlag)(&opts.Paths), "path", "Шаблон пути файлов для восстановления")
+		flags.BoolVar(&dryRun, "dry-run", false, "Показать план восстановления, ничего не записывая")
+		rest := parseFlags(flags, args[1:])
+		if len(rest) < 1 || len(rest) > 2 || opts.Target == "" || (latest && at != "") {
+			usage()
+			os.Exit(2)
+		}
+		if len(rest) == 2 {
+			opts.Backup = rest[1]
+		}
+		if at != "" {
+			if opts.At, err = restore.ParseTime(at); err != nil {
+				break
+			}
+		}
+		if dryRun {
+			err = kkmanager.PlanRestore(rest[0], opts)
+			break
+		}
+		err = kkmanager.RestoreBackup(rest[0], opts)
+	case "ls-archive", "diff":
+		var opts restore.Options
+		var at string
+		flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+		flags.Usage = usage
+		flags.StringVar(&opts.From, "from", "", "Хранилище с архивом")
+		flags.StringVar(&at, "at", "", "Время архива")
+		flags.Var((*pathsFlag)(&opts.Paths), "path", "Шаблон пути файлов")
+		rest := parseFlags(flags, args[1:])
+		if len(rest) < 1 || len(rest) > 2 {
+			usage()
+			os.Exit(2)
+		}
+		if len(rest) == 2 {
+			opts.Backup = rest[1]
+		}
+		if at != "" {
+			if opts.At, err = restore.ParseTime(at); err != nil {
+				break
+			}
+		}
+		if args[0] == "diff" {
+			err = kkmanager.DiffBackup(rest[0], opts)
+			break
+		}
+		err = kkmanager.ListArchive(rest[0], opts)
+	default:
+		usage()
+		os.Exit(2)
+	}
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/TaskRunner"
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/notifications/telegram"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/retention"
 	"github.com/Erikqwerty/KronosKeeper/internal/service"
 	"github.com/sirupsen/logrus"
 )
@@ -78,11 +79,16 @@ func (kkd *KronosKeeperDeamon) Stop() error {
 // addTasksBackup добавляет задачи резервного копирования в планировщик задач.
 func (kkd *KronosKeeperDeamon) addTasksBackup() error {
 	for _, unit := range kkd.config.BackupUnits {
+		unit := unit // каждая задача должна использовать свой юнит
 		err := kkd.AddTask(unit.CrontabTask, func() {
 			backupReport, err := kkd.CreateBackup(unit, kkd.config.RemoteStorages)
 			if err != nil {
 				kkd.writeLogAndNotifyError(fmt.Sprintf("Ошибка при запуске создания резервной копии для Unit %v: %v", unit.Name, err))
 			}
+			// Отчета может не быть, если архив не удалось создать
+			if backupReport == nil {
+				return
+			}
 			if err := kkd.handleBackupReport(backupReport); err != nil {
 				kkd.writeLogAndNotifyError(fmt.Sprintf("Ошибка при обработке отчета о резервном копировании для Unit %v: %v", unit.Name, err))
 			}
@@ -123,35 +129,82 @@ func (kkd *KronosKeeperDeamon) handleBackupReport(backupReport *service.BackupRe
 	// Обработка локальной резервной копии
 	if backupReport.Local != nil {
 		msg := fmt.Sprintf("%v - Успешно созданна резервная копия в %v на локальном диске", backupReport.CurrentTime, backupReport.Local.ArchiveName)
-		kkd.writeLogAndNotify(msg)
+		if backupReport.Local.ArchivePath == "" {
+			msg = fmt.Sprintf("%v - Резервная копия %v создана и передана в удаленные хранилища потоком", backupReport.CurrentTime, backupReport.Local.ArchiveName)
+		}
+		kkd.writeLogAndNotify(fmt.Sprintf("%v, размер: %v байт, SHA-256: %v, MD5: %v", msg, backupReport.Local.Size, backupReport.Local.SHA256, backupReport.Local.MD5))
+	}
+	if backupReport.ManifestErr != nil {
+		kkd.writeLogAndNotifyError(fmt.Sprintf("%v - Ошибка сохранения манифеста: %v", backupReport.CurrentTime, backupReport.ManifestErr))
+		ERRORS = fmt.Errorf("%v: %v", ERRORS, backupReport.ManifestErr)
 	}
 
 	// Обработка удаленных хранилищ
-	if backupReport.Remote != nil {
-		// Обработка загрузки на Google Cloud
-		if backupReport.Remote.GCloud.Status {
-			msg := fmt.Sprintf("%v - Успешная загрузка резервной копии %v на google Cloud disk", backupReport.CurrentTime, backupReport.Local.ArchiveName)
+	for _, name := range backupReport.Remote.Names() {
+		report := backupReport.Remote[name]
+		if report.Status {
+			msg := fmt.Sprintf("%v - Успешная загрузка резервной копии %v в хранилище %v", backupReport.CurrentTime, backupReport.ArchiveName, name)
+			if report.Verified != "" {
+				msg = fmt.Sprintf("%v, проверка: %v", msg, report.Verified)
+			}
+			if report.Attempts > 1 {
+				msg = fmt.Sprintf("%v, попыток: %v", msg, report.Attempts)
+			}
 			kkd.writeLogAndNotify(msg)
-		} else if backupReport.Remote.GCloud.Err != nil {
-			err := backupReport.Remote.GCloud.Err
-			kkd.writeLogAndNotifyError(err.Error())
-			ERRORS = fmt.Errorf("%v: %v", ERRORS, err) // Добавляем ошибку в общий список ошибок
+			if report.ManifestErr != nil {
+				kkd.writeLogAndNotifyError(fmt.Sprintf("%v - Ошибка загрузки манифеста: %v", backupReport.CurrentTime, report.ManifestErr))
+				ERRORS = fmt.Errorf("%v: %v", ERRORS, report.ManifestErr)
+			}
+		} else if report.Err != nil {
+			msg := report.Err.Error()
+			if report.Attempts > 1 {
+				msg = fmt.Sprintf("%v (попыток: %v)", msg, report.Attempts)
+			}
+			kkd.writeLogAndNotifyError(msg)
+			ERRORS = fmt.Errorf("%v: %v", ERRORS, report.Err) // Добавляем ошибку в общий список ошибок
 		}
-		// Обработка загрузки на Google Drive
-		if backupReport.Remote.GDrive.Status {
-			msg := fmt.Sprintf("%v - Успешная загрузка резервной копии %v на google Drive disk", backupReport.CurrentTime, backupReport.Local.ArchiveName)
-			kkd.writeLogAndNotify(msg)
-		} else if backupReport.Remote.GDrive.Err != nil {
-			err := backupReport.Remote.GCloud.Err
-			kkd.writeLogAndNotifyError(err.Error())
-			ERRORS = fmt.Errorf("%v: %v", ERRORS, err) // Добавляем ошибку в общий список ошибок
+	}
+
+	// Обработка архивов, удаленных для соблюдения ограничения maxDiskUsage.
+	// Ошибка ограничения уже отправлена как ошибка создания резервной копии.
+	if backupReport.Evicted != nil {
+		for _, path := range backupReport.Evicted.Deleted {
+			kkd.writeLogAndNotify(fmt.Sprintf("%v - Удален для соблюдения ограничения maxDiskUsage: %v", backupReport.CurrentTime, path))
+		}
+	}
+
+	// Обработка очистки архивов по сроку хранения
+	if err := kkd.handlePruneReport(backupReport.CurrentTime, "на локальном диске", backupReport.LocalPrune); err != nil {
+		ERRORS = fmt.Errorf("%v: %v", ERRORS, err)
+	}
+	for _, name := range backupReport.RemotePrune.Names() {
+		if err := kkd.handlePruneReport(backupReport.CurrentTime, "в хранилище "+name, backupReport.RemotePrune[name]); err != nil {
+			ERRORS = fmt.Errorf("%v: %v", ERRORS, err)
 		}
-		// Обработка других удаленных хранилищ
 	}
 
 	return ERRORS
 }
 
+// handlePruneReport записывает в лог и отправляет в телеграм список архивов, удаленных по сроку хранения в хранилище where (например "на локальном диске").
+func (kkd *KronosKeeperDeamon) handlePruneReport(currentTime, where string, report *retention.Report) error {
+	if report == nil {
+		return nil
+	}
+	for _, path := range report.Deleted {
+		if report.DryRun {
+			kkd.writeLogAndNotify(fmt.Sprintf("%v - [dry-run] Был бы удален по сроку хранения %v: %v", currentTime, where, path))
+		} else {
+			kkd.writeLogAndNotify(fmt.Sprintf("%v - Удален по сроку хранения %v: %v", currentTime, where, path))
+		}
+	}
+	if report.Err != nil {
+		kkd.writeLogAndNotifyError(fmt.Sprintf("%v - Ошибка очистки архивов %v: %v", currentTime, where, report.Err))
+		return report.Err
+	}
+	return nil
+}
+
 // writeLogAndNotify записывает информацию в лог и отправляет уведомления в телеграм.
 func (kkd *KronosKeeperDeamon) writeLogAndNotify(msg string) {
 	kkd.Logger.Infof(msg)
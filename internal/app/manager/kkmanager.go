@@ -2,54 +2,309 @@ package manager
 
 import (
 	"fmt"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/compress"
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/encryption"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/manifest"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages"
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
-	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/gCloud"
-	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/gDrive"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/restore"
 
 	"github.com/sirupsen/logrus"
 )
 
 type Kkmanager struct {
-	Conf *config.Config
-	RemoteStorage
-	Logger *logrus.Logger
-}
-
-type RemoteStorage struct {
-	GCloud *gCloud.GCloud
-	GDrive *gDrive.GDrive
+	Conf    *config.Config
+	Logger  *logrus.Logger
+	remotes *remotestorages.Remotestorages // удаленные хранилища, создаются при первом обращении
 }
 
 func New(conf *config.Config) (*Kkmanager, error) {
-	var err error
 	kkm := &Kkmanager{
 		Conf:   conf,
 		Logger: logrus.New(),
 	}
-	if conf.RemoteStorages != nil {
-		if conf.RemoteStorages.GCloud.CredentialsJSON != "" {
-			kkm.GCloud = gCloud.New(conf.RemoteStorages.GCloud.CredentialsJSON)
-			if err := kkm.GCloud.NewClient(); err != nil {
-				return kkm, err
+	return kkm, nil
+}
+
+// storage возвращает удаленное хранилище name, создавая его клиента при первом обращении.
+// Клиенты создаются только для команд, которым нужен доступ к хранилищам.
+func (kkm *Kkmanager) storage(name string) (cloudStorages.Storage, error) {
+	return kkm.storages().Storage(name)
+}
+
+// storages возвращает удаленные хранилища из конфигурации.
+func (kkm *Kkmanager) storages() *remotestorages.Remotestorages {
+	if kkm.remotes == nil {
+		kkm.remotes, _ = remotestorages.New(&remotestorages.UploadConfig{}, kkm.Conf.RemoteStorages)
+	}
+	return kkm.remotes
+}
+
+// ListStorages выводит хранилища, описанные в конфигурации, с их типами и юнитами, которые в них отправляют резервные копии.
+func (kkm *Kkmanager) ListStorages() error {
+	names := make([]string, 0, len(kkm.Conf.RemoteStorages))
+	for name := range kkm.Conf.RemoteStorages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var units []string
+		for _, unit := range kkm.Conf.BackupUnits {
+			for _, remote := range unit.UploadTo {
+				if remote == name {
+					units = append(units, unit.Name)
+				}
 			}
 		}
+		fmt.Printf("%v | тип: %v | юниты: %v\n", name, kkm.Conf.RemoteStorages[name].Type, strings.Join(units, ", "))
+	}
+	return nil
+}
+
+// unit возвращает настройки юнита по его имени.
+func (kkm *Kkmanager) unit(unitName string) (*config.BackupUnit, error) {
+	for i := range kkm.Conf.BackupUnits {
+		if kkm.Conf.BackupUnits[i].Name == unitName {
+			return &kkm.Conf.BackupUnits[i], nil
+		}
+	}
+	return nil, fmt.Errorf("юнита с таким именем: %v не существует", unitName)
+}
 
-		if conf.RemoteStorages.GDrive.ApiKeyJson != "" {
-			kkm.GDrive, err = gDrive.New(conf.RemoteStorages.GDrive.ApiKeyJson, conf.RemoteStorages.GDrive.TokenFile)
-			if err != nil {
-				return kkm, err
-			}
-			if err := kkm.GDrive.NewClient(); err != nil {
-				return kkm, err
-			}
+// DecryptBackup расшифровывает архив юнита src в файл dst, используя настройки шифрования юнита.
+// Если dst не указан, архив сохраняется рядом с src без расширения шифрования.
+func (kkm *Kkmanager) DecryptBackup(unitName, src, dst string) error {
+	unit, err := kkm.unit(unitName)
+	if err != nil {
+		return err
+	}
+	if unit.Encryption == nil {
+		return fmt.Errorf("для юнита %v не настроено шифрование", unitName)
+	}
+	if !encryption.IsEncrypted(src) {
+		return fmt.Errorf("файл %v не является зашифрованным архивом", src)
+	}
+	if dst == "" {
+		dst = encryption.TrimExtension(src)
+	}
+
+	encryptor, err := encryption.New(unit.Encryption)
+	if err != nil {
+		return err
+	}
+	if err := encryptor.DecryptFile(src, dst); err != nil {
+		return err
+	}
+
+	fmt.Printf("Архив расшифрован и сохранен по пути: %v\n", dst)
+	return nil
+}
+
+// InspectBackup выводит сведения о резервной копии backup юнита и список файлов в ней по манифесту архива,
+// не скачивая сам архив. backup указывается относительно папки юнита: <YYYY-MM>/<архив>.
+// Манифест ищется в хранилище from, а если оно не указано - сначала на локальном диске, затем в хранилищах юнита.
+func (kkm *Kkmanager) InspectBackup(unitName, backup, from string) error {
+	unit, err := kkm.unit(unitName)
+	if err != nil {
+		return err
+	}
+	m, err := kkm.readManifest(unit, backup, from)
+	if err != nil {
+		return err
+	}
+
+	encrypted := "нет"
+	if m.Encrypted {
+		encrypted = "да"
+	}
+	fmt.Printf("Архив: %v/%v\n", m.YearMonth, m.Archive)
+	fmt.Printf("Юнит: %v | хост: %v | создан: %v | %v\n", m.Unit, m.Host, m.Created.Format("2006-01-02 15:04:05"), m.Tool)
+	fmt.Printf("Формат: %v | зашифрован: %v | размер: %v байт\n", m.Format, encrypted, m.Size)
+	fmt.Printf("SHA-256: %v | MD5: %v\n", m.SHA256, m.MD5)
+	fmt.Printf("Пути: %v | исключения: %v\n", strings.Join(m.InputPaths, ", "), strings.Join(m.Exclude, ", "))
+	fmt.Printf("Файлов: %v, общий размер: %v байт\n", m.FileCount, m.TotalSize)
+	printEntries(m.Files)
+	return nil
+}
+
+// printEntries выводит элементы архива: права, размер, время изменения и путь.
+func printEntries(entries []compress.Entry) {
+	for _, entry := range entries {
+		name := entry.Path
+		if entry.Link != "" {
+			name = fmt.Sprintf("%v -> %v", entry.Path, entry.Link)
 		}
+		fmt.Printf("%v %12v %v %v\n", entry.Mode, entry.Size, entry.ModTime.Format("2006-01-02 15:04:05"), name)
 	}
+}
 
-	return kkm, err
+// readManifest читает манифест резервной копии backup юнита из хранилища from или, если оно не указано,
+// с локального диска или из первого хранилища юнита, в котором он есть.
+func (kkm *Kkmanager) readManifest(unit *config.BackupUnit, backup, from string) (*manifest.Manifest, error) {
+	name := manifest.Name(backup)
+	if from == "" && unit.OutputPath != "" {
+		m, err := manifest.ReadFile(filepath.Join(unit.OutputPath, unit.Name, name))
+		if err == nil || len(unit.UploadTo) == 0 {
+			return m, err
+		}
+	}
+
+	remotes := unit.UploadTo
+	if from != "" {
+		remotes = []string{from}
+	}
+	var errs []string
+	for _, remote := range remotes {
+		reader, err := kkm.storages().Open(remote, path.Join(unit.RemotePath, unit.Name, name))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		m, err := manifest.Read(reader)
+		reader.Close()
+		return m, err
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("манифест %v не найден: у юнита %v нет локальной папки и удаленных хранилищ", name, unit.Name)
+	}
+	return nil, fmt.Errorf("манифест %v не найден: %v", name, strings.Join(errs, "; "))
+}
+
+// RestoreBackup восстанавливает резервную копию юнита по параметрам opts и выводит, откуда и какой архив восстановлен.
+func (kkm *Kkmanager) RestoreBackup(unitName string, opts restore.Options) error {
+	unit, err := kkm.unit(unitName)
+	if err != nil {
+		return err
+	}
+	if opts.From != "" {
+		if _, ok := kkm.Conf.RemoteStorages[opts.From]; !ok {
+			return fmt.Errorf("хранилище %v не описано в конфигурации", opts.From)
+		}
+	}
+	defer kkm.storages().Close()
+
+	report, err := restore.New(*unit, kkm.storages()).Restore(opts)
+	if err != nil {
+		return err
+	}
+	verified := "манифест не найден, контрольная сумма не проверялась"
+	switch {
+	case report.Verified && report.Ranged:
+		verified = "SHA-256 файлов совпадает с манифестом"
+	case report.Verified:
+		verified = "SHA-256 совпадает с манифестом"
+	}
+	fmt.Printf("Архив %v (%v) восстановлен в %v: элементов %v, %v\n", report.Archive, report.From, opts.Target, len(report.Entries), verified)
+	if report.Ranged {
+		fmt.Printf("Архив прочитан из хранилища по частям: %v байт\n", report.Fetched)
+	}
+	return nil
+}
+
+// PlanRestore выводит, какие файлы восстановление резервной копии юнита по opts создаст и перезапишет
+// в папке opts.Target, ничего не записывая.
+func (kkm *Kkmanager) PlanRestore(unitName string, opts restore.Options) error {
+	unit, err := kkm.unit(unitName)
+	if err != nil {
+		return err
+	}
+	if opts.From != "" {
+		if _, ok := kkm.Conf.RemoteStorages[opts.From]; !ok {
+			return fmt.Errorf("хранилище %v не описано в конфигурации", opts.From)
+		}
+	}
+	defer kkm.storages().Close()
+
+	plan, err := restore.New(*unit, kkm.storages()).Plan(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Пробное восстановление архива %v (%v) в %v, файлы не записываются\n", plan.Archive, plan.From, opts.Target)
+	printDiff(plan)
+	fmt.Printf("Будет создано файлов: %v, перезаписано: %v\n", len(plan.Deleted), len(plan.Modified)+plan.Unchanged)
+	if !opts.Force && len(plan.Modified)+plan.Unchanged > 0 {
+		fmt.Println("Файлы уже существуют, без --force восстановление не будет выполнено")
+	}
+	return nil
+}
+
+// DiffBackup сравнивает резервную копию юнита, выбранную по opts, с текущим состоянием inputPaths юнита
+// и выводит добавленные, удаленные и измененные с момента резервного копирования файлы.
+func (kkm *Kkmanager) DiffBackup(unitName string, opts restore.Options) error {
+	unit, err := kkm.unit(unitName)
+	if err != nil {
+		return err
+	}
+	if opts.From != "" {
+		if _, ok := kkm.Conf.RemoteStorages[opts.From]; !ok {
+			return fmt.Errorf("хранилище %v не описано в конфигурации", opts.From)
+		}
+	}
+	defer kkm.storages().Close()
+
+	diff, err := restore.New(*unit, kkm.storages()).Diff(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Сравнение архива %v (%v) с %v\n", diff.Archive, diff.From, strings.Join(unit.InputPaths, ", "))
+	printDiff(diff)
+	return nil
+}
+
+// printDiff выводит различия между файлами на диске и архивом: "+" - файл есть только на диске,
+// "-" - только в архиве, "~" - файл изменен.
+func printDiff(diff *restore.Diff) {
+	if diff.FromManifest {
+		fmt.Println("Содержимое архива взято из манифеста")
+	}
+	for _, name := range diff.Added {
+		fmt.Printf("+ %v\n", name)
+	}
+	for _, name := range diff.Deleted {
+		fmt.Printf("- %v\n", name)
+	}
+	for _, change := range diff.Modified {
+		fmt.Printf("~ %v (%v)\n", change.Path, strings.Join(change.Reasons, ", "))
+	}
+	fmt.Printf("Только на диске: %v, только в архиве: %v, изменено: %v, без изменений: %v\n",
+		len(diff.Added), len(diff.Deleted), len(diff.Modified), diff.Unchanged)
+}
+
+// ListArchive выводит элементы резервной копии юнита, выбранной по opts и подходящие под opts.Paths.
+func (kkm *Kkmanager) ListArchive(unitName string, opts restore.Options) error {
+	unit, err := kkm.unit(unitName)
+	if err != nil {
+		return err
+	}
+	if opts.From != "" {
+		if _, ok := kkm.Conf.RemoteStorages[opts.From]; !ok {
+			return fmt.Errorf("хранилище %v не описано в конфигурации", opts.From)
+		}
+	}
+	defer kkm.storages().Close()
+
+	report, err := restore.New(*unit, kkm.storages()).List(opts)
+	if err != nil {
+		return err
+	}
+	source := "список из архива"
+	switch {
+	case report.FromManifest:
+		source = "список из манифеста"
+	case report.Ranged:
+		source = fmt.Sprintf("центральный каталог прочитан по частям: %v байт", report.Fetched)
+	}
+	fmt.Printf("Архив %v (%v), %v\n", report.Archive, report.From, source)
+	printEntries(report.Entries)
+	return nil
 }
 
 func (kkm *Kkmanager) listDir(remote cloudStorages.Lister, path string) error {
@@ -90,31 +345,25 @@ func isValidDate(date string) bool {
 }
 
 func (kkm *Kkmanager) ListBackupsUnit(unitName string) error {
-	for _, unit := range kkm.Conf.BackupUnits {
-		if unit.Name == unitName {
-			for _, remote := range unit.UploadTo {
-				fmt.Print("_________________________________________________________________________________")
-				switch remote {
-				case "gDrive":
-					fmt.Printf("\n				Список бекапов на %v:		\n", remote)
-					fmt.Println("‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾")
-					if err := kkm.listDir(kkm.GDrive, unit.RemotePath); err != nil {
-						return err
-					}
-					continue
-				case "gCloud":
-					fmt.Printf("\n				Список бекапов на %v:		\n", remote)
-					fmt.Println("‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾")
-					if err := kkm.listDir(kkm.GCloud, unit.RemotePath); err != nil {
-						return err
-					}
-					continue
-				default:
-					kkm.Logger.Infof("У данного юнита: %v нету параметров удаленного копирования", unitName)
-				}
-			}
-		} else {
-			kkm.Logger.Warnf("Юнита с таким именим: %v не существует", unitName)
+	unit, err := kkm.unit(unitName)
+	if err != nil {
+		return err
+	}
+	if len(unit.UploadTo) == 0 {
+		kkm.Logger.Infof("У данного юнита: %v нету параметров удаленного копирования", unitName)
+		return nil
+	}
+
+	for _, remote := range unit.UploadTo {
+		storage, err := kkm.storage(remote)
+		if err != nil {
+			return fmt.Errorf("хранилище %v: %v", remote, err)
+		}
+		fmt.Print("_________________________________________________________________________________")
+		fmt.Printf("\n				Список бекапов на %v (%v):		\n", remote, kkm.Conf.RemoteStorages[remote].Type)
+		fmt.Println("‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾")
+		if err := kkm.listDir(storage, unit.RemotePath); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -1,8 +1,11 @@
-// Пакет compress реализует функционал для создания архивов форматов ZIP, ...
+// Пакет compress реализует функционал для создания архивов форматов ZIP, TAR, TAR.GZ, TAR.ZST и TAR.XZ.
 package compress
 
 import (
 	"archive/zip"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -11,19 +14,39 @@ import (
 	"time"
 )
 
+const (
+	YearMonthLayout = "2006-01"  // Формат имени папки год-месяц, в которой лежат архивы
+	DayTimeLayout   = "02-15:04" // Формат префикса имени архива день-часы:минуты
+)
+
 // Compress представляет параметры для создания архива.
 type Compress struct {
-	ArchiveName string   // Имя для создаваемого архива
-	InputPaths  []string // Элементы, которые необходимо включить в архив
-	ExludeFile  []string // Исключения из архивации "*.log", "array.zip"
-	OutputPath  string   // Путь сохранения архива
+	ArchiveName string    // Имя для создаваемого архива
+	InputPaths  []string  // Элементы, которые необходимо включить в архив
+	ExludeFile  []string  // Исключения из архивации "*.log", "array.zip"
+	OutputPath  string    // Путь сохранения архива
+	Level       int       // Уровень сжатия, 0 - уровень по умолчанию для формата
+	Workers     int       // Количество потоков сжатия, 0 или 1 - сжатие в одном потоке
+	Encryptor   Encryptor // Шифрование архива после сжатия, nil - без шифрования
+
+	entries []Entry // Элементы, записанные в архив
+}
+
+// Encryptor шифрует поток архива. Реализуется пакетом encryption.
+type Encryptor interface {
+	Encrypt(dst io.Writer) (io.WriteCloser, error) // Возвращает шифрующего писателя поверх dst
+	Extension() string                             // Расширение зашифрованного архива, например ".age"
 }
 
 // Содержит отчет о результатах сжатия
 type CompressReport struct {
-	YearMoth    string // Год месяц создания - соответствует имени содержащей архив папке
-	ArchiveName string // Имя архива - формат имени 23-10:34-unit.zip
-	ArchivePath string // Полный путь до архива
+	YearMoth    string  // Год месяц создания - соответствует имени содержащей архив папке
+	ArchiveName string  // Имя архива - формат имени 23-10:34-unit.zip
+	ArchivePath string  // Полный путь до архива, пустой при потоковой архивации
+	Size        int64   // Размер архива в байтах
+	SHA256      string  // Контрольная сумма SHA-256 архива в шестнадцатеричном виде
+	MD5         string  // Контрольная сумма MD5 архива в шестнадцатеричном виде, для сверки с удаленными хранилищами
+	Entries     []Entry // Элементы архива в порядке записи
 }
 
 // New создает новый экземпляр Compress.
@@ -36,6 +59,9 @@ func (c *Compress) Start(format string) (*CompressReport, error) {
 	if c.ArchiveName == "" || len(c.InputPaths) == 0 || c.OutputPath == "" {
 		return nil, fmt.Errorf("недостаточно параметров для запуска архивации")
 	}
+	if !isSupported(format) {
+		return nil, fmt.Errorf("формат сжатия ' %v ' не поддерживается", format)
+	}
 	// Создаем обьект времени для указания даты бекапов
 	currentTime := time.Now()
 
@@ -46,31 +72,132 @@ func (c *Compress) Start(format string) (*CompressReport, error) {
 	c.OutputPath = filepath.Join(c.OutputPath, c.ArchiveName) // обнавляем путь до папки где нужно создать папку год месяц
 
 	// Проверяем есть ли в папке с именим юнита папка с годом и месяцем, если нет то создаем
-	dateYearMoth := currentTime.Format("2006-01")
-	if err := c.ensureDirInOutputPath(dateYearMoth); err != nil {
+	report := c.newReport(format, currentTime)
+	if err := c.ensureDirInOutputPath(report.YearMoth); err != nil {
 		return nil, err
 	}
-	c.OutputPath = filepath.Join(c.OutputPath, currentTime.Format("2006-01")) // обнавляем путь до папки куда нужно положить бекап
+	c.OutputPath = filepath.Join(c.OutputPath, report.YearMoth) // обнавляем путь до папки куда нужно положить бекап
 
-	// Добавляем к имени архива день месяца и время
-	dateDayTime := currentTime.Format("02-15:04")
-	c.ArchiveName = dateDayTime + "-" + c.ArchiveName
+	// Создаем файл для записи архива
+	archivePath := filepath.Join(c.OutputPath, report.ArchiveName)
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
-	switch format {
-	case "zip":
-		if err := c.Zip(); err != nil {
-			return nil, err
-		}
-		c.ArchiveName += ".zip"
-	default:
+	if err := c.write(format, file, report); err != nil {
+		file.Close()
+		os.Remove(archivePath) // Не оставляем на диске недописанный архив
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	c.ArchiveName = report.ArchiveName
+	report.ArchivePath = c.OutputPath
+	return report, nil
+}
+
+// Stream создает архив с указанным форматом, не сохраняя его на диск.
+// Сначала формируется отчет с именем архива и папкой год-месяц, по которому open возвращает
+// писателя для архива, например поток загрузки в удаленное хранилище. После записи отчет
+// дополняется размером и контрольной суммой архива.
+func (c *Compress) Stream(format string, open func(report *CompressReport) (io.Writer, error)) (*CompressReport, error) {
+	if c.ArchiveName == "" || len(c.InputPaths) == 0 {
+		return nil, fmt.Errorf("недостаточно параметров для запуска архивации")
+	}
+	if !isSupported(format) {
 		return nil, fmt.Errorf("формат сжатия ' %v ' не поддерживается", format)
 	}
 
+	report := c.newReport(format, time.Now())
+	w, err := open(report)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.write(format, w, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// newReport формирует отчет с именем архива и папкой год-месяц для момента currentTime.
+func (c *Compress) newReport(format string, currentTime time.Time) *CompressReport {
+	// Добавляем к имени архива день месяца и время
+	name := currentTime.Format(DayTimeLayout) + "-" + c.ArchiveName + "." + format
+	if c.Encryptor != nil {
+		name += c.Encryptor.Extension()
+	}
 	return &CompressReport{
-		YearMoth:    dateYearMoth,  // дата создания архива день год месяц
-		ArchiveName: c.ArchiveName, // имя созданного архива
-		ArchivePath: c.OutputPath,  // путь до архива
-	}, nil
+		YearMoth:    currentTime.Format(YearMonthLayout), // дата создания архива год месяц
+		ArchiveName: name,                                // имя создаваемого архива
+	}
+}
+
+// ArchiveTime восстанавливает время создания архива по имени папки год-месяц и имени архива,
+// которые формируются при создании архива, например "2024-03" и "23-10:34-nginx.zip".
+func ArchiveTime(yearMonth, archiveName string) (time.Time, error) {
+	prefix := len(DayTimeLayout)
+	if len(archiveName) <= prefix || archiveName[prefix] != '-' {
+		return time.Time{}, fmt.Errorf("имя %v не соответствует формату имени архива", archiveName)
+	}
+	t, err := time.ParseInLocation(YearMonthLayout+" "+DayTimeLayout, yearMonth+" "+archiveName[:prefix], time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("не удалось определить время создания архива %v: %v", archiveName, err)
+	}
+	return t, nil
+}
+
+// write записывает архив с указанным форматом в w, при необходимости шифруя его,
+// и заполняет в отчете размер и контрольную сумму записанных данных.
+func (c *Compress) write(format string, w io.Writer, report *CompressReport) error {
+	hw := newHashWriter(w)
+	c.entries = nil
+
+	var out io.Writer = hw
+	var encrypted io.WriteCloser
+	if c.Encryptor != nil {
+		var err error
+		encrypted, err = c.Encryptor.Encrypt(hw)
+		if err != nil {
+			return fmt.Errorf("ошибка при запуске шифрования архива: %v", err)
+		}
+		out = encrypted
+	}
+
+	var err error
+	if format == "zip" {
+		err = c.zipTo(out)
+	} else {
+		err = c.tarTo(format, out)
+	}
+	if err != nil {
+		return err
+	}
+
+	if encrypted != nil {
+		if err := encrypted.Close(); err != nil {
+			return fmt.Errorf("ошибка при завершении шифрования архива: %v", err)
+		}
+	}
+
+	report.Size = hw.size
+	report.SHA256, report.MD5 = hw.sums()
+	report.Entries = c.entries
+	return nil
+}
+
+// isSupported сообщает, поддерживается ли формат сжатия.
+func isSupported(format string) bool {
+	switch format {
+	case "zip", "tar", "tar.gz", "tar.zst", "tar.xz":
+		return true
+	}
+	return false
 }
 
 // ensureDirInOutputPath проверяет существует ли директория в с.OutputPath и если нет то создает ее
@@ -104,18 +231,80 @@ func (c *Compress) Zip() error {
 	}
 	defer file.Close()
 
+	if err := c.zipTo(file); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// zipTo записывает ZIP-архив в w.
+func (c *Compress) zipTo(w io.Writer) error {
 	// Создаем новый ZIP-архив
-	zipWriter := zip.NewWriter(file)
+	zipWriter := zip.NewWriter(w)
 	defer zipWriter.Close()
 
+	// Устанавливаем уровень сжатия deflate, если он задан
+	if c.Level != 0 {
+		if _, err := flate.NewWriter(io.Discard, c.Level); err != nil {
+			return fmt.Errorf("недопустимый уровень сжатия zip %v: %v", c.Level, err)
+		}
+		zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, c.Level)
+		})
+	}
+
+	// При нескольких потоках файлы сжимаются параллельно
+	if c.Workers > 1 {
+		if err := c.parallelZip(zipWriter); err != nil {
+			return err
+		}
+		return zipWriter.Close()
+	}
+
 	// Проходимся по директориям, которые нужно добавить в архив
+	err := c.walk(func(path, archivePath string, info fs.FileInfo) error {
+		// Добавляем файлы в архив
+		return c.addToZip(path, archivePath, info, zipWriter)
+	})
+	if err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+// walk обходит все элементы c.InputPaths, пропуская исключенные, и вызывает fn для каждого из них.
+// archivePath - путь элемента внутри архива, всегда начинается с имени входной директории.
+func (c *Compress) walk(fn func(path, archivePath string, info fs.FileInfo) error) error {
 	for _, inputPath := range c.InputPaths {
 		err := filepath.Walk(inputPath, func(path string, info fs.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			// Добавляем файлы в архив
-			return c.addToZip(path, info, zipWriter, inputPath)
+
+			// Исключаем файлы, которые должны быть исключены, вместе с содержимым исключенных директорий
+			exclude, err := c.isExcluded(info.Name())
+			if err != nil {
+				return err
+			}
+			if exclude {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			// Получаем относительный путь к файлу
+			relPath, err := filepath.Rel(inputPath, path)
+			if err != nil {
+				return fmt.Errorf("ошибка при получении относительного пути файла: %v", err)
+			}
+
+			// Определяем путь в архиве на основе относительного пути
+			archivePath := filepath.ToSlash(filepath.Join(filepath.Base(inputPath), relPath))
+
+			return fn(path, archivePath, info)
 		})
 
 		if err != nil {
@@ -127,43 +316,30 @@ func (c *Compress) Zip() error {
 }
 
 // addToZip добавляет файлы и директории в ZIP-архив.
-func (c *Compress) addToZip(path string, info fs.FileInfo, zw *zip.Writer, inputPath string) error {
-	// Получаем относительный путь к файлу
-	relPath, err := filepath.Rel(inputPath, path)
-	if err != nil {
-		return fmt.Errorf("ошибка при получении относительного пути файла: %v", err)
-	}
-
-	// Определяем путь в архиве на основе относительного пути
-	archivePath := filepath.Join(filepath.Base(inputPath), relPath)
-
-	// Исключаем файлы, которые должны быть исключены
-	exclude, err := c.isExcluded(info.Name())
-	if err != nil {
-		return err
-	}
-	if exclude {
-		return nil
-	}
-
+func (c *Compress) addToZip(path, archivePath string, info fs.FileInfo, zw *zip.Writer) error {
 	// Создаем запись в ZIP-архиве
 	var archiveWriter io.Writer
+	var err error
 	if info.IsDir() {
 		// Если это директория, добавляем "/" к пути в архиве
-		archivePath += "/"
-		archiveWriter, err = zw.Create(archivePath)
+		archiveWriter, err = zw.Create(archivePath + "/")
 	} else {
 		// Если это файл, создаем запись с путем в архиве
-		archiveWriter, err = zw.Create(filepath.ToSlash(archivePath))
+		archiveWriter, err = zw.Create(archivePath)
 	}
 	if err != nil {
 		return fmt.Errorf("ошибка при создании записи в архиве: %v", err)
 	}
 
 	// Если это не директория, копируем содержимое файла в архив
+	entry := newEntry(path, archivePath, info)
 	if !info.IsDir() {
-		return c.writeToArchive(archiveWriter, path)
+		entry.Size, entry.SHA256, err = c.writeToArchive(archiveWriter, path)
+		if err != nil {
+			return err
+		}
 	}
+	c.record(entry)
 
 	return nil
 }
@@ -183,30 +359,32 @@ func (c *Compress) isExcluded(name string) (bool, error) {
 	return false, nil
 }
 
-// writeToArchive заполняет архив содержимым файла, указанного в path.
-func (c *Compress) writeToArchive(ArchiveWriter io.Writer, path string) error {
+// writeToArchive заполняет архив содержимым файла, указанного в path,
+// и возвращает размер и SHA-256 записанного содержимого.
+func (c *Compress) writeToArchive(ArchiveWriter io.Writer, path string) (int64, string, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return 0, "", err
 	}
 	defer file.Close()
 
 	// Получаем информацию о файле
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("ошибка при получении информации о файле: %v", err)
+		return 0, "", fmt.Errorf("ошибка при получении информации о файле: %v", err)
 	}
 
 	// Пропускаем пустые файлы
 	if fileInfo.Size() == 0 {
-		return nil
+		return 0, emptySHA256, nil
 	}
 
 	// Копируем содержимое файла в архив
-	_, err = io.Copy(ArchiveWriter, file)
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(ArchiveWriter, hash), file)
 	if err != nil {
-		return fmt.Errorf("ошибка при копировании файла в архив: %v", err)
+		return 0, "", fmt.Errorf("ошибка при копировании файла в архив: %v", err)
 	}
 
-	return nil
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
 }
@@ -1,11 +1,23 @@
 package compress
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 func TestZip(t *testing.T) {
@@ -113,3 +125,352 @@ func TestIsExcluded(t *testing.T) {
 		}
 	}
 }
+
+func TestTarFormats(t *testing.T) {
+	tempDir := t.TempDir() // Создаем временную директорию
+
+	// Создаем структуру входной директории: файлы, исключенный файл, исключенная директория и символическая ссылка
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(filepath.Join(inputDir, "logs"), 0755); err != nil {
+		t.Fatalf("Ошибка при создании входной директории: %v", err)
+	}
+	files := map[string]string{
+		"nginx.conf":    "server {}",
+		"skip.log":      "исключенный файл",
+		"logs/old.conf": "файл в исключенной директории",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0640); err != nil {
+			t.Fatalf("Не удалось создать тестовый файл %s: %v", name, err)
+		}
+	}
+	if err := os.Symlink("nginx.conf", filepath.Join(inputDir, "link.conf")); err != nil {
+		t.Fatalf("Не удалось создать символическую ссылку: %v", err)
+	}
+
+	// Тестовые случаи
+	testCases := []struct {
+		format     string
+		decompress func(io.Reader) (io.Reader, error)
+	}{
+		{"tar", func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{"tar.gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"tar.zst", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{"tar.xz", func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) }},
+	}
+
+	for _, testCase := range testCases {
+		outputDir := filepath.Join(tempDir, testCase.format)
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			t.Fatalf("Ошибка при создании выходной директории: %v", err)
+		}
+
+		c := New()
+		c.ArchiveName = "unit"
+		c.InputPaths = []string{inputDir}
+		c.OutputPath = outputDir
+		c.ExludeFile = []string{"*.log", "logs"}
+
+		report, err := c.Start(testCase.format)
+		if err != nil {
+			t.Fatalf("Формат %v: не ожидалась ошибка, но она произошла: %v", testCase.format, err)
+		}
+		if !strings.HasSuffix(report.ArchiveName, "-unit."+testCase.format) {
+			t.Errorf("Формат %v: неверное имя архива %v", testCase.format, report.ArchiveName)
+		}
+
+		archive, err := os.Open(filepath.Join(report.ArchivePath, report.ArchiveName))
+		if err != nil {
+			t.Fatalf("Формат %v: не удалось открыть архив: %v", testCase.format, err)
+		}
+		defer archive.Close()
+
+		reader, err := testCase.decompress(archive)
+		if err != nil {
+			t.Fatalf("Формат %v: ошибка распаковки: %v", testCase.format, err)
+		}
+
+		// Читаем архив и сохраняем заголовки по именам
+		headers := map[string]*tar.Header{}
+		tr := tar.NewReader(reader)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Формат %v: ошибка чтения архива: %v", testCase.format, err)
+			}
+			headers[header.Name] = header
+		}
+
+		expect := []string{"input/", "input/nginx.conf", "input/link.conf"}
+		for _, name := range expect {
+			if _, ok := headers[name]; !ok {
+				t.Errorf("Формат %v: в архиве отсутствует %v", testCase.format, name)
+			}
+		}
+		if len(headers) != len(expect) {
+			t.Errorf("Формат %v: ожидалось %v записей, получено %v", testCase.format, len(expect), len(headers))
+		}
+		if header, ok := headers["input/nginx.conf"]; ok && header.FileInfo().Mode().Perm() != 0640 {
+			t.Errorf("Формат %v: права доступа не сохранены: %v", testCase.format, header.FileInfo().Mode().Perm())
+		}
+		if header, ok := headers["input/link.conf"]; ok && (header.Typeflag != tar.TypeSymlink || header.Linkname != "nginx.conf") {
+			t.Errorf("Формат %v: символическая ссылка не сохранена: %+v", testCase.format, header)
+		}
+	}
+}
+
+func TestParallelZip(t *testing.T) {
+	tempDir := t.TempDir() // Создаем временную директорию
+
+	// Создаем набор файлов разного размера, включая пустой файл и поддиректорию
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(filepath.Join(inputDir, "sub"), 0755); err != nil {
+		t.Fatalf("Ошибка при создании входной директории: %v", err)
+	}
+	contents := map[string]string{"empty.txt": ""}
+	for i := 0; i < 20; i++ {
+		name := filepath.Join("sub", "file"+strconv.Itoa(i)+".txt")
+		contents[filepath.ToSlash(name)] = strings.Repeat("строка "+strconv.Itoa(i)+"\n", i*100)
+	}
+	for name, content := range contents {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Не удалось создать тестовый файл %s: %v", name, err)
+		}
+	}
+
+	// Создаем два архива с одинаковыми параметрами, они должны совпадать побайтно
+	var archives [][]byte
+	for id := 0; id < 2; id++ {
+		outputDir := filepath.Join(tempDir, "output"+strconv.Itoa(id))
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			t.Fatalf("Ошибка при создании выходной директории: %v", err)
+		}
+
+		c := New()
+		c.ArchiveName = "unit"
+		c.InputPaths = []string{inputDir}
+		c.OutputPath = outputDir
+		c.Level = 9
+		c.Workers = 4
+		if err := c.Zip(); err != nil {
+			t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(outputDir, "unit.zip"))
+		if err != nil {
+			t.Fatalf("Не удалось прочитать архив: %v", err)
+		}
+		archives = append(archives, data)
+
+		// Во время сжатия не должно остаться временных файлов
+		items, err := os.ReadDir(outputDir)
+		if err != nil {
+			t.Fatalf("Не удалось прочитать выходную директорию: %v", err)
+		}
+		if len(items) != 1 {
+			t.Errorf("В выходной директории ожидался только архив, найдено %v элементов", len(items))
+		}
+	}
+	if !reflect.DeepEqual(archives[0], archives[1]) {
+		t.Errorf("Архивы, созданные с одинаковыми параметрами, отличаются")
+	}
+
+	// Проверяем содержимое архива
+	zr, err := zip.NewReader(bytes.NewReader(archives[0]), int64(len(archives[0])))
+	if err != nil {
+		t.Fatalf("Не удалось открыть архив: %v", err)
+	}
+	found := 0
+	for _, file := range zr.File {
+		name := strings.TrimPrefix(file.Name, "input/")
+		content, ok := contents[name]
+		if !ok {
+			continue
+		}
+		found++
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Не удалось открыть %v в архиве: %v", file.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("Не удалось прочитать %v из архива: %v", file.Name, err)
+		}
+		if string(data) != content {
+			t.Errorf("Содержимое %v в архиве не совпадает с исходным", file.Name)
+		}
+	}
+	if found != len(contents) {
+		t.Errorf("Ожидалось %v файлов в архиве, найдено %v", len(contents), found)
+	}
+}
+
+func TestZipExcludeDir(t *testing.T) {
+	tempDir := t.TempDir() // Создаем временную директорию
+
+	// Исключенная папка logs пропускается вместе с содержимым, даже если оно не подходит под шаблоны
+	inputDir := filepath.Join(tempDir, "input")
+	if err := os.MkdirAll(filepath.Join(inputDir, "logs", "nested"), 0755); err != nil {
+		t.Fatalf("Ошибка при создании входной директории: %v", err)
+	}
+	for _, name := range []string{"nginx.conf", "logs/old.conf", "logs/nested/site.conf"} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Не удалось создать тестовый файл %s: %v", name, err)
+		}
+	}
+
+	for _, workers := range []int{1, 4} {
+		c := New()
+		c.ArchiveName = "unit"
+		c.InputPaths = []string{inputDir}
+		c.OutputPath = t.TempDir()
+		c.ExludeFile = []string{"logs"}
+		c.Workers = workers
+
+		report, err := c.Start("zip")
+		if err != nil {
+			t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+		}
+		zr, err := zip.OpenReader(filepath.Join(report.ArchivePath, report.ArchiveName))
+		if err != nil {
+			t.Fatalf("Не удалось открыть архив: %v", err)
+		}
+		var names []string
+		for _, file := range zr.File {
+			names = append(names, file.Name)
+		}
+		zr.Close()
+
+		expect := []string{"input/", "input/nginx.conf"}
+		if !reflect.DeepEqual(names, expect) {
+			t.Errorf("Потоков %v: тест не пройден ожидалось: %v, полученно: %v", workers, expect, names)
+		}
+	}
+}
+
+// xorEncryptor - тестовое шифрование, инвертирующее биты данных.
+type xorEncryptor struct{}
+
+func (xorEncryptor) Encrypt(dst io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{writerFunc(func(p []byte) (int, error) {
+		out := make([]byte, len(p))
+		for i := range p {
+			out[i] = ^p[i]
+		}
+		return dst.Write(out)
+	})}, nil
+}
+
+func (xorEncryptor) Extension() string { return ".xor" }
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestStream(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "nginx.conf"), []byte("server {}"), 0644); err != nil {
+		t.Fatalf("Не удалось создать тестовый файл: %v", err)
+	}
+
+	c := New()
+	c.ArchiveName = "nginx"
+	c.InputPaths = []string{tempDir}
+	c.Encryptor = xorEncryptor{}
+
+	var buf bytes.Buffer
+	var opened *CompressReport
+	report, err := c.Stream("tar.gz", func(report *CompressReport) (io.Writer, error) {
+		opened = report
+		return &buf, nil
+	})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	// Имя архива должно быть известно до начала записи
+	if opened == nil || opened.ArchiveName != report.ArchiveName {
+		t.Fatalf("Писатель должен открываться с итоговым именем архива")
+	}
+	if !strings.HasSuffix(report.ArchiveName, "-nginx.tar.gz.xor") || report.ArchivePath != "" {
+		t.Errorf("Неверный отчет о потоковой архивации: %+v", report)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	md5sum := md5.Sum(buf.Bytes())
+	if report.Size != int64(buf.Len()) || report.SHA256 != hex.EncodeToString(sum[:]) || report.MD5 != hex.EncodeToString(md5sum[:]) {
+		t.Errorf("Размер или контрольная сумма в отчете не совпадают с записанными данными")
+	}
+
+	// Расшифровываем и проверяем, что внутри корректный gzip
+	plain := make([]byte, buf.Len())
+	for i, b := range buf.Bytes() {
+		plain[i] = ^b
+	}
+	if _, err := gzip.NewReader(bytes.NewReader(plain)); err != nil {
+		t.Errorf("Расшифрованный поток не является gzip: %v", err)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	inputDir := filepath.Join(t.TempDir(), "input")
+	if err := os.MkdirAll(filepath.Join(inputDir, "conf.d"), 0755); err != nil {
+		t.Fatalf("Ошибка при создании входной директории: %v", err)
+	}
+	files := map[string]string{
+		"nginx.conf":        "server {}",
+		"conf.d/site.conf":  "location / {}",
+		"conf.d/empty.conf": "",
+		"conf.d/skip.log":   "исключенный файл",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0640); err != nil {
+			t.Fatalf("Не удалось создать тестовый файл %s: %v", name, err)
+		}
+	}
+
+	// Для каждого формата и режима сжатия список элементов должен быть одинаковым
+	testCases := []struct {
+		format  string
+		workers int
+	}{
+		{"zip", 1},
+		{"zip", 4},
+		{"tar.gz", 1},
+	}
+	for _, testCase := range testCases {
+		c := New()
+		c.ArchiveName = "unit"
+		c.InputPaths = []string{inputDir}
+		c.OutputPath = t.TempDir()
+		c.ExludeFile = []string{"*.log"}
+		c.Workers = testCase.workers
+
+		report, err := c.Start(testCase.format)
+		if err != nil {
+			t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+		}
+
+		got := map[string]Entry{}
+		for _, entry := range report.Entries {
+			got[entry.Path] = entry
+		}
+		if len(got) != 5 {
+			t.Errorf("%v: неверный список элементов архива: %+v", testCase.format, report.Entries)
+		}
+		if !got["input/conf.d"].Mode.IsDir() {
+			t.Errorf("%v: папка conf.d должна быть описана как папка: %+v", testCase.format, got["input/conf.d"])
+		}
+		for _, name := range []string{"nginx.conf", "conf.d/site.conf", "conf.d/empty.conf"} {
+			sum := sha256.Sum256([]byte(files[name]))
+			entry := got["input/"+name]
+			if entry.Size != int64(len(files[name])) || entry.SHA256 != hex.EncodeToString(sum[:]) || entry.Mode.Perm() != 0640 {
+				t.Errorf("%v: неверное описание файла %v: %+v", testCase.format, name, entry)
+			}
+		}
+	}
+}
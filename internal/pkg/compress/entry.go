@@ -0,0 +1,37 @@
+package compress
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Entry описывает элемент архива: файл, папку или символическую ссылку.
+// Список элементов попадает в отчет о сжатии и из него формируется манифест архива.
+type Entry struct {
+	Path    string      `json:"path"`             // Путь внутри архива, начинается с имени входной директории
+	Size    int64       `json:"size"`             // Размер записанного содержимого в байтах
+	Mode    fs.FileMode `json:"mode"`             // Тип и права доступа
+	ModTime time.Time   `json:"mtime"`            // Время изменения
+	SHA256  string      `json:"sha256,omitempty"` // SHA-256 записанного содержимого, только для файлов
+	Link    string      `json:"link,omitempty"`   // Путь, на который указывает символическая ссылка
+}
+
+// emptySHA256 - SHA-256 пустого файла.
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// newEntry создает описание элемента path с путем archivePath внутри архива по информации о нем.
+func newEntry(path, archivePath string, info fs.FileInfo) Entry {
+	entry := Entry{Path: archivePath, Mode: info.Mode(), ModTime: info.ModTime()}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		entry.Link, _ = os.Readlink(path)
+	}
+	return entry
+}
+
+// record добавляет элемент в список записанных элементов архива.
+func (c *Compress) record(entry Entry) {
+	c.entries = append(c.entries, entry)
+}
@@ -0,0 +1,429 @@
+package compress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
+)
+
+// Системы, создавшие zip архив, для которых в нем сохраняются права доступа Unix.
+const (
+	zipCreatorUnix   = 3
+	zipCreatorMacOSX = 19
+)
+
+// ExtractOptions содержит параметры распаковки архива.
+type ExtractOptions struct {
+	Force bool     // Перезаписывать существующие файлы, иначе распаковка не начинается, если хотя бы один файл уже есть
+	Paths []string // Шаблоны путей элементов для распаковки, пустой список - весь архив, см. MatchPath
+}
+
+// walkFunc вызывается для каждого элемента архива. Функция open открывает содержимое элемента
+// и должна вызываться до перехода к следующему элементу.
+type walkFunc func(entry Entry, open func() (io.ReadCloser, error)) error
+
+// FormatOf определяет формат сжатия по имени архива, например "03-13:37-nginx.tar.gz" - "tar.gz".
+// Расширение шифрования должно быть удалено из имени заранее.
+func FormatOf(name string) (string, error) {
+	for _, format := range []string{"tar.gz", "tar.zst", "tar.xz", "tar", "zip"} {
+		if strings.HasSuffix(name, "."+format) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("не удалось определить формат архива %v", name)
+}
+
+// MatchPath сообщает, подходит ли элемент архива name под один из шаблонов patterns в синтаксисе path.Match.
+// Шаблон выбирает элемент, если совпадает с его путем или путем одной из его папок, поэтому шаблон папки
+// выбирает все ее содержимое. Шаблон без "/" сравнивается также с именами элементов и папок на любой глубине:
+// "nginx.conf" выбирает "nginx/nginx.conf". Пустой список шаблонов выбирает все элементы.
+func MatchPath(patterns []string, name string) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+	name = strings.Trim(name, "/")
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		base := !strings.Contains(pattern, "/")
+		for dir := name; dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+			subject := dir
+			if base {
+				subject = path.Base(dir)
+			}
+			matched, err := path.Match(pattern, subject)
+			if err != nil {
+				return false, fmt.Errorf("некорректный шаблон пути %q: %v", pattern, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// List возвращает элементы архива src формата format. Для zip читается только центральный каталог,
+// tar-архив читается целиком без распаковки файлов на диск.
+func List(src, format string) ([]Entry, error) {
+	return list(func(fn walkFunc) error { return readArchive(src, format, fn) })
+}
+
+// ListSums возвращает элементы архива src формата format, как List, с SHA-256 содержимого файлов.
+// Архив читается целиком.
+func ListSums(src, format string) ([]Entry, error) {
+	var entries []Entry
+	err := readArchive(src, format, func(entry Entry, open func() (io.ReadCloser, error)) error {
+		if entry.Mode.IsRegular() {
+			r, err := open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			hash := sha256.New()
+			if _, err := io.Copy(hash, r); err != nil {
+				return fmt.Errorf("ошибка чтения %v: %v", entry.Path, err)
+			}
+			entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// ListZip возвращает элементы ZIP-архива размером size, читая из r только центральный каталог.
+func ListZip(r io.ReaderAt, size int64) ([]Entry, error) {
+	return list(func(fn walkFunc) error { return readZipAt(r, size, fn) })
+}
+
+// list возвращает элементы архива, перечисляемые функцией read.
+func list(read func(fn walkFunc) error) ([]Entry, error) {
+	var entries []Entry
+	err := read(func(entry Entry, _ func() (io.ReadCloser, error)) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// Extract распаковывает архив src формата format в папку target и возвращает распакованные элементы
+// с SHA-256 содержимого файлов. Права доступа и время изменения восстанавливаются, если они сохранены
+// в архиве. Элементы с путями вне target и файлы, которые пришлось бы записывать через символические
+// ссылки, не распаковываются.
+func Extract(src, format, target string, opts ExtractOptions) ([]Entry, error) {
+	if !isSupported(format) {
+		return nil, fmt.Errorf("формат сжатия ' %v ' не поддерживается", format)
+	}
+	return extract(func(fn walkFunc) error { return readArchive(src, format, fn) }, target, opts)
+}
+
+// ExtractZip распаковывает в папку target ZIP-архив размером size, читая его из r, как Extract.
+// Из r читаются только центральный каталог и выбранные opts.Paths файлы, поэтому r может
+// читать архив по частям прямо из удаленного хранилища.
+func ExtractZip(r io.ReaderAt, size int64, target string, opts ExtractOptions) ([]Entry, error) {
+	return extract(func(fn walkFunc) error { return readZipAt(r, size, fn) }, target, opts)
+}
+
+// extract распаковывает в папку target элементы архива, перечисляемые функцией read.
+func extract(read func(fn walkFunc) error, target string, opts ExtractOptions) ([]Entry, error) {
+	for _, pattern := range opts.Paths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("некорректный шаблон пути %q: %v", pattern, err)
+		}
+	}
+	if !opts.Force {
+		if err := checkConflicts(read, target, opts.Paths); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, fmt.Errorf("не удалось создать папку %v: %v", target, err)
+	}
+
+	var entries []Entry
+	var dirs []Entry
+	err := read(func(entry Entry, open func() (io.ReadCloser, error)) error {
+		if matched, _ := MatchPath(opts.Paths, entry.Path); !matched {
+			return nil
+		}
+		path, err := targetPath(target, entry.Path)
+		if err != nil {
+			return err
+		}
+		if err := extractEntry(path, &entry, open, opts); err != nil {
+			return fmt.Errorf("ошибка распаковки %v: %v", entry.Path, err)
+		}
+		if entry.Mode.IsDir() {
+			dirs = append(dirs, entry)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return entries, err
+	}
+	if len(opts.Paths) > 0 && len(entries) == 0 {
+		return nil, fmt.Errorf("в архиве нет элементов, подходящих под шаблоны %v", strings.Join(opts.Paths, ", "))
+	}
+
+	// Права и время изменения папок восстанавливаем в конце: запись файлов в папку меняет время,
+	// а папка без права записи не дала бы распаковать в нее файлы
+	for _, dir := range dirs {
+		path, _ := targetPath(target, dir.Path)
+		if err := os.Chmod(path, dir.Mode.Perm()); err != nil {
+			return entries, fmt.Errorf("ошибка распаковки %v: %v", dir.Path, err)
+		}
+		setModTime(path, dir.ModTime)
+	}
+	return entries, nil
+}
+
+// checkConflicts возвращает ошибку, если какой-либо выбранный шаблонами paths файл архива уже существует в target.
+func checkConflicts(read func(fn walkFunc) error, target string, paths []string) error {
+	entries, err := list(read)
+	if err != nil {
+		return err
+	}
+	var conflicts []string
+	for _, entry := range entries {
+		if matched, _ := MatchPath(paths, entry.Path); !matched {
+			continue
+		}
+		path, err := targetPath(target, entry.Path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(path)
+		if err != nil || (info.IsDir() && entry.Mode.IsDir()) {
+			continue
+		}
+		conflicts = append(conflicts, path)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	if len(conflicts) > 5 {
+		conflicts = append(conflicts[:5], fmt.Sprintf("и еще %v", len(conflicts)-5))
+	}
+	return fmt.Errorf("файлы уже существуют, перезапись не разрешена: %v", strings.Join(conflicts, ", "))
+}
+
+// targetPath возвращает путь в папке target для элемента архива name и проверяет, что он не выходит за target.
+func targetPath(target, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("путь %v в архиве выходит за папку восстановления", name)
+	}
+	return filepath.Join(target, clean), nil
+}
+
+// extractEntry создает на диске по пути path элемент архива entry. Для файлов в entry записывается SHA-256 содержимого.
+func extractEntry(path string, entry *Entry, open func() (io.ReadCloser, error), opts ExtractOptions) error {
+	if err := checkParents(path, entry.Path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	switch {
+	case entry.Mode.IsDir():
+		if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("папка %v является символической ссылкой", path)
+		}
+		return os.MkdirAll(path, 0755)
+
+	case entry.Mode&fs.ModeSymlink != 0:
+		if opts.Force {
+			os.Remove(path)
+		}
+		return os.Symlink(entry.Link, path)
+
+	case entry.Mode.IsRegular():
+		if opts.Force {
+			// Удаляем прежний файл, чтобы не записать данные через символическую ссылку на его месте
+			if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+				os.Remove(path)
+			}
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, entry.Mode.Perm())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		r, err := open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(file, hash), r); err != nil {
+			return err
+		}
+		entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		if err := file.Close(); err != nil {
+			return err
+		}
+		// Права задаем явно, так как при создании файла на них действует umask
+		if err := os.Chmod(path, entry.Mode.Perm()); err != nil {
+			return err
+		}
+		setModTime(path, entry.ModTime)
+	}
+
+	// Устройства и именованные каналы не восстанавливаются
+	return nil
+}
+
+// checkParents проверяет, что ни одна из уже существующих папок на пути к path внутри папки восстановления
+// не является символической ссылкой: иначе архив мог бы записать файл за пределы папки восстановления.
+func checkParents(path, name string) error {
+	dir := filepath.Dir(path)
+	for i := strings.Count(filepath.Clean(filepath.FromSlash(name)), string(filepath.Separator)); i > 0; i-- {
+		if info, err := os.Lstat(dir); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("папка %v является символической ссылкой", dir)
+		}
+		dir = filepath.Dir(dir)
+	}
+	return nil
+}
+
+// setModTime восстанавливает время изменения, если оно сохранено в архиве.
+func setModTime(path string, modTime time.Time) {
+	if modTime.IsZero() || modTime.Year() < 1981 {
+		return
+	}
+	os.Chtimes(path, modTime, modTime)
+}
+
+// readArchive вызывает fn для каждого элемента архива src формата format.
+func readArchive(src, format string, fn walkFunc) error {
+	if format == "zip" {
+		return readZip(src, fn)
+	}
+	if !isSupported(format) {
+		return fmt.Errorf("формат сжатия ' %v ' не поддерживается", format)
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть архив: %v", err)
+	}
+	defer file.Close()
+
+	decompressor, err := newDecompressor(format, file)
+	if err != nil {
+		return fmt.Errorf("ошибка при чтении архива %v: %v", format, err)
+	}
+	defer decompressor.Close()
+
+	tr := tar.NewReader(decompressor)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка чтения tar архива: %v", err)
+		}
+		entry := Entry{
+			Path:    strings.TrimSuffix(header.Name, "/"),
+			Size:    header.Size,
+			Mode:    header.FileInfo().Mode(),
+			ModTime: header.ModTime,
+			Link:    header.Linkname,
+		}
+		// Жесткие ссылки KronosKeeper не создает, такие элементы не восстанавливаются
+		if header.Typeflag == tar.TypeLink {
+			entry.Mode = fs.ModeIrregular | entry.Mode.Perm()
+		}
+		err = fn(entry, func() (io.ReadCloser, error) { return io.NopCloser(tr), nil })
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readZip вызывает fn для каждого элемента ZIP-архива src по его центральному каталогу.
+func readZip(src string, fn walkFunc) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть zip архив: %v", err)
+	}
+	defer zr.Close()
+	return walkZip(&zr.Reader, fn)
+}
+
+// readZipAt вызывает fn для каждого элемента ZIP-архива размером size, читаемого из r.
+func readZipAt(r io.ReaderAt, size int64, fn walkFunc) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть zip архив: %v", err)
+	}
+	return walkZip(zr, fn)
+}
+
+// walkZip вызывает fn для каждого элемента центрального каталога ZIP-архива.
+func walkZip(zr *zip.Reader, fn walkFunc) error {
+	for _, file := range zr.File {
+		if err := fn(zipFileEntry(file), file.Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipFileEntry описывает элемент ZIP-архива. KronosKeeper не сохраняет в zip права доступа Unix,
+// поэтому для таких архивов используются права 0644 для файлов и 0755 для папок.
+func zipFileEntry(file *zip.File) Entry {
+	mode := file.Mode()
+	if creator := file.CreatorVersion >> 8; creator != zipCreatorUnix && creator != zipCreatorMacOSX {
+		mode = mode.Type() | 0644
+		if mode.IsDir() {
+			mode = mode.Type() | 0755
+		}
+	}
+	return Entry{
+		Path:    strings.TrimSuffix(file.Name, "/"),
+		Size:    int64(file.UncompressedSize64),
+		Mode:    mode,
+		ModTime: file.Modified,
+	}
+}
+
+// newDecompressor создает распаковщик tar-формата поверх r.
+func newDecompressor(format string, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case "tar":
+		return io.NopCloser(r), nil
+	case "tar.gz":
+		return pgzip.NewReader(r)
+	case "tar.zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case "tar.xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	}
+	return nil, fmt.Errorf("формат сжатия ' %v ' не поддерживается", format)
+}
@@ -0,0 +1,202 @@
+package compress
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	inputDir := filepath.Join(t.TempDir(), "nginx")
+	if err := os.MkdirAll(filepath.Join(inputDir, "conf.d"), 0755); err != nil {
+		t.Fatalf("Ошибка при создании входной директории: %v", err)
+	}
+	files := map[string]string{
+		"nginx.conf":        "server {}",
+		"conf.d/site.conf":  "location / {}",
+		"conf.d/empty.conf": "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0640); err != nil {
+			t.Fatalf("Не удалось создать тестовый файл %s: %v", name, err)
+		}
+	}
+
+	for _, format := range []string{"zip", "tar", "tar.gz", "tar.zst", "tar.xz"} {
+		c := New()
+		c.ArchiveName = "nginx"
+		c.InputPaths = []string{inputDir}
+		c.OutputPath = t.TempDir()
+		report, err := c.Start(format)
+		if err != nil {
+			t.Fatalf("%v: ошибка создания архива: %v", format, err)
+		}
+		archive := filepath.Join(report.ArchivePath, report.ArchiveName)
+		if detected, err := FormatOf(report.ArchiveName); err != nil || detected != format {
+			t.Errorf("Тест не пройден ожидалось: %v, полученно: %v, %v", format, detected, err)
+		}
+
+		entries, err := List(archive, format)
+		if err != nil || len(entries) != 5 {
+			t.Errorf("%v: неверный список элементов архива: %+v, %v", format, entries, err)
+		}
+
+		sums, err := ListSums(archive, format)
+		if err != nil {
+			t.Fatalf("%v: ошибка чтения архива: %v", format, err)
+		}
+		for i, entry := range sums {
+			if entry.SHA256 != report.Entries[i].SHA256 {
+				t.Errorf("%v: %v: тест не пройден ожидалось: %v, полученно: %v", format, entry.Path, report.Entries[i].SHA256, entry.SHA256)
+			}
+		}
+
+		target := t.TempDir()
+		if _, err := Extract(archive, format, target, ExtractOptions{}); err != nil {
+			t.Fatalf("%v: ошибка распаковки: %v", format, err)
+		}
+		for name, content := range files {
+			data, err := os.ReadFile(filepath.Join(target, "nginx", name))
+			if err != nil || string(data) != content {
+				t.Errorf("%v: неверное содержимое %v: %q, %v", format, name, data, err)
+			}
+		}
+		// Tar сохраняет права доступа, zip распаковывается с правами по умолчанию
+		info, err := os.Stat(filepath.Join(target, "nginx", "nginx.conf"))
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		expectPerm := os.FileMode(0640)
+		if format == "zip" {
+			expectPerm = 0644
+		}
+		if info.Mode().Perm() != expectPerm {
+			t.Errorf("%v: тест не пройден ожидалось: %v, полученно: %v", format, expectPerm, info.Mode().Perm())
+		}
+
+		// Существующие файлы не перезаписываются без Force
+		if err := os.WriteFile(filepath.Join(target, "nginx", "nginx.conf"), []byte("changed"), 0644); err != nil {
+			t.Fatalf("Ошибка при изменении файла: %v", err)
+		}
+		if _, err := Extract(archive, format, target, ExtractOptions{}); err == nil {
+			t.Errorf("%v: ожидалась ошибка перезаписи существующих файлов", format)
+		}
+		if data, _ := os.ReadFile(filepath.Join(target, "nginx", "nginx.conf")); string(data) != "changed" {
+			t.Errorf("%v: файл не должен был быть перезаписан: %q", format, data)
+		}
+		if _, err := Extract(archive, format, target, ExtractOptions{Force: true}); err != nil {
+			t.Fatalf("%v: ошибка распаковки с перезаписью: %v", format, err)
+		}
+		if data, _ := os.ReadFile(filepath.Join(target, "nginx", "nginx.conf")); string(data) != files["nginx.conf"] {
+			t.Errorf("%v: файл должен был быть перезаписан: %q", format, data)
+		}
+	}
+}
+
+func TestExtractOutsideTarget(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "evil.zip")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("Ошибка при создании архива: %v", err)
+	}
+	zw := zip.NewWriter(file)
+	w, _ := zw.Create("../evil.conf")
+	w.Write([]byte("evil"))
+	zw.Close()
+	file.Close()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "restore")
+	if _, err := Extract(archive, "zip", target, ExtractOptions{}); err == nil || !strings.Contains(err.Error(), "выходит за папку") {
+		t.Errorf("Ожидалась ошибка пути вне папки восстановления, полученно: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.conf")); !os.IsNotExist(err) {
+		t.Errorf("Файл не должен был быть записан вне папки восстановления")
+	}
+}
+
+func TestExtractPaths(t *testing.T) {
+	inputDir := filepath.Join(t.TempDir(), "nginx")
+	if err := os.MkdirAll(filepath.Join(inputDir, "conf.d"), 0755); err != nil {
+		t.Fatalf("Ошибка при создании входной директории: %v", err)
+	}
+	for name, content := range map[string]string{"nginx.conf": "server {}", "conf.d/site.conf": "location / {}", "mime.types": "types {}"} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Не удалось создать тестовый файл %s: %v", name, err)
+		}
+	}
+	c := New()
+	c.ArchiveName = "nginx"
+	c.InputPaths = []string{inputDir}
+	c.OutputPath = t.TempDir()
+	report, err := c.Start("zip")
+	if err != nil {
+		t.Fatalf("Ошибка создания архива: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(report.ArchivePath, report.ArchiveName))
+	if err != nil {
+		t.Fatalf("Ошибка чтения архива: %v", err)
+	}
+
+	if entries, err := ListZip(bytes.NewReader(data), int64(len(data))); err != nil || len(entries) != 5 {
+		t.Errorf("Неверный список элементов архива: %+v, %v", entries, err)
+	}
+
+	// Распаковывается только выбранный файл, с SHA-256 содержимого
+	target := t.TempDir()
+	entries, err := ExtractZip(bytes.NewReader(data), int64(len(data)), target, ExtractOptions{Paths: []string{"nginx.conf"}})
+	if err != nil {
+		t.Fatalf("Ошибка распаковки: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "nginx/nginx.conf" {
+		t.Fatalf("Неверные распакованные элементы: %+v", entries)
+	}
+	for _, entry := range report.Entries {
+		if entry.Path == "nginx/nginx.conf" && entry.SHA256 != entries[0].SHA256 {
+			t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", entry.SHA256, entries[0].SHA256)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(target, "nginx", "mime.types")); !os.IsNotExist(err) {
+		t.Errorf("Файл mime.types не должен был быть распакован")
+	}
+
+	// Шаблон папки выбирает ее содержимое, а существующие невыбранные файлы не мешают распаковке
+	entries, err = ExtractZip(bytes.NewReader(data), int64(len(data)), target, ExtractOptions{Paths: []string{"nginx/conf.d"}})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("Неверные распакованные элементы: %+v, %v", entries, err)
+	}
+	if _, err := ExtractZip(bytes.NewReader(data), int64(len(data)), target, ExtractOptions{Paths: []string{"*.log"}}); err == nil {
+		t.Errorf("Ожидалась ошибка: в архиве нет элементов под шаблон")
+	}
+	if _, err := ExtractZip(bytes.NewReader(data), int64(len(data)), target, ExtractOptions{Paths: []string{"[conf"}}); err == nil {
+		t.Errorf("Ожидалась ошибка некорректного шаблона")
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	testCases := []struct {
+		patterns []string
+		name     string
+		expect   bool
+	}{
+		{nil, "nginx/nginx.conf", true},
+		{[]string{"nginx.conf"}, "nginx/nginx.conf", true},
+		{[]string{"*.conf"}, "nginx/conf.d/site.conf", true},
+		{[]string{"nginx/*.conf"}, "nginx/nginx.conf", true},
+		{[]string{"nginx/*.conf"}, "nginx/conf.d/site.conf", false},
+		{[]string{"nginx/conf.d"}, "nginx/conf.d/site.conf", true},
+		{[]string{"/nginx/conf.d/"}, "nginx/conf.d/site.conf", true},
+		{[]string{"conf.d"}, "nginx/conf.d/site.conf", true},
+		{[]string{"site.conf"}, "nginx/conf.d", false},
+		{[]string{"*.log", "mime.types"}, "nginx/mime.types", true},
+	}
+	for _, tc := range testCases {
+		got, err := MatchPath(tc.patterns, tc.name)
+		if err != nil || got != tc.expect {
+			t.Errorf("%v, %v: тест не пройден ожидалось: %v, полученно: %v, %v", tc.patterns, tc.name, tc.expect, got, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package compress
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// hashWriter передает данные в w, подсчитывая их размер и контрольные суммы SHA-256 и MD5.
+// MD5 нужен для сверки с контрольными суммами, которые сообщают удаленные хранилища.
+type hashWriter struct {
+	w      io.Writer
+	sha256 hash.Hash
+	md5    hash.Hash
+	size   int64
+}
+
+// newHashWriter создает hashWriter поверх w.
+func newHashWriter(w io.Writer) *hashWriter {
+	return &hashWriter{w: w, sha256: sha256.New(), md5: md5.New()}
+}
+
+func (hw *hashWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.sha256.Write(p[:n])
+	hw.md5.Write(p[:n])
+	hw.size += int64(n)
+	return n, err
+}
+
+// sums возвращает контрольные суммы SHA-256 и MD5 записанных данных в шестнадцатеричном виде.
+func (hw *hashWriter) sums() (string, string) {
+	return hex.EncodeToString(hw.sha256.Sum(nil)), hex.EncodeToString(hw.md5.Sum(nil))
+}
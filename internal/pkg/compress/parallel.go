@@ -0,0 +1,199 @@
+package compress
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// tempPattern - шаблон имени временных файлов параллельного сжатия.
+const tempPattern = ".kk-*.deflate"
+
+// IsTempFile проверяет, что name - временный файл параллельного сжатия. Такие файлы создаются
+// в системной временной папке, но прежние версии создавали их рядом с архивом, и после сбоя
+// они могли остаться в папке год-месяц.
+func IsTempFile(name string) bool {
+	matched, _ := filepath.Match(tempPattern, name)
+	return matched
+}
+
+// zipEntry описывает элемент, который нужно добавить в ZIP-архив.
+type zipEntry struct {
+	path        string      // Путь к файлу на диске
+	archivePath string      // Путь внутри архива
+	info        fs.FileInfo // Информация о файле
+}
+
+// zipResult содержит результат сжатия одного файла во временный файл.
+type zipResult struct {
+	tmp    *os.File // Временный файл со сжатыми данными deflate
+	crc32  uint32   // Контрольная сумма несжатых данных
+	size   uint64   // Размер несжатых данных
+	packed uint64   // Размер сжатых данных
+	sha256 string   // SHA-256 несжатых данных
+	err    error
+}
+
+// parallelZip сжимает файлы в c.Workers потоков и записывает их в zw в порядке обхода директорий,
+// поэтому содержимое архива не зависит от количества потоков.
+func (c *Compress) parallelZip(zw *zip.Writer) error {
+	// Собираем список элементов заранее, чтобы зафиксировать порядок записи
+	var entries []zipEntry
+	err := c.walk(func(path, archivePath string, info fs.FileInfo) error {
+		entries = append(entries, zipEntry{path: path, archivePath: archivePath, info: info})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	results := make([]chan zipResult, len(entries))
+	for i := range results {
+		results[i] = make(chan zipResult, 1)
+	}
+
+	// sem ограничивает количество одновременно сжимаемых и ожидающих записи файлов
+	sem := make(chan struct{}, c.Workers)
+	done := make(chan struct{})
+	produced := make(chan struct{})
+	var wg sync.WaitGroup
+
+	go func() {
+		defer close(produced)
+		for i, entry := range entries {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+			if !entry.info.Mode().IsRegular() || entry.info.Size() == 0 {
+				results[i] <- zipResult{}
+				continue
+			}
+			wg.Add(1)
+			go func(i int, entry zipEntry) {
+				defer wg.Done()
+				results[i] <- c.deflateToTemp(entry.path)
+			}(i, entry)
+		}
+	}()
+
+	for i, entry := range entries {
+		result := <-results[i]
+		err := result.err
+		if err == nil {
+			err = c.writeZipResult(zw, entry, result)
+		}
+		<-sem
+		if err != nil {
+			close(done)
+			<-produced
+			c.cleanupResults(&wg, results[i+1:])
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeZipResult записывает в архив уже сжатый файл или создает запись для директории и пустого файла.
+func (c *Compress) writeZipResult(zw *zip.Writer, entry zipEntry, result zipResult) error {
+	if result.tmp == nil {
+		return c.addToZip(entry.path, entry.archivePath, entry.info, zw)
+	}
+	defer os.Remove(result.tmp.Name())
+	defer result.tmp.Close()
+
+	header := &zip.FileHeader{
+		Name:               entry.archivePath,
+		Method:             zip.Deflate,
+		CRC32:              result.crc32,
+		UncompressedSize64: result.size,
+		CompressedSize64:   result.packed,
+	}
+	archiveWriter, err := zw.CreateRaw(header)
+	if err != nil {
+		return fmt.Errorf("ошибка при создании записи в архиве: %v", err)
+	}
+	if _, err := result.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(archiveWriter, result.tmp); err != nil {
+		return fmt.Errorf("ошибка при копировании файла в архив: %v", err)
+	}
+
+	record := newEntry(entry.path, entry.archivePath, entry.info)
+	record.Size, record.SHA256 = int64(result.size), result.sha256
+	c.record(record)
+	return nil
+}
+
+// deflateToTemp сжимает файл path во временный файл в системной временной папке, чтобы после сбоя
+// он не остался в папке с архивами.
+func (c *Compress) deflateToTemp(path string) (result zipResult) {
+	file, err := os.Open(path)
+	if err != nil {
+		return zipResult{err: err}
+	}
+	defer file.Close()
+
+	tmp, err := os.CreateTemp("", tempPattern)
+	if err != nil {
+		return zipResult{err: fmt.Errorf("ошибка при создании временного файла: %v", err)}
+	}
+	defer func() {
+		if result.err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	level := c.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	fw, err := flate.NewWriter(tmp, level)
+	if err != nil {
+		return zipResult{err: fmt.Errorf("недопустимый уровень сжатия zip %v: %v", c.Level, err)}
+	}
+
+	crc := crc32.NewIEEE()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(fw, crc, hash), file)
+	if err != nil {
+		return zipResult{err: fmt.Errorf("ошибка при сжатии файла %v: %v", path, err)}
+	}
+	if err := fw.Close(); err != nil {
+		return zipResult{err: fmt.Errorf("ошибка при сжатии файла %v: %v", path, err)}
+	}
+
+	packed, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return zipResult{err: err}
+	}
+
+	return zipResult{tmp: tmp, crc32: crc.Sum32(), size: uint64(size), packed: uint64(packed), sha256: hex.EncodeToString(hash.Sum(nil))}
+}
+
+// cleanupResults дожидается завершения уже запущенных потоков и удаляет их временные файлы.
+func (c *Compress) cleanupResults(wg *sync.WaitGroup, results []chan zipResult) {
+	wg.Wait()
+	for _, ch := range results {
+		select {
+		case result := <-ch:
+			if result.tmp != nil {
+				result.tmp.Close()
+				os.Remove(result.tmp.Name())
+			}
+		default:
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package compress
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
+)
+
+// nopWriteCloser оборачивает io.Writer, не выполняя никаких действий при закрытии.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Tar выполняет архивацию в формате TAR без сжатия.
+func (c *Compress) Tar() error {
+	return c.tarFile("tar")
+}
+
+// TarGz выполняет архивацию в формате TAR со сжатием gzip.
+func (c *Compress) TarGz() error {
+	return c.tarFile("tar.gz")
+}
+
+// TarZst выполняет архивацию в формате TAR со сжатием zstd.
+func (c *Compress) TarZst() error {
+	return c.tarFile("tar.zst")
+}
+
+// TarXz выполняет архивацию в формате TAR со сжатием xz.
+func (c *Compress) TarXz() error {
+	return c.tarFile("tar.xz")
+}
+
+// pgzipBlockSize - размер блока для параллельного сжатия gzip.
+const pgzipBlockSize = 1 << 20
+
+// xzDictCaps сопоставляет уровень сжатия xz с размером словаря.
+var xzDictCaps = map[int]int{
+	1: 1 << 20, 2: 2 << 20, 3: 4 << 20, 4: 4 << 20, 5: 8 << 20,
+	6: 8 << 20, 7: 16 << 20, 8: 32 << 20, 9: 64 << 20,
+}
+
+// newCompressor создает компрессор для tar-формата поверх w с учетом c.Level и c.Workers.
+func (c *Compress) newCompressor(format string, w io.Writer) (io.WriteCloser, error) {
+	switch format {
+	case "tar":
+		return nopWriteCloser{w}, nil
+
+	case "tar.gz":
+		// При нескольких потоках поток сжимается блоками параллельно, результат остается обычным gzip.
+		level := c.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if c.Workers <= 1 {
+			return gzip.NewWriterLevel(w, level)
+		}
+		gw, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		if err := gw.SetConcurrency(pgzipBlockSize, c.Workers); err != nil {
+			return nil, err
+		}
+		return gw, nil
+
+	case "tar.zst":
+		// Уровень задается по шкале zstd (1-22) и приводится к ближайшему уровню кодировщика.
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(max(c.Workers, 1))}
+		if c.Level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)))
+		}
+		return zstd.NewWriter(w, options...)
+
+	case "tar.xz":
+		// Уровень (1-9) определяет размер словаря так же, как пресеты утилиты xz; сжатие выполняется в одном потоке.
+		config := xz.WriterConfig{}
+		if c.Level != 0 {
+			dictCap, ok := xzDictCaps[c.Level]
+			if !ok {
+				return nil, fmt.Errorf("недопустимый уровень сжатия xz %v", c.Level)
+			}
+			config.DictCap = dictCap
+		}
+		return config.NewWriter(w)
+	}
+
+	return nil, fmt.Errorf("формат сжатия ' %v ' не поддерживается", format)
+}
+
+// tarFile создает файл TAR-архива с расширением format в c.OutputPath.
+func (c *Compress) tarFile(format string) error {
+	if c.ArchiveName == "" || len(c.InputPaths) == 0 || c.OutputPath == "" {
+		return fmt.Errorf("недостаточно параметров для запуска архивации")
+	}
+	// Формируем путь и имя архива
+	archivePath := filepath.Join(c.OutputPath, c.ArchiveName+"."+format)
+
+	// Создаем файл для записи архива
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := c.tarTo(format, file); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// tarTo записывает в w TAR-архив, сжатый компрессором формата format.
+func (c *Compress) tarTo(format string, w io.Writer) error {
+	compressor, err := c.newCompressor(format, w)
+	if err != nil {
+		return fmt.Errorf("ошибка при создании компрессора %v: %v", format, err)
+	}
+	tarWriter := tar.NewWriter(compressor)
+
+	// Проходимся по директориям, которые нужно добавить в архив
+	err = c.walk(func(path, archivePath string, info fs.FileInfo) error {
+		return c.addToTar(path, archivePath, info, tarWriter)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Закрываем писателей по порядку, чтобы дописать хвосты tar и компрессора
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("ошибка при завершении tar архива: %v", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("ошибка при завершении сжатия %v: %v", format, err)
+	}
+
+	return nil
+}
+
+// addToTar добавляет файл, директорию или символическую ссылку в TAR-архив,
+// сохраняя права доступа, владельца и время изменения.
+func (c *Compress) addToTar(path, archivePath string, info fs.FileInfo, tw *tar.Writer) error {
+	// Сокеты не могут быть сохранены в tar, пропускаем их
+	if info.Mode()&fs.ModeSocket != 0 {
+		return nil
+	}
+
+	// Для символических ссылок сохраняем путь, на который они указывают
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		link, err = os.Readlink(path)
+		if err != nil {
+			return fmt.Errorf("ошибка при чтении символической ссылки %v: %v", path, err)
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("ошибка при создании заголовка tar для %v: %v", path, err)
+	}
+	header.Name = archivePath
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("ошибка при создании записи в архиве: %v", err)
+	}
+
+	// Содержимое копируем только для обычных файлов
+	entry := newEntry(path, archivePath, info)
+	if !info.Mode().IsRegular() {
+		c.record(entry)
+		return nil
+	}
+	entry.Size, entry.SHA256 = header.Size, emptySHA256
+	if header.Size == 0 {
+		c.record(entry)
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Копируем ровно столько байт, сколько указано в заголовке: файл мог вырасти во время архивации
+	hash := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(tw, hash), file, header.Size); err != nil {
+		return fmt.Errorf("ошибка при копировании файла в архив: %v", err)
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	c.record(entry)
+
+	return nil
+}
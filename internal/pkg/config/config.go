@@ -2,6 +2,9 @@
 package config
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/BurntSushi/toml"
 )
 
@@ -11,56 +14,222 @@ type Telegram struct {
 	ChatID string `toml:"chat_id"` // id чата
 }
 
-// RemoteStorages содержит настройки удаленных хранилищ данных.
-type RemoteStorages struct {
-	GCloud struct {
-		CredentialsJSON string `toml:"credentials_json"` // Путь к JSON-файлу с учетными данными для Google Cloud
-	} `toml:"gCloud"`
-	GDrive struct {
-		ApiKeyJson string `toml:"apiKeyJson"` // Путь к ключу GDrive для атентификации по OAuth2.0
-		TokenFile  string `toml:"tokenFile"`  // Путь до токен файл где будет сохранен токен после атентификации
-	} `toml:"gDrive"`
-	Samba struct {
-		SambaPath string `toml:"samba"`    // Путь к сетевому ресурсу Samba
-		Username  string `toml:"username"` // Имя пользователя для доступа к ресурсу
-		Password  string `toml:"password"` // Пароль для доступа к ресурсу
-	} `toml:"samba"`
-	NFS struct {
-		Path         string `toml:"path"`          // Путь к NFS-шаре
-		MountOptions string `toml:"mount_options"` // Опции монтирования
-	} `toml:"nfs"`
+// RemoteStorages содержит настройки удаленных хранилищ данных по имени хранилища.
+type RemoteStorages map[string]*Storage
+
+// Storage содержит настройки одного удаленного хранилища из блока [storage.<name>].
+// Тип задается параметром type, поэтому можно описать несколько хранилищ одного типа,
+// например два Google Drive разных аккаунтов. Если type не указан, тип совпадает с именем хранилища.
+// Остальные параметры блока зависят от типа хранилища и разбираются методом Decode.
+type Storage struct {
+	Name   string // Имя хранилища, на которое ссылается uploadTo юнита
+	Type   string // Тип хранилища: gCloud, gDrive и т.д.
+	Retry  Retry  // Настройки повторов операций с хранилищем: блок [storage.<name>.retry] поверх общего блока [retry]
+	Verify string // Проверка загруженных архивов: checksum, size или none
+
+	md   *toml.MetaData // Метаданные файла конфигурации для разбора параметров хранилища
+	prim toml.Primitive // Неразобранные параметры блока хранилища
+}
+
+// Способы проверки архива после загрузки в хранилище.
+const (
+	VerifyChecksum = "checksum" // Сверка контрольной суммы хранилища, а если хранилище ее не сообщает - повторное чтение архива
+	VerifySize     = "size"     // Сверка размера и контрольной суммы хранилища, без повторного чтения архива
+	VerifyNone     = "none"     // Без проверки
+)
+
+// NewStorage создает настройки хранилища name типа storageType без дополнительных параметров.
+func NewStorage(name, storageType string) *Storage {
+	return &Storage{Name: name, Type: storageType, Verify: VerifyChecksum}
+}
+
+// Decode разбирает параметры блока хранилища в v, например в структуру с настройками конкретного типа хранилища.
+func (s *Storage) Decode(v interface{}) error {
+	if s.md == nil {
+		return nil
+	}
+	if err := s.md.PrimitiveDecode(s.prim, v); err != nil {
+		return fmt.Errorf("ошибка в настройках хранилища %v: %v", s.Name, err)
+	}
+	return nil
+}
+
+// Retry содержит настройки повторов операций с удаленными хранилищами при временных ошибках.
+// Нулевые значения означают настройки по умолчанию.
+type Retry struct {
+	MaxAttempts int           `toml:"maxAttempts"` // Максимальное количество попыток, 1 - без повторов
+	BaseDelay   time.Duration `toml:"baseDelay"`   // Пауза перед первым повтором, удваивается с каждой попыткой, например "2s"
+	MaxDelay    time.Duration `toml:"maxDelay"`    // Максимальная пауза между попытками, например "1m"
+	Jitter      float64       `toml:"jitter"`      // Случайное отклонение паузы в долях от нее, от 0 до 1
+}
+
+// Merge возвращает настройки r, в которых заданные в override параметры заменяют значения r.
+func (r Retry) Merge(override Retry) Retry {
+	if override.MaxAttempts != 0 {
+		r.MaxAttempts = override.MaxAttempts
+	}
+	if override.BaseDelay != 0 {
+		r.BaseDelay = override.BaseDelay
+	}
+	if override.MaxDelay != 0 {
+		r.MaxDelay = override.MaxDelay
+	}
+	if override.Jitter != 0 {
+		r.Jitter = override.Jitter
+	}
+	return r
+}
+
+// validate проверяет, что параметры повторов допустимы.
+func (r Retry) validate() error {
+	if r.MaxAttempts < 0 || r.BaseDelay < 0 || r.MaxDelay < 0 {
+		return fmt.Errorf("maxAttempts, baseDelay и maxDelay не могут быть отрицательными")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("jitter должен быть от 0 до 1")
+	}
+	return nil
+}
+
+// Encryption содержит настройки шифрования архивов юнита.
+// Указывается либо публичный ключ age, либо пароль для AES-256-GCM.
+type Encryption struct {
+	Recipient      string `toml:"recipient"`      // Публичный ключ age получателя (age1...)
+	Identity       string `toml:"identity"`       // Путь к файлу с приватным ключом age, нужен только для восстановления
+	Passphrase     string `toml:"passphrase"`     // Пароль для шифрования AES-256-GCM
+	PassphraseFile string `toml:"passphraseFile"` // Путь к файлу с паролем, используется вместо passphrase
+}
+
+// Retention описывает политику хранения резервных копий юнита. Архив сохраняется, если его оставляет хотя бы одно правило.
+// В конфигурации задается либо числом дней (retention = 30), либо блоком [Unit.retention] с правилами keep_*.
+type Retention struct {
+	Days        int `toml:"days"`         // Хранить все архивы моложе указанного количества дней
+	KeepLast    int `toml:"keep_last"`    // Хранить указанное количество последних архивов
+	KeepDaily   int `toml:"keep_daily"`   // Хранить последний архив за каждый из указанного количества дней
+	KeepWeekly  int `toml:"keep_weekly"`  // Хранить последний архив за каждую из указанного количества недель
+	KeepMonthly int `toml:"keep_monthly"` // Хранить последний архив за каждый из указанного количества месяцев
+	KeepYearly  int `toml:"keep_yearly"`  // Хранить последний архив за каждый из указанного количества лет
+}
+
+// UnmarshalTOML разбирает срок хранения, заданный числом дней или блоком с правилами.
+func (r *Retention) UnmarshalTOML(data interface{}) error {
+	switch value := data.(type) {
+	case int64:
+		r.Days = int(value)
+		return nil
+	case map[string]interface{}:
+		fields := map[string]*int{
+			"days":         &r.Days,
+			"keep_last":    &r.KeepLast,
+			"keep_daily":   &r.KeepDaily,
+			"keep_weekly":  &r.KeepWeekly,
+			"keep_monthly": &r.KeepMonthly,
+			"keep_yearly":  &r.KeepYearly,
+		}
+		for key, item := range value {
+			field, ok := fields[key]
+			if !ok {
+				return fmt.Errorf("неизвестный параметр срока хранения: %v", key)
+			}
+			number, ok := item.(int64)
+			if !ok || number < 0 {
+				return fmt.Errorf("параметр срока хранения %v должен быть неотрицательным целым числом", key)
+			}
+			*field = int(number)
+		}
+		return nil
+	}
+	return fmt.Errorf("срок хранения должен быть числом дней или блоком с правилами keep_*")
 }
 
 // BackupUnitConfig содержит настройки юнитов/задач бекапов.
 type BackupUnit struct {
-	Name            string   `toml:"name"`            // Имя юнита/задачи бэкапа
-	Retention       int      `toml:"retention"`       // Время хранения бэкапов (в днях)
-	CrontabTask     string   `toml:"crontabTask"`     // Расписание Cron
-	InputPaths      []string `toml:"inputPaths"`      // Пути для бэкапа
-	OutputPath      string   `toml:"output"`          // Путь для сохранения бэкапов
-	CompressFormat  string   `toml:"compressFormat"`  // Формат сжатия бэкапов
-	CompressExclude []string `toml:"compressExclude"` // Исключения из сжатия
-	MaxDiskUsage    string   `toml:"maxDiskUsage"`    // Максимальное использование диска
-	UploadTo        []string `toml:"uploadTo"`        // Список удаленных хранилищ
-	RemotePath      string   `toml:"remotePath"`      // Папка на удаленном хранилище для сохранения бэкапов
+	Name            string      `toml:"name"`            // Имя юнита/задачи бэкапа
+	Retention       Retention   `toml:"retention"`       // Политика хранения бэкапов
+	RetentionDryRun bool        `toml:"retentionDryRun"` // Только сообщать, какие архивы были бы удалены по сроку хранения
+	CrontabTask     string      `toml:"crontabTask"`     // Расписание Cron
+	InputPaths      []string    `toml:"inputPaths"`      // Пути для бэкапа
+	OutputPath      string      `toml:"output"`          // Путь для сохранения бэкапов
+	CompressFormat  string      `toml:"compressFormat"`  // Формат сжатия бэкапов
+	CompressLevel   int         `toml:"compressLevel"`   // Уровень сжатия, 0 - по умолчанию для формата
+	CompressWorkers int         `toml:"compressWorkers"` // Количество потоков сжатия
+	CompressExclude []string    `toml:"compressExclude"` // Исключения из сжатия
+	MaxDiskUsage    string      `toml:"maxDiskUsage"`    // Максимальное использование диска
+	UploadTo        []string    `toml:"uploadTo"`        // Список удаленных хранилищ
+	RemotePath      string      `toml:"remotePath"`      // Папка на удаленном хранилище для сохранения бэкапов
+	Streaming       bool        `toml:"streaming"`       // Отправлять архив в хранилища потоком, без локальной копии
+	Encryption      *Encryption `toml:"encryption"`      // Настройки шифрования архивов, nil - без шифрования
 }
 
 // Config представляет конфигурацию программы KronosKeeper.
 type Config struct {
-	LogPath        string          `toml:"log_path"`  // Путь к файлу журнала
-	LogLevel       string          `toml:"log_level"` // Уровень журналирования
-	Telegram       *Telegram       `toml:"telegram"`  // Настройки уведомлений
-	RemoteStorages *RemoteStorages `toml:"storage"`   // Настройки удаленных хранилищ данных
-	BackupUnits    []BackupUnit    `toml:"unit"`      // Настройки юнитов/задач бекапов
+	LogPath        string         `toml:"log_path"`  // Путь к файлу журнала
+	LogLevel       string         `toml:"log_level"` // Уровень журналирования
+	Telegram       *Telegram      `toml:"telegram"`  // Настройки уведомлений
+	Retry          Retry          `toml:"retry"`     // Общие настройки повторов операций с хранилищами
+	RemoteStorages RemoteStorages `toml:"-"`         // Настройки удаленных хранилищ данных по имени
+	BackupUnits    []BackupUnit   `toml:"unit"`      // Настройки юнитов/задач бекапов
+
+	Storages map[string]toml.Primitive `toml:"storage"` // Неразобранные блоки [storage.<name>]
 }
 
 // NewConfig создает новый экземпляр конфигурации KronosKeeper.
 func NewConfig(configPath string) (*Config, error) {
 	conf := &Config{}
-	_, err := toml.DecodeFile(configPath, conf) // загружаем конфигурацию из файла toml в config
+	md, err := toml.DecodeFile(configPath, conf) // загружаем конфигурацию из файла toml в config
 	if err != nil {
 		return nil, err
 	}
+	if err := conf.decodeStorages(&md); err != nil {
+		return nil, err
+	}
 
 	return conf, nil
 }
+
+// decodeStorages разбирает блоки [storage.<name>] в RemoteStorages и проверяет, что хранилища из uploadTo описаны.
+// Настройки повторов хранилища дополняют общий блок [retry].
+func (c *Config) decodeStorages(md *toml.MetaData) error {
+	if err := c.Retry.validate(); err != nil {
+		return fmt.Errorf("ошибка в блоке [retry]: %v", err)
+	}
+
+	c.RemoteStorages = RemoteStorages{}
+	for name, prim := range c.Storages {
+		storage := &Storage{Name: name, md: md, prim: prim}
+		var head struct {
+			Type   string `toml:"type"`
+			Retry  Retry  `toml:"retry"`
+			Verify string `toml:"verify"`
+		}
+		if err := md.PrimitiveDecode(prim, &head); err != nil {
+			return fmt.Errorf("ошибка в настройках хранилища %v: %v", name, err)
+		}
+		storage.Type = head.Type
+		if storage.Type == "" {
+			storage.Type = name
+		}
+		switch head.Verify {
+		case "":
+			storage.Verify = VerifyChecksum
+		case VerifyChecksum, VerifySize, VerifyNone:
+			storage.Verify = head.Verify
+		default:
+			return fmt.Errorf("ошибка в настройках хранилища %v: неизвестный способ проверки verify = %q, допустимо: checksum, size, none", name, head.Verify)
+		}
+		storage.Retry = c.Retry.Merge(head.Retry)
+		if err := storage.Retry.validate(); err != nil {
+			return fmt.Errorf("ошибка в настройках повторов хранилища %v: %v", name, err)
+		}
+		c.RemoteStorages[name] = storage
+	}
+
+	for _, unit := range c.BackupUnits {
+		for _, name := range unit.UploadTo {
+			if _, ok := c.RemoteStorages[name]; !ok {
+				return fmt.Errorf("юнит %v ссылается на хранилище %v, которое не описано в блоке [storage.%v]", unit.Name, name, name)
+			}
+		}
+	}
+	return nil
+}
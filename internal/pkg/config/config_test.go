@@ -0,0 +1,153 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionDecode(t *testing.T) {
+	testCases := []struct {
+		toml        string
+		expect      Retention
+		expectError bool
+	}{
+		// Срок хранения числом дней
+		{
+			toml:   "[[unit]]\nname = \"nginx\"\nretention = 30\n",
+			expect: Retention{Days: 30},
+		},
+		// Блок с правилами дед-отец-сын
+		{
+			toml:   "[[unit]]\nname = \"nginx\"\n[unit.retention]\nkeep_last = 2\nkeep_daily = 7\nkeep_weekly = 4\nkeep_monthly = 12\nkeep_yearly = 3\n",
+			expect: Retention{KeepLast: 2, KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 12, KeepYearly: 3},
+		},
+		// Неизвестное правило
+		{
+			toml:        "[[unit]]\nname = \"nginx\"\n[unit.retention]\nkeep_hourly = 24\n",
+			expectError: true,
+		},
+	}
+
+	for id, testCase := range testCases {
+		path := filepath.Join(t.TempDir(), "kk.toml")
+		if err := os.WriteFile(path, []byte(testCase.toml), 0644); err != nil {
+			t.Fatalf("Не удалось создать файл конфигурации: %v", err)
+		}
+
+		conf, err := NewConfig(path)
+		if testCase.expectError {
+			if err == nil {
+				t.Errorf("Тест %d не пройден: ожидалась ошибка, но ее не было", id+1)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Тест %d не пройден: не ожидалась ошибка, но она произошла: %v", id+1, err)
+		}
+		if conf.BackupUnits[0].Retention != testCase.expect {
+			t.Errorf("Тест %d не пройден ожидалось: %+v, полученно: %+v", id+1, testCase.expect, conf.BackupUnits[0].Retention)
+		}
+	}
+}
+
+func TestStorageDecode(t *testing.T) {
+	data := `
+[storage.gCloud]
+credentials_json = "configs/credentials.json"
+
+[storage.personal]
+type = "gDrive"
+apiKeyJson = "/etc/KronosKeeper/personal.json"
+
+[storage.work]
+type = "gDrive"
+apiKeyJson = "/etc/KronosKeeper/work.json"
+
+[[unit]]
+name = "nginx"
+uploadTo = ["personal", "work"]
+`
+	path := filepath.Join(t.TempDir(), "kk.toml")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Не удалось создать файл конфигурации: %v", err)
+	}
+	conf, err := NewConfig(path)
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	expectTypes := map[string]string{"gCloud": "gCloud", "personal": "gDrive", "work": "gDrive"}
+	for name, expectType := range expectTypes {
+		storage, ok := conf.RemoteStorages[name]
+		if !ok {
+			t.Fatalf("Хранилище %v не найдено", name)
+		}
+		if storage.Name != name || storage.Type != expectType {
+			t.Errorf("Тест не пройден ожидалось: %v (%v), полученно: %v (%v)", name, expectType, storage.Name, storage.Type)
+		}
+	}
+
+	var settings struct {
+		ApiKeyJson string `toml:"apiKeyJson"`
+	}
+	if err := conf.RemoteStorages["work"].Decode(&settings); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if settings.ApiKeyJson != "/etc/KronosKeeper/work.json" {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", "/etc/KronosKeeper/work.json", settings.ApiKeyJson)
+	}
+
+	// Юнит ссылается на хранилище, которого нет в конфигурации
+	if err := os.WriteFile(path, []byte("[[unit]]\nname = \"nginx\"\nuploadTo = [\"missing\"]\n"), 0644); err != nil {
+		t.Fatalf("Не удалось создать файл конфигурации: %v", err)
+	}
+	if _, err := NewConfig(path); err == nil {
+		t.Errorf("Ожидалась ошибка для неописанного хранилища, но ее не было")
+	}
+}
+
+func TestRetryDecode(t *testing.T) {
+	data := `
+[retry]
+maxAttempts = 5
+baseDelay = "1s"
+maxDelay = "30s"
+
+[storage.gDrive]
+apiKeyJson = "configs/gDrive.json"
+
+[storage.nas]
+type = "sftp"
+[storage.nas.retry]
+maxAttempts = 2
+jitter = 0.5
+`
+	path := filepath.Join(t.TempDir(), "kk.toml")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Не удалось создать файл конфигурации: %v", err)
+	}
+	conf, err := NewConfig(path)
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	expect := map[string]Retry{
+		"gDrive": {MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second},
+		"nas":    {MaxAttempts: 2, BaseDelay: time.Second, MaxDelay: 30 * time.Second, Jitter: 0.5},
+	}
+	for name, retry := range expect {
+		if got := conf.RemoteStorages[name].Retry; got != retry {
+			t.Errorf("Тест не пройден ожидалось: %+v, полученно: %+v", retry, got)
+		}
+	}
+
+	// Недопустимое отклонение паузы
+	if err := os.WriteFile(path, []byte("[retry]\njitter = 2.0\n"), 0644); err != nil {
+		t.Fatalf("Не удалось создать файл конфигурации: %v", err)
+	}
+	if _, err := NewConfig(path); err == nil {
+		t.Errorf("Ожидалась ошибка для jitter больше 1, но ее не было")
+	}
+}
@@ -0,0 +1,151 @@
+// Пакет diskusage реализует разбор и проверку ограничения на место, занимаемое резервными копиями юнита.
+//
+// Ограничение задается размером ("20GB", "512MiB", "1048576") или процентом от размера файловой системы ("10%").
+package diskusage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Limit описывает ограничение на использование диска.
+type Limit struct {
+	Bytes   int64   // Ограничение в байтах
+	Percent float64 // Ограничение в процентах от размера файловой системы, используется если Bytes равно 0
+}
+
+// ErrUnsupported возвращается, если свободное место нельзя определить на этой платформе.
+var ErrUnsupported = errors.New("свободное место на файловой системе можно определить только в Linux")
+
+// units содержит множители единиц измерения размера. Десятичные единицы считаются по 1000, двоичные по 1024.
+var units = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// ParseLimit разбирает ограничение из конфигурации юнита. Пустая строка означает отсутствие ограничения.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Limit{}, nil
+	}
+
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return Limit{}, fmt.Errorf("некорректный процент использования диска: %v", s)
+		}
+		return Limit{Percent: percent}, nil
+	}
+
+	// Отделяем число от единицы измерения
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	number, unit := s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	multiplier, ok := units[unit]
+	if !ok {
+		return Limit{}, fmt.Errorf("неизвестная единица измерения %q в ограничении %v", s[i:], s)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value <= 0 {
+		return Limit{}, fmt.Errorf("некорректный размер ограничения: %v", s)
+	}
+
+	return Limit{Bytes: int64(value * float64(multiplier))}, nil
+}
+
+// IsEmpty возвращает true, если ограничение не задано.
+func (l Limit) IsEmpty() bool {
+	return l.Bytes <= 0 && l.Percent <= 0
+}
+
+// Resolve возвращает ограничение в байтах для папки dir. Процент считается от размера файловой системы,
+// на которой находится dir или ближайшая существующая родительская папка.
+func (l Limit) Resolve(dir string) (int64, error) {
+	if l.Bytes > 0 || l.Percent <= 0 {
+		return l.Bytes, nil
+	}
+
+	dir, err := existingParent(dir)
+	if err != nil {
+		return 0, err
+	}
+	total, err := filesystemSize(dir)
+	if err != nil {
+		return 0, fmt.Errorf("не удалось определить размер файловой системы %v: %v", dir, err)
+	}
+	return int64(float64(total) * l.Percent / 100), nil
+}
+
+// DirSize возвращает суммарный размер файлов в папке dir. Если папки нет, возвращается 0.
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("не удалось посчитать размер папки %v: %v", dir, err)
+	}
+	return size, nil
+}
+
+// existingParent возвращает dir или ближайшую существующую родительскую папку.
+func existingParent(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("не найдена существующая папка для %v", dir)
+		}
+		dir = parent
+	}
+}
+
+// Free возвращает количество байт, доступных для записи на файловой системе, на которой находится dir
+// или ближайшая существующая родительская папка.
+func Free(dir string) (uint64, error) {
+	dir, err := existingParent(dir)
+	if err != nil {
+		return 0, err
+	}
+	free, err := filesystemFree(dir)
+	if err != nil {
+		return 0, fmt.Errorf("не удалось определить свободное место на %v: %w", dir, err)
+	}
+	return free, nil
+}
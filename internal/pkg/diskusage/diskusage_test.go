@@ -0,0 +1,40 @@
+package diskusage
+
+import (
+	"testing"
+)
+
+func TestParseLimit(t *testing.T) {
+	testCases := []struct {
+		value       string
+		expect      Limit
+		expectError bool
+	}{
+		{value: "", expect: Limit{}},
+		{value: "1048576", expect: Limit{Bytes: 1048576}},
+		{value: "20GB", expect: Limit{Bytes: 20 * 1000 * 1000 * 1000}},
+		{value: "1.5 GiB", expect: Limit{Bytes: 3 << 29}},
+		{value: "512mb", expect: Limit{Bytes: 512 * 1000 * 1000}},
+		{value: "10%", expect: Limit{Percent: 10}},
+		{value: "150%", expectError: true},
+		{value: "20PB", expectError: true},
+		{value: "GB", expectError: true},
+	}
+
+	for _, testCase := range testCases {
+		limit, err := ParseLimit(testCase.value)
+		if testCase.expectError {
+			if err == nil {
+				t.Errorf("Для %q ожидалась ошибка, но ее не было", testCase.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Для %q не ожидалась ошибка, но она произошла: %v", testCase.value, err)
+			continue
+		}
+		if limit != testCase.expect {
+			t.Errorf("Тест не пройден ожидалось: %+v, полученно: %+v", testCase.expect, limit)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package diskusage
+
+import "syscall"
+
+// filesystemSize возвращает размер файловой системы, на которой находится dir.
+func filesystemSize(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Blocks * uint64(stat.Bsize), nil
+}
+
+// filesystemFree возвращает количество байт, доступных непривилегированному пользователю на файловой системе dir.
+func filesystemFree(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
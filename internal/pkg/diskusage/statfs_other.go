@@ -0,0 +1,15 @@
+//go:build !linux
+
+package diskusage
+
+import "errors"
+
+// filesystemSize не поддерживается вне Linux, поэтому ограничение в процентах доступно только в Linux.
+func filesystemSize(dir string) (uint64, error) {
+	return 0, errors.New("ограничение в процентах от файловой системы поддерживается только в Linux")
+}
+
+// filesystemFree не поддерживается вне Linux.
+func filesystemFree(dir string) (uint64, error) {
+	return 0, ErrUnsupported
+}
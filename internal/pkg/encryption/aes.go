@@ -0,0 +1,204 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Формат файла .enc:
+//
+//	magic (6 байт "KKENC1") | logN scrypt (1 байт) | соль (16 байт) | фрагменты
+//
+// Данные шифруются фрагментами по aesChunkSize байт. Nonce каждого фрагмента состоит из
+// 11 байт порядкового номера и 1 байта признака последнего фрагмента, поэтому перестановка,
+// удаление или обрезка фрагментов обнаруживаются при расшифровке.
+const (
+	aesMagic      = "KKENC1"
+	aesSaltSize   = 16
+	aesLogN       = 15 // Параметр стоимости scrypt N = 2^15
+	aesChunkSize  = 64 * 1024
+	aesLastChunk  = 1
+	aesHeaderSize = len(aesMagic) + 1 + aesSaltSize
+)
+
+// deriveKey получает ключ AES-256 из пароля и соли.
+func deriveKey(passphrase, salt []byte, logN int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, 1<<logN, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ключа из пароля: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce формирует nonce для фрагмента с номером counter.
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = aesLastChunk
+	}
+	return nonce
+}
+
+// aesWriter шифрует поток фрагментами AES-256-GCM.
+type aesWriter struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+// newAESWriter записывает заголовок в dst и возвращает шифрующего писателя.
+func newAESWriter(dst io.Writer, passphrase []byte) (*aesWriter, error) {
+	salt := make([]byte, aesSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("ошибка генерации соли: %v", err)
+	}
+	aead, err := deriveKey(passphrase, salt, aesLogN)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(aesMagic), byte(aesLogN))
+	header = append(header, salt...)
+	if _, err := dst.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &aesWriter{dst: dst, aead: aead, buf: make([]byte, 0, aesChunkSize)}, nil
+}
+
+func (w *aesWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("запись в закрытый шифрующий поток")
+	}
+	n := 0
+	for len(p) > 0 {
+		// Полный буфер шифруется только когда известно, что за ним есть еще данные
+		if len(w.buf) == aesChunkSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):aesChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close шифрует последний фрагмент. Close не закрывает dst.
+func (w *aesWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *aesWriter) flush(last bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.counter, last), w.buf, nil)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.dst.Write(sealed)
+	return err
+}
+
+// aesReader расшифровывает поток, созданный aesWriter.
+type aesReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	enc     []byte // Буфер для зашифрованного фрагмента
+	plain   []byte // Расшифрованные, но еще не прочитанные данные
+	counter uint64
+	peeked  []byte // Байт, прочитанный для проверки наличия следующего фрагмента
+	done    bool
+}
+
+// newAESReader читает заголовок из src и возвращает расшифровывающего читателя.
+func newAESReader(src io.Reader, passphrase []byte) (*aesReader, error) {
+	header := make([]byte, aesHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, fmt.Errorf("ошибка чтения заголовка зашифрованного файла: %v", err)
+	}
+	if !bytes.Equal(header[:len(aesMagic)], []byte(aesMagic)) {
+		return nil, errors.New("файл не является архивом, зашифрованным паролем")
+	}
+	logN := int(header[len(aesMagic)])
+	if logN < 10 || logN > 22 {
+		return nil, fmt.Errorf("недопустимый параметр scrypt в заголовке: %v", logN)
+	}
+	aead, err := deriveKey(passphrase, header[len(aesMagic)+1:], logN)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aesReader{src: src, aead: aead, enc: make([]byte, aesChunkSize+aead.Overhead())}, nil
+}
+
+func (r *aesReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next читает и расшифровывает следующий фрагмент.
+func (r *aesReader) next() error {
+	n := copy(r.enc, r.peeked)
+	r.peeked = nil
+	m, err := io.ReadFull(r.src, r.enc[n:])
+	n += m
+
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		// Фрагмент полный: проверяем, есть ли за ним еще данные
+		one := make([]byte, 1)
+		k, err := io.ReadFull(r.src, one)
+		if err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+		r.peeked = one[:k]
+	}
+
+	if n < r.aead.Overhead() {
+		return errors.New("зашифрованный файл поврежден или обрезан")
+	}
+
+	plain, err := r.aead.Open(r.enc[:0:0], chunkNonce(r.counter, last), r.enc[:n], nil)
+	if err != nil {
+		return errors.New("неверный пароль или зашифрованный файл поврежден")
+	}
+	r.counter++
+	r.plain = plain
+	r.done = last
+	return nil
+}
@@ -0,0 +1,147 @@
+// Пакет encryption реализует шифрование архивов резервных копий перед отправкой в удаленные хранилища
+// и их расшифровку при восстановлении.
+//
+// Поддерживаются два способа шифрования:
+//   - age с получателем X25519 (публичный ключ age1...), файл получает расширение .age;
+//   - AES-256-GCM с ключом, полученным из пароля через scrypt, файл получает расширение .enc.
+package encryption
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+)
+
+const (
+	AgeExtension = ".age" // Расширение архивов, зашифрованных age
+	AESExtension = ".enc" // Расширение архивов, зашифрованных AES-256-GCM
+)
+
+// Encryptor шифрует и расшифровывает архивы согласно настройкам юнита.
+type Encryptor struct {
+	recipient  *age.X25519Recipient // Получатель age, используется при шифровании
+	identities []age.Identity       // Приватные ключи age, используются при расшифровке
+	passphrase []byte               // Пароль для AES-256-GCM
+}
+
+// New создает новый Encryptor на основе настроек шифрования юнита.
+// Должен быть указан либо получатель age, либо пароль, но не оба сразу.
+func New(conf *config.Encryption) (*Encryptor, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("отсутствуют настройки шифрования")
+	}
+
+	passphrase := conf.Passphrase
+	if conf.PassphraseFile != "" {
+		data, err := os.ReadFile(conf.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать файл с паролем: %v", err)
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	}
+
+	usesAge := conf.Recipient != "" || conf.Identity != ""
+	if usesAge && passphrase != "" {
+		return nil, fmt.Errorf("в настройках шифрования нужно указать либо ключ age, либо пароль, но не оба сразу")
+	}
+	if !usesAge && passphrase == "" {
+		return nil, fmt.Errorf("в настройках шифрования не указан ни ключ age, ни пароль")
+	}
+
+	e := &Encryptor{passphrase: []byte(passphrase)}
+
+	if conf.Recipient != "" {
+		recipient, err := age.ParseX25519Recipient(conf.Recipient)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный публичный ключ age: %v", err)
+		}
+		e.recipient = recipient
+	}
+
+	if conf.Identity != "" {
+		file, err := os.Open(conf.Identity)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось открыть файл с приватным ключом age: %v", err)
+		}
+		defer file.Close()
+
+		e.identities, err = age.ParseIdentities(file)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный файл с приватным ключом age: %v", err)
+		}
+	}
+
+	return e, nil
+}
+
+// Extension возвращает расширение, которое получает зашифрованный архив.
+func (e *Encryptor) Extension() string {
+	if len(e.passphrase) > 0 {
+		return AESExtension
+	}
+	return AgeExtension
+}
+
+// Encrypt возвращает писателя, который шифрует все записанные в него данные и передает их в dst.
+// Шифрование завершается только после вызова Close.
+func (e *Encryptor) Encrypt(dst io.Writer) (io.WriteCloser, error) {
+	if len(e.passphrase) > 0 {
+		return newAESWriter(dst, e.passphrase)
+	}
+	if e.recipient == nil {
+		return nil, fmt.Errorf("для шифрования age не указан публичный ключ получателя")
+	}
+	return age.Encrypt(dst, e.recipient)
+}
+
+// Decrypt возвращает читателя, который расшифровывает данные из src.
+func (e *Encryptor) Decrypt(src io.Reader) (io.Reader, error) {
+	if len(e.passphrase) > 0 {
+		return newAESReader(src, e.passphrase)
+	}
+	if len(e.identities) == 0 {
+		return nil, fmt.Errorf("для расшифровки age не указан файл с приватным ключом (identity)")
+	}
+	return age.Decrypt(src, e.identities...)
+}
+
+// DecryptFile расшифровывает файл src в файл dst.
+func (e *Encryptor) DecryptFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия зашифрованного файла: %v", err)
+	}
+	defer in.Close()
+
+	r, err := e.Decrypt(in)
+	if err != nil {
+		return fmt.Errorf("ошибка расшифровки файла %v: %v", src, err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("ошибка создания файла: %v", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("ошибка расшифровки файла %v: %v", src, err)
+	}
+
+	return out.Close()
+}
+
+// IsEncrypted сообщает, является ли файл зашифрованным архивом, по его расширению.
+func IsEncrypted(name string) bool {
+	return strings.HasSuffix(name, AgeExtension) || strings.HasSuffix(name, AESExtension)
+}
+
+// TrimExtension возвращает имя файла без расширения шифрования.
+func TrimExtension(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, AgeExtension), AESExtension)
+}
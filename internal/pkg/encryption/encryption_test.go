@@ -0,0 +1,139 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+)
+
+func TestAESRoundTrip(t *testing.T) {
+	encryptor, err := New(&config.Encryption{Passphrase: "секрет"})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка при создании Encryptor: %v", err)
+	}
+	if encryptor.Extension() != AESExtension {
+		t.Errorf("Ожидалось расширение %v, получено %v", AESExtension, encryptor.Extension())
+	}
+
+	// Размеры подобраны так, чтобы проверить пустые данные и границы фрагментов
+	sizes := []int{0, 1, aesChunkSize - 1, aesChunkSize, aesChunkSize + 1, 3 * aesChunkSize}
+	for _, size := range sizes {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		encrypted := encrypt(t, encryptor, plain)
+		decrypted, err := decrypt(encryptor, encrypted)
+		if err != nil {
+			t.Fatalf("Размер %v: ошибка расшифровки: %v", size, err)
+		}
+		if !bytes.Equal(plain, decrypted) {
+			t.Errorf("Размер %v: расшифрованные данные не совпадают с исходными", size)
+		}
+
+		// Обрезанный файл не должен расшифровываться
+		if size > 0 {
+			if _, err := decrypt(encryptor, encrypted[:len(encrypted)-1]); err == nil {
+				t.Errorf("Размер %v: ожидалась ошибка при расшифровке обрезанного файла", size)
+			}
+		}
+	}
+
+	// Неверный пароль
+	encrypted := encrypt(t, encryptor, []byte("nginx.conf"))
+	wrong, err := New(&config.Encryption{Passphrase: "другой"})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка при создании Encryptor: %v", err)
+	}
+	if _, err := decrypt(wrong, encrypted); err == nil {
+		t.Errorf("Ожидалась ошибка при расшифровке с неверным паролем")
+	}
+}
+
+func TestAgeFileRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Не удалось создать ключ age: %v", err)
+	}
+	identityFile := filepath.Join(tempDir, "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("Не удалось сохранить ключ age: %v", err)
+	}
+
+	encryptor, err := New(&config.Encryption{Recipient: identity.Recipient().String(), Identity: identityFile})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка при создании Encryptor: %v", err)
+	}
+
+	plain := []byte("содержимое архива")
+	encPath := filepath.Join(tempDir, "01-10:00-nginx.zip"+AgeExtension)
+	if err := os.WriteFile(encPath, encrypt(t, encryptor, plain), 0644); err != nil {
+		t.Fatalf("Не удалось создать зашифрованный архив: %v", err)
+	}
+
+	restored := filepath.Join(tempDir, "restored.zip")
+	if err := encryptor.DecryptFile(encPath, restored); err != nil {
+		t.Fatalf("Ошибка расшифровки файла: %v", err)
+	}
+	data, err := os.ReadFile(restored)
+	if err != nil {
+		t.Fatalf("Не удалось прочитать расшифрованный файл: %v", err)
+	}
+	if !bytes.Equal(plain, data) {
+		t.Errorf("Расшифрованные данные не совпадают с исходными")
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	testCases := []struct {
+		conf        *config.Encryption
+		expectError bool
+	}{
+		{&config.Encryption{}, true},
+		{&config.Encryption{Recipient: "age1invalid"}, true},
+		{&config.Encryption{Recipient: "age1invalid", Passphrase: "pass"}, true},
+		{&config.Encryption{Passphrase: "pass"}, false},
+	}
+
+	for id, testCase := range testCases {
+		_, err := New(testCase.conf)
+		if testCase.expectError && err == nil {
+			t.Errorf("Тест %d не пройден: ожидалась ошибка, но ее не было", id+1)
+		}
+		if !testCase.expectError && err != nil {
+			t.Errorf("Тест %d не пройден: не ожидалась ошибка, но она произошла: %v", id+1, err)
+		}
+	}
+}
+
+// encrypt шифрует plain в памяти.
+func encrypt(t *testing.T, e *Encryptor, plain []byte) []byte {
+	var buf bytes.Buffer
+	w, err := e.Encrypt(&buf)
+	if err != nil {
+		t.Fatalf("Ошибка создания шифрующего потока: %v", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatalf("Ошибка шифрования: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Ошибка завершения шифрования: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decrypt расшифровывает data в памяти.
+func decrypt(e *Encryptor, data []byte) ([]byte, error) {
+	r, err := e.Decrypt(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,157 @@
+// Пакет manifest описывает манифест резервной копии - JSON файл, который сохраняется рядом с каждым архивом
+// локально и в удаленных хранилищах.
+//
+// Манифест содержит сведения о том, где и как создан архив, его размер и контрольные суммы, а также список
+// всех файлов архива с размером, временем изменения, правами доступа и SHA-256. По манифесту можно посмотреть
+// содержимое резервной копии и проверить архив, не скачивая и не распаковывая его.
+// Манифест архива 23-10:34-nginx.zip называется 23-10:34-nginx.zip.manifest.json.
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/compress"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+)
+
+// Suffix добавляется к имени архива в имени файла манифеста.
+const Suffix = ".manifest.json"
+
+// FormatVersion - версия формата манифеста.
+const FormatVersion = 1
+
+// ToolVersion - версия KronosKeeper, задается при сборке:
+//
+//	go build -ldflags "-X github.com/Erikqwerty/KronosKeeper/internal/pkg/manifest.ToolVersion=1.0.0"
+var ToolVersion = ""
+
+// Manifest описывает резервную копию.
+type Manifest struct {
+	FormatVersion int              `json:"formatVersion"` // Версия формата манифеста
+	Tool          string           `json:"tool"`          // Программа и ее версия, создавшие архив
+	Host          string           `json:"host"`          // Имя хоста, на котором создан архив
+	Unit          string           `json:"unit"`          // Имя юнита
+	Created       time.Time        `json:"created"`       // Время создания манифеста
+	Archive       string           `json:"archive"`       // Имя архива
+	YearMonth     string           `json:"yearMonth"`     // Папка год-месяц, в которой лежит архив
+	Format        string           `json:"format"`        // Формат сжатия
+	Encrypted     bool             `json:"encrypted"`     // Архив зашифрован
+	InputPaths    []string         `json:"inputPaths"`    // Пути, из которых создан архив
+	Exclude       []string         `json:"exclude"`       // Исключения из сжатия
+	Size          int64            `json:"size"`          // Размер архива в байтах
+	SHA256        string           `json:"sha256"`        // SHA-256 архива
+	MD5           string           `json:"md5"`           // MD5 архива
+	FileCount     int              `json:"fileCount"`     // Количество файлов в архиве, без папок и ссылок
+	TotalSize     int64            `json:"totalSize"`     // Суммарный размер файлов до сжатия
+	Files         []compress.Entry `json:"files"`         // Элементы архива
+}
+
+// New создает манифест архива юнита unit по отчету о сжатии report.
+func New(unit config.BackupUnit, report *compress.CompressReport) *Manifest {
+	host, _ := os.Hostname()
+	m := &Manifest{
+		FormatVersion: FormatVersion,
+		Tool:          "KronosKeeper " + Version(),
+		Host:          host,
+		Unit:          unit.Name,
+		Created:       time.Now(),
+		Archive:       report.ArchiveName,
+		YearMonth:     report.YearMoth,
+		Format:        unit.CompressFormat,
+		Encrypted:     unit.Encryption != nil,
+		InputPaths:    unit.InputPaths,
+		Exclude:       unit.CompressExclude,
+		Size:          report.Size,
+		SHA256:        report.SHA256,
+		MD5:           report.MD5,
+		Files:         report.Entries,
+	}
+	for _, file := range m.Files {
+		if file.Mode.IsRegular() {
+			m.FileCount++
+			m.TotalSize += file.Size
+		}
+	}
+	return m
+}
+
+// Version возвращает версию KronosKeeper: заданную при сборке или версию модуля из информации о сборке.
+func Version() string {
+	if ToolVersion != "" {
+		return ToolVersion
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// Name возвращает имя файла манифеста для архива archiveName.
+func Name(archiveName string) string {
+	return archiveName + Suffix
+}
+
+// IsManifest сообщает, является ли файл name манифестом, и возвращает имя архива, которому он принадлежит.
+func IsManifest(name string) (string, bool) {
+	if !strings.HasSuffix(name, Suffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(name, Suffix), true
+}
+
+// Marshal возвращает манифест в формате JSON.
+func (m *Manifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка формирования манифеста: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// WriteFile сохраняет манифест в папку dir рядом с архивом и возвращает путь к файлу манифеста.
+// Манифест сначала записывается во временный файл, чтобы рядом с архивом не оказался недописанный манифест.
+func (m *Manifest) WriteFile(dir string) (string, error) {
+	data, err := m.Marshal()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, Name(m.Archive))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return "", fmt.Errorf("ошибка записи манифеста %v: %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("ошибка записи манифеста %v: %v", path, err)
+	}
+	return path, nil
+}
+
+// Read читает манифест из r.
+func Read(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("ошибка чтения манифеста: %v", err)
+	}
+	if m.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("версия манифеста %v не поддерживается, обновите KronosKeeper", m.FormatVersion)
+	}
+	return m, nil
+}
+
+// ReadFile читает манифест из файла path.
+func ReadFile(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть манифест: %v", err)
+	}
+	defer file.Close()
+	return Read(file)
+}
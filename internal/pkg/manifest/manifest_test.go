@@ -0,0 +1,91 @@
+package manifest
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/compress"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+)
+
+func TestNew(t *testing.T) {
+	unit := config.BackupUnit{
+		Name:            "nginx",
+		InputPaths:      []string{"/etc/nginx"},
+		CompressFormat:  "tar.gz",
+		CompressExclude: []string{"*.log"},
+		Encryption:      &config.Encryption{Passphrase: "secret"},
+	}
+	report := &compress.CompressReport{
+		YearMoth:    "2024-03",
+		ArchiveName: "15-12:00-nginx.tar.gz",
+		Size:        300,
+		SHA256:      "sha",
+		MD5:         "md5",
+		Entries: []compress.Entry{
+			{Path: "nginx", Mode: fs.ModeDir | 0755},
+			{Path: "nginx/nginx.conf", Size: 100, Mode: 0644},
+			{Path: "nginx/mime.types", Size: 50, Mode: 0644},
+			{Path: "nginx/current", Mode: fs.ModeSymlink | 0777, Link: "nginx.conf"},
+		},
+	}
+
+	m := New(unit, report)
+	if m.Unit != "nginx" || m.Archive != report.ArchiveName || m.YearMonth != "2024-03" || m.Format != "tar.gz" || !m.Encrypted {
+		t.Errorf("Неверные сведения об архиве: %+v", m)
+	}
+	if m.FileCount != 2 || m.TotalSize != 150 {
+		t.Errorf("Тест не пройден ожидалось: 2 файла, 150 байт, полученно: %v файлов, %v байт", m.FileCount, m.TotalSize)
+	}
+	if !strings.HasPrefix(m.Tool, "KronosKeeper ") || m.FormatVersion != FormatVersion {
+		t.Errorf("Неверная версия программы или манифеста: %v, %v", m.Tool, m.FormatVersion)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	m := &Manifest{
+		FormatVersion: FormatVersion,
+		Archive:       "15-12:00-nginx.zip",
+		Created:       time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC),
+		InputPaths:    []string{"/etc/nginx"},
+		Files:         []compress.Entry{{Path: "nginx/nginx.conf", Size: 100, Mode: 0644, SHA256: "abc"}},
+	}
+
+	path, err := m.WriteFile(dir)
+	if err != nil {
+		t.Fatalf("Ошибка записи манифеста: %v", err)
+	}
+	if path != filepath.Join(dir, "15-12:00-nginx.zip.manifest.json") {
+		t.Errorf("Неверный путь манифеста: %v", path)
+	}
+	read, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("Ошибка чтения манифеста: %v", err)
+	}
+	if !reflect.DeepEqual(read, m) {
+		t.Errorf("Тест не пройден ожидалось: %+v, полученно: %+v", m, read)
+	}
+
+	// Манифест более новой версии не читается
+	if err := os.WriteFile(path, []byte(`{"formatVersion": 100}`), 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if _, err := ReadFile(path); err == nil {
+		t.Errorf("Для неизвестной версии манифеста ожидалась ошибка")
+	}
+}
+
+func TestIsManifest(t *testing.T) {
+	if archive, ok := IsManifest(Name("15-12:00-nginx.zip")); !ok || archive != "15-12:00-nginx.zip" {
+		t.Errorf("Тест не пройден ожидалось: 15-12:00-nginx.zip, полученно: %v, %v", archive, ok)
+	}
+	if _, ok := IsManifest("15-12:00-nginx.zip"); ok {
+		t.Errorf("Архив не должен считаться манифестом")
+	}
+}
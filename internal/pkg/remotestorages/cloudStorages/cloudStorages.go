@@ -2,21 +2,39 @@ package cloudStorages
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 )
 
+// PartSuffix добавляется к имени архива на время загрузки хранилищами, которые сначала записывают
+// архив во временный файл, а после окончания загрузки переименовывают его. Такие файлы являются
+// недокачанными архивами и не возвращаются из ListDirItems.
+const PartSuffix = ".part"
+
 type File struct {
 	Id       string   // ID файла на диске
 	Name     string   // Имя файла
 	Size     int64    // Размер файла
 	Parents  []string // Папка вышестоящего уровня, а надо бы путь к файлу.
 	MimeType string   // Тип файла
+	Dir      bool     // Элемент является папкой, для хранилищ без отдельного типа папок
+	MD5      string   // MD5 содержимого в шестнадцатеричном виде, посчитанный хранилищем, пустой если хранилище его не сообщает
 }
 
 type Cloud interface {
 	Lister
 	Uploader
 	Downloader
+	Deleter
+}
+
+// Storage описывает удаленное хранилище резервных копий. Клиент хранилища создается вызовом NewClient
+// перед первым обращением к хранилищу.
+type Storage interface {
+	Cloud
+	Stater
+	NewClient() error
 }
 
 type Lister interface {
@@ -25,14 +43,85 @@ type Lister interface {
 
 type Uploader interface {
 	UploadFile(localPath string, remotePath string) error
+	StreamUploader
+}
+
+// StreamUploader загружает файл с именем name из потока r, не требуя локальной копии.
+type StreamUploader interface {
+	UploadStream(r io.Reader, name string, remotePath string) error
 }
 
 type Downloader interface {
 	DownloadFile(fileID string, localPath string) error
 }
 
+// Opener открывает файл хранилища на чтение без сохранения на диск, например для проверки контрольной суммы.
+// Необязательный интерфейс: для хранилищ без него файл скачивается методом DownloadFile.
+type Opener interface {
+	Open(fileID string) (io.ReadCloser, error)
+}
+
+// RangeOpener открывает на чтение часть файла хранилища длиной length байт, начиная с offset, например
+// центральный каталог и нужные файлы ZIP-архива при выборочном восстановлении.
+// Необязательный интерфейс: для хранилищ без него архив скачивается целиком.
+type RangeOpener interface {
+	OpenRange(fileID string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Stater возвращает информацию о файле по его пути в хранилище.
+type Stater interface {
+	Stat(remotePath string) (*File, error)
+}
+
+// Deleter удаляет файлы и папки по их идентификаторам, например при очистке старых резервных копий.
+type Deleter interface {
+	DeleteFile(fileID string) error
+	DeleteDir(dirID string) error
+}
+
+// section - часть файла, при закрытии которой закрывается сам файл.
+type section struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// NewSection возвращает читателя length байт файла file, начиная с offset, для реализации RangeOpener
+// хранилищами с произвольным доступом к файлам. Закрытие читателя закрывает file.
+func NewSection(file interface {
+	io.ReaderAt
+	io.Closer
+}, offset, length int64) io.ReadCloser {
+	return &section{SectionReader: io.NewSectionReader(file, offset, length), Closer: file}
+}
+
+// DownloadFile скачивает файл fileID в localPath, читая его через opener, для реализации Downloader
+// хранилищами, которые реализуют Opener.
+func DownloadFile(opener Opener, fileID string, localPath string) error {
+	remote, err := opener.Open(fileID)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	outFile, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("не удалось создать файл: %w", err)
+	}
+	defer outFile.Close()
+
+	if _, err := io.Copy(outFile, remote); err != nil {
+		return fmt.Errorf("ошибка скачивания файла %v: %w", fileID, err)
+	}
+	return outFile.Close()
+}
+
+// RangeHeader возвращает значение HTTP заголовка Range для чтения length байт, начиная с offset.
+func RangeHeader(offset, length int64) string {
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
 func (f *File) IsDir() bool {
-	return f.MimeType == "application/vnd.google-apps.folder"
+	return f.Dir || f.MimeType == "application/vnd.google-apps.folder"
 }
 
 func (f *File) PathGenerate() string {
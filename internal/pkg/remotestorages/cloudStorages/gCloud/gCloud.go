@@ -3,20 +3,64 @@ package gCloud
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/gcs"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/resumable"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
-// GCloud представляет объект для работы с Google Cloud.
+func init() {
+	cloudStorages.Register("gCloud", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		// Если указан бакет, архивы сохраняются в Cloud Storage, иначе по-старому в Drive сервисного аккаунта
+		bucket := gcs.Config{}
+		if err := conf.Decode(&bucket); err != nil {
+			return nil, err
+		}
+		if bucket.Bucket != "" {
+			return gcs.New(bucket)
+		}
+
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		storage := New(settings.CredentialsJSON)
+		if settings.ChunkSizeMB > 0 {
+			storage.chunkSize = int64(settings.ChunkSizeMB) << 20
+		}
+		storage.state = resumable.NewState(settings.StateFile)
+		return storage, nil
+	})
+}
+
+// Config содержит настройки хранилища типа gCloud.
+type Config struct {
+	CredentialsJSON string `toml:"credentials_json"` // Путь к JSON-файлу с учетными данными сервисного аккаунта
+	ChunkSizeMB     int    `toml:"chunkSizeMB"`      // Размер части возобновляемой загрузки в МБ, по умолчанию 16
+	StateFile       string `toml:"stateFile"`        // Файл с незавершенными загрузками, по умолчанию в папке кэша пользователя
+}
+
+// GCloud представляет объект для работы с Google Drive сервисного аккаунта Google Cloud.
+// Для хранения архивов в бакете Cloud Storage используется пакет gcs.
 type GCloud struct {
 	CredentialsJSON string          // Путь к файлу Credentials.json, необходимому для аутентификации Google Cloud API.
 	ctx             context.Context // Контекст для выполнения операций API.
 	client          *drive.Service  // Клиент Google Cloud API.
+	httpClient      *http.Client    // Авторизованный HTTP клиент для возобновляемой загрузки.
+
+	chunkSize int64            // Размер части возобновляемой загрузки.
+	state     *resumable.State // Незавершенные загрузки.
 }
 
 // New создает новый экземпляр GCloud с указанием пути к файлу JSON с учетными данными.
@@ -24,28 +68,64 @@ func New(credentialsJSON string) *GCloud {
 	return &GCloud{
 		CredentialsJSON: credentialsJSON,
 		ctx:             context.Background(),
+		chunkSize:       resumable.DefaultChunkSize,
+		state:           resumable.NewState(""),
 	}
 }
 
 // NewClient создает новый клиент Google Cloud API с использованием учетных данных из файла JSON.
 func (gc *GCloud) NewClient() error {
-	client, err := drive.NewService(gc.ctx, option.WithCredentialsFile(gc.CredentialsJSON))
+	httpClient, _, err := htransport.NewClient(gc.ctx, option.WithCredentialsFile(gc.CredentialsJSON), option.WithScopes(drive.DriveScope))
+	if err != nil {
+		return fmt.Errorf("google Cloud API NewClient: не удалось создать клиент: %w", err)
+	}
+	client, err := drive.NewService(gc.ctx, option.WithHTTPClient(httpClient))
 	if err != nil {
-		return fmt.Errorf("google Cloud API NewClient: не удалось создать клиент: %v", err)
+		return fmt.Errorf("google Cloud API NewClient: не удалось создать клиент: %w", err)
 	}
 	gc.client = client
+	gc.httpClient = httpClient
 	return nil
 }
 
-// UploadFile загружает файл на Google Cloud в указанную удаленную директорию.
+// UploadFile загружает файл на Google Cloud в указанную удаленную директорию частями. Если предыдущая загрузка
+// этого файла прервалась, она продолжается с последней принятой части.
 func (gc *GCloud) UploadFile(localPath, remotePath string) error {
 	// Открываем локальный файл для чтения.
 	file, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("не удалось открыть файл для чтения: %v", err)
+		return fmt.Errorf("не удалось открыть файл для чтения: %w", err)
 	}
 	defer file.Close()
 
+	// Создаем директории на Google Cloud, если они не существуют.
+	if err := gc.ensureDirectoriesExist(remotePath); err != nil {
+		return err
+	}
+	gCloudfolderID, err := gc.folderIDByPath(remotePath)
+	if err != nil {
+		return err
+	}
+
+	uploadURL, err := resumable.UploadURL(gc.client.BasePath)
+	if err != nil {
+		return fmt.Errorf("google Cloud API Upload: %w", err)
+	}
+	upload := &resumable.Upload{Client: gc.httpClient, URL: uploadURL, ChunkSize: gc.chunkSize, State: gc.state}
+	name := filepath.Base(localPath)
+	fileMetadata := &drive.File{
+		Name:    name,
+		Parents: []string{gCloudfolderID},
+	}
+	if err := upload.File(file, "gCloud/"+gCloudfolderID+"/"+name, fileMetadata, nil); err != nil {
+		return fmt.Errorf("google Cloud API Upload: не удалось загрузить файл: %w", err)
+	}
+
+	return nil
+}
+
+// UploadStream загружает на Google Cloud файл с именем name, читая его содержимое из r.
+func (gc *GCloud) UploadStream(r io.Reader, name string, remotePath string) error {
 	// Создаем директории на Google Cloud, если они не существуют.
 	if err := gc.ensureDirectoriesExist(remotePath); err != nil {
 		return err
@@ -59,14 +139,14 @@ func (gc *GCloud) UploadFile(localPath, remotePath string) error {
 
 	// Создаем метаданные для файла.
 	fileMetadata := &drive.File{
-		Name:    filepath.Base(localPath),
+		Name:    name,
 		Parents: []string{gCloudfolderID},
 	}
 
-	// Загружаем файл на Google Cloud.
-	_, err = gc.client.Files.Create(fileMetadata).Media(file).Do()
+	// Загружаем файл на Google Cloud. Поток нельзя перечитать, поэтому части повторяются только в пределах загрузки.
+	_, err = gc.client.Files.Create(fileMetadata).Media(r, googleapi.ChunkSize(int(gc.chunkSize))).Do()
 	if err != nil {
-		return fmt.Errorf("google Cloud API Upload: не удалось загрузить файл: %v", err)
+		return fmt.Errorf("google Cloud API Upload: не удалось загрузить файл: %w", err)
 	}
 
 	return nil
@@ -85,14 +165,14 @@ func (gc *GCloud) ensureDirectoriesExist(remotePath string) error {
 		// Проверяем, существует ли папка с текущим именем в текущей родительской папке.
 		folderID, err := gc.folderID(folderName, parentID)
 		if err != nil {
-			return fmt.Errorf("google Cloud API: не удалось получить идентификатор папки %s: %v", folderName, err)
+			return fmt.Errorf("google Cloud API: не удалось получить идентификатор папки %s: %w", folderName, err)
 		}
 
 		// Если папка не существует, создаем ее.
 		if folderID == "" {
 			newFolder, err := gc.createFolder(folderName, parentID)
 			if err != nil {
-				return fmt.Errorf("google Cloud API: не удалось создать папку %s: %v", folderName, err)
+				return fmt.Errorf("google Cloud API: не удалось создать папку %s: %w", folderName, err)
 			}
 			parentID = newFolder.Id
 		} else {
@@ -106,7 +186,7 @@ func (gc *GCloud) ensureDirectoriesExist(remotePath string) error {
 
 // folderID возвращает идентификатор папки по ее имени и родительскому идентификатору.
 func (gc *GCloud) folderID(folderName, parentID string) (string, error) {
-	query := fmt.Sprintf("mimeType='application/vnd.google-apps.folder' and name='%s' and '%s' in parents", folderName, parentID)
+	query := fmt.Sprintf("mimeType='application/vnd.google-apps.folder' and name='%s' and '%s' in parents", escape(folderName), parentID)
 	fileList, err := gc.client.Files.List().Q(query).Fields("files(id)").Do()
 	if err != nil {
 		return "", err
@@ -139,9 +219,9 @@ func (gc *GCloud) folderIDByPath(remotePath string) (string, error) {
 	// Перебираем каждый компонент пути.
 	for _, folderName := range pathСomponents {
 		// Выполняем запрос к Google Cloud API для получения списка папок в текущей родительской папке.
-		fileList, err := gc.client.Files.List().Q(fmt.Sprintf("'%s' in parents and mimeType='application/vnd.google-apps.folder' and name='%s'", parentID, folderName)).Do()
+		fileList, err := gc.client.Files.List().Q(fmt.Sprintf("'%s' in parents and mimeType='application/vnd.google-apps.folder' and name='%s'", parentID, escape(folderName))).Do()
 		if err != nil {
-			return "", fmt.Errorf("google Cloud API: не удалось получить список папок: %v", err)
+			return "", fmt.Errorf("google Cloud API: не удалось получить список папок: %w", err)
 		}
 
 		// Проверяем, найдена ли папка с заданным именем в текущей родительской папке.
@@ -162,22 +242,31 @@ func (gc *GCloud) ListDirItems(remotePath string) ([]cloudStorages.File, error)
 	// Получаем идентификатор папки по ее пути.
 	folderID, err := gc.folderIDByPath(remotePath)
 	if err != nil {
-		return nil, fmt.Errorf("google Cloud API: не удалось получить идентификатор папки по пути %s: %v", remotePath, err)
+		return nil, fmt.Errorf("google Cloud API: не удалось получить идентификатор папки по пути %s: %w", remotePath, err)
 	}
 
 	// Выполняем запрос к Google Cloud API для получения списка файлов и папок в указанной папке.
-	fileList, err := gc.client.Files.List().Q(fmt.Sprintf("'%s' in parents", folderID)).Do()
+	// Список запрашивается постранично до конца, файлы в корзине не выводятся.
+	var files []*drive.File
+	err = gc.client.Files.List().
+		Q(fmt.Sprintf("'%s' in parents and trashed=false", folderID)).
+		Fields("nextPageToken, files(id, name, size, parents, mimeType, md5Checksum)").
+		Pages(gc.ctx, func(list *drive.FileList) error {
+			files = append(files, list.Files...)
+			return nil
+		})
 	if err != nil {
-		return nil, fmt.Errorf("google Cloud API: не удалось получить список файлов и папок: %v", err)
+		return nil, fmt.Errorf("google Cloud API: не удалось получить список файлов и папок: %w", err)
 	}
 	var Items []cloudStorages.File
-	for _, file := range fileList.Files {
+	for _, file := range files {
 		item := &cloudStorages.File{
 			Id:       file.Id,
 			Name:     file.Name,
 			Size:     file.Size,
 			Parents:  file.Parents,
 			MimeType: file.MimeType,
+			MD5:      file.Md5Checksum,
 		}
 		Items = append(Items, *item)
 	}
@@ -185,3 +274,82 @@ func (gc *GCloud) ListDirItems(remotePath string) ([]cloudStorages.File, error)
 	// Возвращаем список файлов и папок.
 	return Items, nil
 }
+
+// DownloadFile скачивает файл с Google Cloud по его идентификатору в localPath.
+func (gc *GCloud) DownloadFile(fileID string, localPath string) error {
+	return cloudStorages.DownloadFile(gc, fileID, localPath)
+}
+
+// Open открывает файл fileID на Google Cloud для чтения.
+func (gc *GCloud) Open(fileID string) (io.ReadCloser, error) {
+	resp, err := gc.client.Files.Get(fileID).Download()
+	if err != nil {
+		return nil, fmt.Errorf("google Cloud API: не удалось скачать файл: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// OpenRange открывает на чтение length байт файла fileID на Google Cloud, начиная с offset.
+func (gc *GCloud) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	call := gc.client.Files.Get(fileID)
+	call.Header().Set("Range", cloudStorages.RangeHeader(offset, length))
+	resp, err := call.Download()
+	if err != nil {
+		return nil, fmt.Errorf("google Cloud API: не удалось прочитать часть файла: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// Stat возвращает информацию о файле на Google Cloud по его пути. Если файлов с таким именем несколько,
+// например после повторной загрузки, возвращается самый новый из них.
+func (gc *GCloud) Stat(remotePath string) (*cloudStorages.File, error) {
+	folderID := "root"
+	if dir := path.Dir(remotePath); dir != "." && dir != "/" {
+		var err error
+		folderID, err = gc.folderIDByPath(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := fmt.Sprintf("name='%s' and '%s' in parents and trashed=false", escape(path.Base(remotePath)), folderID)
+	fileList, err := gc.client.Files.List().Q(query).OrderBy("modifiedTime desc").
+		Fields("files(id, name, size, parents, mimeType, md5Checksum)").Do()
+	if err != nil {
+		return nil, fmt.Errorf("google Cloud API: не удалось получить информацию о файле %s: %w", remotePath, err)
+	}
+	if len(fileList.Files) == 0 {
+		return nil, fmt.Errorf("google Cloud API: файл %s не найден", remotePath)
+	}
+
+	file := fileList.Files[0]
+	return &cloudStorages.File{
+		Id:       file.Id,
+		Name:     file.Name,
+		Size:     file.Size,
+		Parents:  file.Parents,
+		MimeType: file.MimeType,
+		MD5:      file.Md5Checksum,
+	}, nil
+}
+
+// DeleteFile удаляет файл на Google Cloud по его идентификатору.
+func (gc *GCloud) DeleteFile(fileID string) error {
+	if err := gc.client.Files.Delete(fileID).Do(); err != nil {
+		return fmt.Errorf("google Cloud API: не удалось удалить файл: %w", err)
+	}
+	return nil
+}
+
+// DeleteDir удаляет папку на Google Cloud по ее идентификатору вместе со всем содержимым.
+func (gc *GCloud) DeleteDir(dirID string) error {
+	if err := gc.client.Files.Delete(dirID).Do(); err != nil {
+		return fmt.Errorf("google Cloud API: не удалось удалить папку: %w", err)
+	}
+	return nil
+}
+
+// escape экранирует строку для подстановки в запрос поиска файлов Google Drive API.
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
@@ -9,17 +9,50 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/resumable"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
+func init() {
+	cloudStorages.Register("gDrive", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		if settings.ApiKeyJson == "" {
+			return nil, fmt.Errorf("google Drive %v не настроен: не указан apiKeyJson", conf.Name)
+		}
+		storage, err := New(settings.ApiKeyJson, settings.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		if settings.ChunkSizeMB > 0 {
+			storage.chunkSize = int64(settings.ChunkSizeMB) << 20
+		}
+		storage.state = resumable.NewState(settings.StateFile)
+		return storage, nil
+	})
+}
+
+// Config содержит настройки хранилища типа gDrive.
+type Config struct {
+	ApiKeyJson  string `toml:"apiKeyJson"`  // Путь к ключу GDrive для атентификации по OAuth2.0
+	TokenFile   string `toml:"tokenFile"`   // Путь до токен файл где будет сохранен токен после атентификации
+	ChunkSizeMB int    `toml:"chunkSizeMB"` // Размер части возобновляемой загрузки в МБ, по умолчанию 16
+	StateFile   string `toml:"stateFile"`   // Файл с незавершенными загрузками, по умолчанию в папке кэша пользователя
+}
+
 // GDrive представляет клиент Google Drive.
 type GDrive struct {
 	config      *oauth2.Config // Конфигурация OAuth2 для аутентификации.
@@ -28,7 +61,9 @@ type GDrive struct {
 	oAuthServer *http.Server   // HTTP-сервер для аутентификации.
 	currentUser *drive.User    // Текущий пользователь Google Drive.
 
-	tokenFile string // Файл для сохранения токена доступа.
+	tokenFile string           // Файл для сохранения токена доступа.
+	chunkSize int64            // Размер части возобновляемой загрузки.
+	state     *resumable.State // Незавершенные загрузки.
 }
 
 // New создает новый экземпляр GDrive с заданным файлом учетных данных, и токеном клиента. Если токена нету он будет создан по переданному пути после атентификации.
@@ -36,13 +71,13 @@ func New(credentialsFile string, tokenFile string) (*GDrive, error) {
 	// Чтение данных учетной записи из файла.
 	credentials, err := os.ReadFile(credentialsFile)
 	if err != nil {
-		return nil, fmt.Errorf("не удалось прочитать файл с учетными данными: %v", err)
+		return nil, fmt.Errorf("не удалось прочитать файл с учетными данными: %w", err)
 	}
 
 	// Инициализация конфигурации OAuth2 из JSON-файла учетных данных.
 	config, err := google.ConfigFromJSON(credentials, drive.DriveScope)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка конфигурации OAuth 2.0: %v", err)
+		return nil, fmt.Errorf("ошибка конфигурации OAuth 2.0: %w", err)
 	}
 
 	server := &http.Server{
@@ -53,6 +88,8 @@ func New(credentialsFile string, tokenFile string) (*GDrive, error) {
 		config:      config,
 		oAuthServer: server,
 		tokenFile:   tokenFile,
+		chunkSize:   resumable.DefaultChunkSize,
+		state:       resumable.NewState(""),
 	}, nil
 }
 
@@ -66,13 +103,13 @@ func (gd *GDrive) NewClient() error {
 		gd.client = gd.config.Client(context.Background(), token)
 		gd.service, err = drive.NewService(context.Background(), option.WithHTTPClient(gd.client))
 		if err != nil {
-			return fmt.Errorf("ошибка создания клиента Google Drive API: %v", err)
+			return fmt.Errorf("ошибка создания клиента Google Drive API: %w", err)
 		}
 
 		// Получение информации о текущем пользователе.
 		user, err := gd.service.About.Get().Fields("user").Do()
 		if err != nil {
-			return fmt.Errorf("ошибка получения информации о пользователе: %v", err)
+			return fmt.Errorf("ошибка получения информации о пользователе: %w", err)
 		}
 		gd.currentUser = user.User
 
@@ -84,7 +121,7 @@ func (gd *GDrive) NewClient() error {
 	go func() {
 		err = gd.startOAuthServer()
 		if err != nil {
-			errChan <- fmt.Errorf("ошибка запуска сервера аутентификации: %v", err)
+			errChan <- fmt.Errorf("ошибка запуска сервера аутентификации: %w", err)
 			return
 		}
 		errChan <- nil
@@ -96,13 +133,13 @@ func (gd *GDrive) NewClient() error {
 	// Создание клиента Google Drive API.
 	gd.service, err = drive.NewService(context.Background(), option.WithHTTPClient(gd.client))
 	if err != nil {
-		return fmt.Errorf("ошибка создания клиента Google Drive API: %v", err)
+		return fmt.Errorf("ошибка создания клиента Google Drive API: %w", err)
 	}
 
 	// Получение информации о текущем пользователе.
 	user, err := gd.service.About.Get().Fields("user").Do()
 	if err != nil {
-		return fmt.Errorf("ошибка получения информации о пользователе: %v", err)
+		return fmt.Errorf("ошибка получения информации о пользователе: %w", err)
 	}
 	gd.currentUser = user.User
 
@@ -114,8 +151,11 @@ func (gd *GDrive) startOAuthServer() error {
 	// Вывод URL для аутентификации.
 	gd.printAuthURL()
 
-	// Обработчик для обработки обратного вызова аутентификации.
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// Обработчик для обработки обратного вызова аутентификации. У каждого экземпляра свой обработчик,
+	// чтобы несколько хранилищ Google Drive могли проходить аутентификацию по очереди.
+	mux := http.NewServeMux()
+	gd.oAuthServer.Handler = mux
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		state := r.URL.Query().Get("state")
 		code := r.URL.Query().Get("code")
 
@@ -150,7 +190,7 @@ func (gd *GDrive) startOAuthServer() error {
 
 	// Запуск HTTP-сервера аутентификации.
 	if err := gd.oAuthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		return fmt.Errorf("ошибка запуска сервера аутентификации Google Drive: %v", err)
+		return fmt.Errorf("ошибка запуска сервера аутентификации Google Drive: %w", err)
 	}
 
 	return nil
@@ -163,31 +203,57 @@ func (gd *GDrive) printAuthURL() {
 	fmt.Println(url)
 }
 
-// UploadFile загружает файл в Google Drive.
+// UploadFile загружает файл в Google Drive частями. Если предыдущая загрузка этого файла прервалась,
+// она продолжается с последней принятой части.
 func (gd *GDrive) UploadFile(localPath string, remotePath string) error {
 	// Открытие файла для чтения.
 	file, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("ошибка открытия файла: %v", err)
+		return fmt.Errorf("ошибка открытия файла: %w", err)
 	}
 	defer file.Close()
 
 	// Получение или создание папки для загрузки файла.
 	folder, err := gd.getOrCreateFolder(remotePath)
 	if err != nil {
-		return fmt.Errorf("ошибка получения или создания папки: %v", err)
+		return fmt.Errorf("ошибка получения или создания папки: %w", err)
+	}
+
+	uploadURL, err := resumable.UploadURL(gd.service.BasePath)
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки файла: %w", err)
+	}
+	upload := &resumable.Upload{Client: gd.client, URL: uploadURL, ChunkSize: gd.chunkSize, State: gd.state}
+	name := filepath.Base(localPath)
+	f := &drive.File{
+		Title:   name,
+		Parents: []*drive.ParentReference{{Id: folder.Id}},
+	}
+	if err := upload.File(file, "gDrive/"+folder.Id+"/"+name, f, nil); err != nil {
+		return fmt.Errorf("ошибка загрузки файла: %w", err)
+	}
+
+	return nil
+}
+
+// UploadStream загружает в Google Drive файл с именем name, читая его содержимое из r.
+func (gd *GDrive) UploadStream(r io.Reader, name string, remotePath string) error {
+	// Получение или создание папки для загрузки файла.
+	folder, err := gd.getOrCreateFolder(remotePath)
+	if err != nil {
+		return fmt.Errorf("ошибка получения или создания папки: %w", err)
 	}
 
 	// Создание объекта файла для загрузки.
 	f := &drive.File{
-		Title:   filepath.Base(localPath),
+		Title:   name,
 		Parents: []*drive.ParentReference{{Id: folder.Id}},
 	}
 
-	// Загрузка файла в Google Drive.
-	_, err = gd.service.Files.Insert(f).Media(file).Do()
+	// Загрузка файла в Google Drive. Поток нельзя перечитать, поэтому части повторяются только в пределах загрузки.
+	_, err = gd.service.Files.Insert(f).Media(r, googleapi.ChunkSize(int(gd.chunkSize))).Do()
 	if err != nil {
-		return fmt.Errorf("ошибка загрузки файла: %v", err)
+		return fmt.Errorf("ошибка загрузки файла: %w", err)
 	}
 
 	return nil
@@ -195,31 +261,77 @@ func (gd *GDrive) UploadFile(localPath string, remotePath string) error {
 
 // DownloadFile скачивает файл с Google Drive.
 func (gd *GDrive) DownloadFile(fileID string, localPath string) error {
-	// Создание файла для записи.
-	outFile, err := os.Create(localPath)
+	return cloudStorages.DownloadFile(gd, fileID, localPath)
+}
+
+// Open открывает файл fileID на Google Drive для чтения.
+func (gd *GDrive) Open(fileID string) (io.ReadCloser, error) {
+	resp, err := gd.service.Files.Get(fileID).Download()
 	if err != nil {
-		return fmt.Errorf("ошибка создания файла: %v", err)
+		return nil, fmt.Errorf("ошибка скачивания файла: %w", err)
 	}
-	defer outFile.Close()
+	return resp.Body, nil
+}
 
-	// Загрузка содержимого файла с Google Drive.
-	resp, err := gd.service.Files.Get(fileID).Download()
+// OpenRange открывает на чтение length байт файла fileID на Google Drive, начиная с offset.
+func (gd *GDrive) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	call := gd.service.Files.Get(fileID)
+	call.Header().Set("Range", cloudStorages.RangeHeader(offset, length))
+	resp, err := call.Download()
 	if err != nil {
-		return fmt.Errorf("ошибка скачивания файла: %v", err)
+		return nil, fmt.Errorf("ошибка чтения части файла: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// Stat возвращает информацию о файле на Google Drive по его пути. Google Drive допускает несколько файлов
+// с одинаковым именем, например после повторной загрузки, поэтому возвращается самый новый из них.
+func (gd *GDrive) Stat(remotePath string) (*cloudStorages.File, error) {
+	dir := path.Dir(remotePath)
+	if dir == "." {
+		dir = ""
+	}
+	folder, err := gd.getFolderByPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения папки: %w", err)
 	}
-	defer resp.Body.Close()
 
-	// Копирование содержимого файла в локальный файл.
-	_, err = io.Copy(outFile, resp.Body)
+	query := fmt.Sprintf("title='%s' and trashed=false and '%s' in parents", escape(path.Base(remotePath)), folder.Id)
+	files, err := gd.service.Files.List().Q(query).OrderBy("modifiedDate desc").Do()
 	if err != nil {
-		return fmt.Errorf("ошибка записи файла: %v", err)
+		return nil, fmt.Errorf("ошибка при поиске файла %s: %w", remotePath, err)
+	}
+	if len(files.Items) == 0 {
+		return nil, fmt.Errorf("файл %s не найден", remotePath)
 	}
 
-	fmt.Printf("Файл успешно скачен и сохранен по пути: %s\n", localPath)
+	file := files.Items[0]
+	return &cloudStorages.File{
+		Id:       file.Id,
+		Name:     file.Title,
+		Size:     file.FileSize,
+		MimeType: file.MimeType,
+		MD5:      file.Md5Checksum,
+	}, nil
+}
+
+// DeleteFile удаляет файл с Google Drive по его идентификатору.
+func (gd *GDrive) DeleteFile(fileID string) error {
+	if err := gd.service.Files.Delete(fileID).Do(); err != nil {
+		return fmt.Errorf("ошибка удаления файла: %w", err)
+	}
+	return nil
+}
+
+// DeleteDir удаляет папку с Google Drive по ее идентификатору вместе со всем содержимым.
+func (gd *GDrive) DeleteDir(dirID string) error {
+	if err := gd.service.Files.Delete(dirID).Do(); err != nil {
+		return fmt.Errorf("ошибка удаления папки: %w", err)
+	}
 	return nil
 }
 
-// ListDirItems выводит список файлов в указанной папке.
+// ListDirItems выводит список файлов в указанной папке. Список запрашивается постранично до конца.
 func (gd *GDrive) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
 	// Если путь пустой, устанавливаем его как корневая папка.
 	if remotePath == "" {
@@ -229,26 +341,30 @@ func (gd *GDrive) ListDirItems(remotePath string) ([]cloudStorages.File, error)
 	// Получение ID папки по ее пути.
 	folder, err := gd.getFolderByPath(remotePath)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка получения папки: %v", err)
+		return nil, fmt.Errorf("ошибка получения папки: %w", err)
 	}
 
-	// Формирование запроса к API для получения списка файлов в указанной папке.
-	query := fmt.Sprintf("'%s' in parents", folder.Id)
-	files, err := gd.service.Files.List().Q(query).Do()
+	// Формирование запроса к API для получения списка файлов в указанной папке. Файлы в корзине не выводятся.
+	query := fmt.Sprintf("'%s' in parents and trashed=false", folder.Id)
+	var files []*drive.File
+	err = gd.service.Files.List().Q(query).Pages(context.Background(), func(list *drive.FileList) error {
+		files = append(files, list.Items...)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("ошибка при получении списка файлов: %v", err)
+		return nil, fmt.Errorf("ошибка при получении списка файлов: %w", err)
 	}
 
 	// Вывод списка файлов.
 	var items []cloudStorages.File
-	for _, file := range files.Items {
+	for _, file := range files {
 		// Преобразование списка родительских папок в путь.
 		var parents []string
 		for _, parent := range file.Parents {
 			// Получаем информацию о каждой родительской папке и добавляем ее имя в список.
 			parentInfo, err := gd.service.Files.Get(parent.Id).Fields("title").Do()
 			if err != nil {
-				return nil, fmt.Errorf("ошибка при получении информации о родительской папке: %v", err)
+				return nil, fmt.Errorf("ошибка при получении информации о родительской папке: %w", err)
 			}
 			parents = append(parents, parentInfo.Title)
 		}
@@ -259,10 +375,11 @@ func (gd *GDrive) ListDirItems(remotePath string) ([]cloudStorages.File, error)
 			Size:     file.FileSize,
 			Parents:  parents,
 			MimeType: file.MimeType,
+			MD5:      file.Md5Checksum,
 		}
 		items = append(items, *item)
 	}
-	return items, err
+	return items, nil
 }
 
 // getOrCreateFolder получает или создает папку по указанному пути.
@@ -278,10 +395,10 @@ func (gd *GDrive) getOrCreateFolder(remotePath string) (*drive.File, error) {
 		}
 
 		// Поиск папки среди дочерних элементов текущей папки.
-		query := fmt.Sprintf("title='%s' and trashed=false and mimeType='application/vnd.google-apps.folder' and '%s' in parents", folder, parent)
+		query := fmt.Sprintf("title='%s' and trashed=false and mimeType='application/vnd.google-apps.folder' and '%s' in parents", escape(folder), parent)
 		folderList, err := gd.service.Files.List().Q(query).Do()
 		if err != nil {
-			return nil, fmt.Errorf("ошибка при поиске папки %s: %v", folder, err)
+			return nil, fmt.Errorf("ошибка при поиске папки %s: %w", folder, err)
 		}
 
 		// Создание новой папки, если она не найдена.
@@ -293,7 +410,7 @@ func (gd *GDrive) getOrCreateFolder(remotePath string) (*drive.File, error) {
 			}
 			createdFolder, err := gd.service.Files.Insert(newFolder).Do()
 			if err != nil {
-				return nil, fmt.Errorf("ошибка при создании папки %s: %v", folder, err)
+				return nil, fmt.Errorf("ошибка при создании папки %s: %w", folder, err)
 			}
 			parent = createdFolder.Id
 		} else {
@@ -319,10 +436,10 @@ func (gd *GDrive) getFolderByPath(remotePath string) (*drive.File, error) {
 		}
 
 		// Поиск папки среди дочерних элементов текущей папки.
-		query := fmt.Sprintf("title='%s' and trashed=false and mimeType='application/vnd.google-apps.folder' and '%s' in parents", folder, parent)
+		query := fmt.Sprintf("title='%s' and trashed=false and mimeType='application/vnd.google-apps.folder' and '%s' in parents", escape(folder), parent)
 		folderList, err := gd.service.Files.List().Q(query).Do()
 		if err != nil {
-			return nil, fmt.Errorf("ошибка при поиске папки %s: %v", folder, err)
+			return nil, fmt.Errorf("ошибка при поиске папки %s: %w", folder, err)
 		}
 
 		// Возврат ошибки, если папка не найдена.
@@ -338,14 +455,19 @@ func (gd *GDrive) getFolderByPath(remotePath string) (*drive.File, error) {
 	return &drive.File{Id: parent}, nil
 }
 
+// escape экранирует строку для подстановки в запрос поиска файлов Google Drive.
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
 func (gd *GDrive) saveToken(token *oauth2.Token) error {
 	tokenData, err := json.Marshal(token)
 	if err != nil {
-		return fmt.Errorf("ошибка при сериализации токена: %v", err)
+		return fmt.Errorf("ошибка при сериализации токена: %w", err)
 	}
 
 	if err := os.WriteFile(gd.tokenFile, tokenData, 0644); err != nil {
-		return fmt.Errorf("ошибка при сохранении токена в файл: %v", err)
+		return fmt.Errorf("ошибка при сохранении токена в файл: %w", err)
 	}
 
 	return nil
@@ -355,12 +477,12 @@ func (gd *GDrive) saveToken(token *oauth2.Token) error {
 func (gd *GDrive) loadToken() (*oauth2.Token, error) {
 	tokenData, err := os.ReadFile(gd.tokenFile)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка при чтении токена из файла: %v", err)
+		return nil, fmt.Errorf("ошибка при чтении токена из файла: %w", err)
 	}
 
 	var token oauth2.Token
 	if err := json.Unmarshal(tokenData, &token); err != nil {
-		return nil, fmt.Errorf("ошибка при десериализации токена: %v", err)
+		return nil, fmt.Errorf("ошибка при десериализации токена: %w", err)
 	}
 
 	return &token, nil
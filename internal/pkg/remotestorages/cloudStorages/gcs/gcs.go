@@ -0,0 +1,303 @@
+// Пакет gcs реализует хранилище резервных копий в бакете Google Cloud Storage.
+//
+// Папки хранилища соответствуют префиксам имен объектов: архив remotePath/name сохраняется
+// в объект <prefix>/<remotePath>/<name>. Архивы загружаются возобновляемой загрузкой частями
+// по chunkSizeMB, после загрузки контрольные суммы MD5 и CRC32C объекта сверяются с посчитанными
+// при отправке, а SHA-256 архива сохраняется в метаданные объекта.
+package gcs
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// DefaultChunkSizeMB - размер части возобновляемой загрузки по умолчанию в мегабайтах.
+const DefaultChunkSizeMB = 16
+
+// SHA256Metadata - ключ метаданных объекта с контрольной суммой SHA-256 архива.
+const SHA256Metadata = "sha256"
+
+func init() {
+	cloudStorages.Register("gcs", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		return New(*settings)
+	})
+}
+
+// Config содержит настройки хранилища типа gcs.
+type Config struct {
+	CredentialsJSON string `toml:"credentials_json"` // Путь к JSON-файлу сервисного аккаунта, если не указан - учетные данные по умолчанию
+	Bucket          string `toml:"bucket"`           // Имя бакета
+	Prefix          string `toml:"prefix"`           // Префикс имен объектов внутри бакета
+	StorageClass    string `toml:"storageClass"`     // Класс хранения объектов: STANDARD, NEARLINE, COLDLINE, ARCHIVE
+	ChunkSizeMB     int    `toml:"chunkSizeMB"`      // Размер части возобновляемой загрузки в мегабайтах
+	Endpoint        string `toml:"endpoint"`         // Адрес API, например эмулятора GCS; по умолчанию storage.googleapis.com
+}
+
+// GCS представляет хранилище в бакете Google Cloud Storage.
+type GCS struct {
+	conf      Config
+	chunkSize int // Размер части возобновляемой загрузки в байтах
+	ctx       context.Context
+	service   *storage.Service
+}
+
+// New создает хранилище GCS с настройками conf. Клиент создается методом NewClient.
+func New(conf Config) (*GCS, error) {
+	if conf.Bucket == "" {
+		return nil, fmt.Errorf("gcs: не указан бакет")
+	}
+	if conf.ChunkSizeMB < 0 {
+		return nil, fmt.Errorf("gcs: некорректный размер части загрузки %v", conf.ChunkSizeMB)
+	}
+	if conf.ChunkSizeMB == 0 {
+		conf.ChunkSizeMB = DefaultChunkSizeMB
+	}
+	conf.Prefix = strings.Trim(conf.Prefix, "/")
+	conf.StorageClass = strings.ToUpper(conf.StorageClass)
+
+	return &GCS{conf: conf, chunkSize: conf.ChunkSizeMB << 20, ctx: context.Background()}, nil
+}
+
+// NewClient создает клиента Cloud Storage API и проверяет доступ к бакету.
+func (g *GCS) NewClient() error {
+	var options []option.ClientOption
+	switch {
+	case g.conf.CredentialsJSON != "":
+		options = append(options, option.WithCredentialsFile(g.conf.CredentialsJSON))
+	case g.conf.Endpoint != "":
+		// Эмуляторы GCS не требуют аутентификации
+		options = append(options, option.WithoutAuthentication())
+	}
+	if g.conf.Endpoint != "" {
+		options = append(options, option.WithEndpoint(strings.TrimSuffix(g.conf.Endpoint, "/")+"/storage/v1/"))
+	}
+
+	service, err := storage.NewService(g.ctx, options...)
+	if err != nil {
+		return fmt.Errorf("gcs NewClient: не удалось создать клиент: %w", err)
+	}
+	if _, err := service.Buckets.Get(g.conf.Bucket).Do(); err != nil {
+		return fmt.Errorf("gcs NewClient: нет доступа к бакету %v: %w", g.conf.Bucket, err)
+	}
+
+	g.service = service
+	return nil
+}
+
+// object возвращает имя объекта для пути remotePath внутри хранилища.
+func (g *GCS) object(remotePath string) string {
+	return strings.Trim(path.Join(g.conf.Prefix, remotePath), "/")
+}
+
+// UploadFile загружает локальный файл localPath в папку remotePath.
+func (g *GCS) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл для чтения: %w", err)
+	}
+	defer file.Close()
+
+	return g.UploadStream(file, filepath.Base(localPath), remotePath)
+}
+
+// UploadStream загружает в папку remotePath объект с именем name, читая его содержимое из r.
+// Если контрольные суммы загруженного объекта не совпадают с отправленными данными, объект удаляется.
+func (g *GCS) UploadStream(r io.Reader, name string, remotePath string) error {
+	objectName := g.object(path.Join(remotePath, name))
+	sums := newChecksums()
+
+	object := &storage.Object{
+		Name:         objectName,
+		ContentType:  "application/octet-stream",
+		StorageClass: g.conf.StorageClass,
+	}
+	uploaded, err := g.service.Objects.Insert(g.conf.Bucket, object).
+		Media(io.TeeReader(r, sums), googleapi.ChunkSize(g.chunkSize), googleapi.ContentType("application/octet-stream")).
+		Context(g.ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("gcs: не удалось загрузить объект %v: %w", objectName, err)
+	}
+
+	if err := sums.verify(uploaded); err != nil {
+		g.service.Objects.Delete(g.conf.Bucket, objectName).Do()
+		return fmt.Errorf("gcs: объект %v поврежден при загрузке и удален: %w", objectName, err)
+	}
+
+	// Сохраняем SHA-256 архива в метаданные объекта, он известен только после чтения всего потока
+	patch := &storage.Object{Metadata: map[string]string{SHA256Metadata: sums.sha256()}}
+	if _, err := g.service.Objects.Patch(g.conf.Bucket, objectName, patch).Do(); err != nil {
+		return fmt.Errorf("gcs: не удалось сохранить контрольную сумму объекта %v: %w", objectName, err)
+	}
+	return nil
+}
+
+// ListDirItems возвращает объекты и вложенные папки (префиксы) в папке remotePath.
+func (g *GCS) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	prefix := g.object(remotePath)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var items []cloudStorages.File
+	err := g.service.Objects.List(g.conf.Bucket).Prefix(prefix).Delimiter("/").Pages(g.ctx, func(objects *storage.Objects) error {
+		for _, dir := range objects.Prefixes {
+			items = append(items, cloudStorages.File{
+				Id:   dir,
+				Name: strings.TrimSuffix(strings.TrimPrefix(dir, prefix), "/"),
+				Dir:  true,
+			})
+		}
+		for _, object := range objects.Items {
+			items = append(items, toFile(object))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gcs: не удалось получить список объектов %v: %w", prefix, err)
+	}
+	return items, nil
+}
+
+// Stat возвращает информацию об объекте по его пути в хранилище.
+func (g *GCS) Stat(remotePath string) (*cloudStorages.File, error) {
+	objectName := g.object(remotePath)
+	object, err := g.service.Objects.Get(g.conf.Bucket, objectName).Do()
+	if err != nil {
+		return nil, fmt.Errorf("gcs: не удалось получить информацию об объекте %v: %w", objectName, err)
+	}
+	file := toFile(object)
+	return &file, nil
+}
+
+// DownloadFile скачивает объект с именем fileID в файл localPath.
+func (g *GCS) DownloadFile(fileID string, localPath string) error {
+	resp, err := g.service.Objects.Get(g.conf.Bucket, fileID).Download()
+	if err != nil {
+		return fmt.Errorf("gcs: не удалось скачать объект %v: %w", fileID, err)
+	}
+	defer resp.Body.Close()
+
+	outFile, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("не удалось создать файл: %w", err)
+	}
+	defer outFile.Close()
+
+	if _, err := io.Copy(outFile, resp.Body); err != nil {
+		return fmt.Errorf("не удалось записать файл: %w", err)
+	}
+	return nil
+}
+
+// OpenRange открывает на чтение length байт объекта с именем fileID, начиная с offset.
+func (g *GCS) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	call := g.service.Objects.Get(g.conf.Bucket, fileID)
+	call.Header().Set("Range", cloudStorages.RangeHeader(offset, length))
+	resp, err := call.Download()
+	if err != nil {
+		return nil, fmt.Errorf("gcs: не удалось прочитать часть объекта %v: %w", fileID, err)
+	}
+	return resp.Body, nil
+}
+
+// DeleteFile удаляет объект с именем fileID.
+func (g *GCS) DeleteFile(fileID string) error {
+	if err := g.service.Objects.Delete(g.conf.Bucket, fileID).Do(); err != nil {
+		return fmt.Errorf("gcs: не удалось удалить объект %v: %w", fileID, err)
+	}
+	return nil
+}
+
+// DeleteDir удаляет все объекты с префиксом dirID.
+func (g *GCS) DeleteDir(dirID string) error {
+	prefix := strings.TrimSuffix(dirID, "/") + "/"
+	var names []string
+	err := g.service.Objects.List(g.conf.Bucket).Prefix(prefix).Pages(g.ctx, func(objects *storage.Objects) error {
+		for _, object := range objects.Items {
+			names = append(names, object.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("gcs: не удалось получить список объектов %v: %w", prefix, err)
+	}
+	for _, name := range names {
+		if err := g.DeleteFile(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toFile преобразует объект GCS в описание файла хранилища.
+// MD5 в GCS хранится в base64, у составных объектов его нет.
+func toFile(object *storage.Object) cloudStorages.File {
+	file := cloudStorages.File{
+		Id:       object.Name,
+		Name:     path.Base(object.Name),
+		Size:     int64(object.Size),
+		MimeType: object.ContentType,
+	}
+	if sum, err := base64.StdEncoding.DecodeString(object.Md5Hash); err == nil && len(sum) == md5.Size {
+		file.MD5 = hex.EncodeToString(sum)
+	}
+	return file
+}
+
+// checksums считает контрольные суммы отправляемых данных.
+type checksums struct {
+	md5, sha hash.Hash
+	crc32c   hash.Hash32
+	io.Writer
+}
+
+func newChecksums() *checksums {
+	c := &checksums{md5: md5.New(), crc32c: crc32.New(crc32.MakeTable(crc32.Castagnoli)), sha: sha256.New()}
+	c.Writer = io.MultiWriter(c.md5, c.crc32c, c.sha)
+	return c
+}
+
+// verify сравнивает контрольные суммы загруженного объекта с посчитанными при отправке.
+func (c *checksums) verify(object *storage.Object) error {
+	if object.Md5Hash != "" {
+		if sum := base64.StdEncoding.EncodeToString(c.md5.Sum(nil)); sum != object.Md5Hash {
+			return fmt.Errorf("MD5 не совпадает: отправлено %v, в хранилище %v", sum, object.Md5Hash)
+		}
+	}
+	if object.Crc32c != "" {
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, c.crc32c.Sum32())
+		if sum := base64.StdEncoding.EncodeToString(crc); sum != object.Crc32c {
+			return fmt.Errorf("CRC32C не совпадает: отправлено %v, в хранилище %v", sum, object.Crc32c)
+		}
+	}
+	return nil
+}
+
+// sha256 возвращает SHA-256 отправленных данных в шестнадцатеричном виде.
+func (c *checksums) sha256() string {
+	return hex.EncodeToString(c.sha.Sum(nil))
+}
@@ -0,0 +1,289 @@
+package gcs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/storagetest"
+	"google.golang.org/api/storage/v1"
+)
+
+// fakeGCS - минимальный сервер Cloud Storage JSON API в памяти.
+type fakeGCS struct {
+	mu       sync.Mutex
+	url      string
+	bucket   string
+	objects  map[string]*storage.Object
+	data     map[string][]byte
+	sessions map[string]*session
+	chunks   int  // количество частей возобновляемых загрузок
+	corrupt  bool // портить данные при сохранении
+}
+
+// session описывает возобновляемую загрузку.
+type session struct {
+	object *storage.Object
+	data   []byte
+}
+
+func newFakeGCS(bucket string) *fakeGCS {
+	return &fakeGCS{bucket: bucket, objects: map[string]*storage.Object{}, data: map[string][]byte{}, sessions: map[string]*session{}}
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	escaped := r.URL.EscapedPath()
+	bucketPath := "/storage/v1/b/" + f.bucket
+	uploadPath := "/upload/storage/v1/b/" + f.bucket + "/o"
+	query := r.URL.Query()
+
+	switch {
+	case escaped == bucketPath && r.Method == http.MethodGet:
+		writeJSON(w, &storage.Bucket{Name: f.bucket})
+	case escaped == uploadPath && query.Get("uploadType") == "multipart":
+		f.multipart(w, r)
+	case escaped == uploadPath && query.Get("upload_id") == "":
+		object := &storage.Object{}
+		json.NewDecoder(r.Body).Decode(object)
+		id := strconv.Itoa(len(f.sessions) + 1)
+		f.sessions[id] = &session{object: object}
+		w.Header().Set("Location", f.url+uploadPath+"?uploadType=resumable&upload_id="+id)
+	case escaped == uploadPath:
+		f.chunk(w, r, f.sessions[query.Get("upload_id")])
+	case escaped == bucketPath+"/o" && r.Method == http.MethodGet:
+		f.list(w, query.Get("prefix"), query.Get("delimiter"))
+	case strings.HasPrefix(escaped, bucketPath+"/o/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(escaped, bucketPath+"/o/"))
+		object, ok := f.objects[name]
+		if !ok {
+			http.Error(w, `{"error":{"code":404,"message":"Not Found"}}`, http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(f.objects, name)
+			delete(f.data, name)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPatch:
+			patch := &storage.Object{}
+			json.NewDecoder(r.Body).Decode(patch)
+			object.Metadata = patch.Metadata
+			writeJSON(w, object)
+		case query.Get("alt") == "media":
+			http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(f.data[name]))
+		default:
+			writeJSON(w, object)
+		}
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+// multipart сохраняет объект, загруженный одним запросом multipart/related.
+func (f *fakeGCS) multipart(w http.ResponseWriter, r *http.Request) {
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	object := &storage.Object{}
+	part, _ := reader.NextPart()
+	json.NewDecoder(part).Decode(object)
+	part, _ = reader.NextPart()
+	data, _ := io.ReadAll(part)
+	writeJSON(w, f.store(object, data))
+}
+
+// chunk принимает часть возобновляемой загрузки.
+func (f *fakeGCS) chunk(w http.ResponseWriter, r *http.Request, s *session) {
+	data, _ := io.ReadAll(r.Body)
+	s.data = append(s.data, data...)
+	f.chunks++
+
+	// Content-Range: bytes 0-99/* для промежуточных частей и bytes 100-149/150 для последней
+	contentRange := r.Header.Get("Content-Range")
+	if strings.HasSuffix(contentRange, "/*") {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.data)-1))
+		return
+	}
+	writeJSON(w, f.store(s.object, s.data))
+}
+
+// store сохраняет объект и вычисляет его контрольные суммы, как это делает Cloud Storage.
+func (f *fakeGCS) store(object *storage.Object, data []byte) *storage.Object {
+	md5sum := md5.Sum(data)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	if f.corrupt {
+		data = append([]byte{}, data...)
+		data[0] ^= 0xff
+		md5sum = md5.Sum(data)
+	}
+
+	object.Bucket = f.bucket
+	object.Size = uint64(len(data))
+	object.Md5Hash = base64.StdEncoding.EncodeToString(md5sum[:])
+	object.Crc32c = base64.StdEncoding.EncodeToString(crc)
+	f.objects[object.Name] = object
+	f.data[object.Name] = data
+	return object
+}
+
+// list возвращает объекты и префиксы, как Objects.List с разделителем.
+func (f *fakeGCS) list(w http.ResponseWriter, prefix, delimiter string) {
+	names := make([]string, 0, len(f.objects))
+	for name := range f.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := &storage.Objects{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			dir := prefix + rest[:i+len(delimiter)]
+			if !seen[dir] {
+				seen[dir] = true
+				result.Prefixes = append(result.Prefixes, dir)
+			}
+			continue
+		}
+		result.Items = append(result.Items, f.objects[name])
+	}
+	writeJSON(w, result)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func newTestGCS(t *testing.T) (*GCS, *fakeGCS) {
+	fake := newFakeGCS("backups")
+	server := httptest.NewServer(fake)
+	fake.url = server.URL
+	t.Cleanup(server.Close)
+
+	bucket, err := New(Config{Bucket: "backups", Prefix: "kk", StorageClass: "nearline", Endpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	// Минимальный размер части, чтобы проверить возобновляемую загрузку на небольшом архиве
+	bucket.chunkSize = 256 << 10
+	if err := bucket.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	return bucket, fake
+}
+
+func TestGCS(t *testing.T) {
+	bucket, fake := newTestGCS(t)
+
+	large := make([]byte, 1<<20+100)
+	rand.New(rand.NewSource(1)).Read(large)
+	if err := bucket.UploadStream(bytes.NewReader(large), "15-12:00-nginx.tar.gz", "host/nginx/2024-03"); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if fake.chunks < 4 {
+		t.Errorf("Ожидалась возобновляемая загрузка минимум из 4 частей, полученно частей: %v", fake.chunks)
+	}
+	object := fake.objects["kk/host/nginx/2024-03/15-12:00-nginx.tar.gz"]
+	if object == nil || !bytes.Equal(fake.data[object.Name], large) {
+		t.Fatalf("Объект не загружен или поврежден")
+	}
+	if object.StorageClass != "NEARLINE" {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", "NEARLINE", object.StorageClass)
+	}
+	if object.Metadata[SHA256Metadata] == "" {
+		t.Errorf("В метаданных объекта нет контрольной суммы SHA-256")
+	}
+
+	localPath := filepath.Join(t.TempDir(), "20-13:37-nginx.zip")
+	if err := os.WriteFile(localPath, []byte("archive"), 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if err := bucket.UploadFile(localPath, "host/nginx/2024-02"); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	items, err := bucket.ListDirItems("host/nginx")
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if len(items) != 2 || items[0].Name != "2024-02" || !items[0].IsDir() || items[1].Name != "2024-03" {
+		t.Fatalf("Неверный список папок: %+v", items)
+	}
+
+	items, err = bucket.ListDirItems("host/nginx/2024-03")
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if len(items) != 1 || items[0].Name != "15-12:00-nginx.tar.gz" || items[0].Size != int64(len(large)) {
+		t.Fatalf("Неверный список архивов: %+v", items)
+	}
+
+	info, err := bucket.Stat("host/nginx/2024-02/20-13:37-nginx.zip")
+	if sum := md5.Sum([]byte("archive")); err != nil || info.Size != int64(len("archive")) || info.MD5 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Неверная информация об объекте: %+v, %v", info, err)
+	}
+
+	downloaded := filepath.Join(t.TempDir(), "restore.tar.gz")
+	if err := bucket.DownloadFile(items[0].Id, downloaded); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if data, err := os.ReadFile(downloaded); err != nil || !bytes.Equal(data, large) {
+		t.Errorf("Скачанный архив не совпадает с загруженным: %v", err)
+	}
+
+	if err := bucket.DeleteFile(items[0].Id); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := bucket.DeleteDir("kk/host/nginx/2024-02/"); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("Все объекты должны быть удалены, осталось: %v", len(fake.objects))
+	}
+}
+
+func TestGCSChecksumMismatch(t *testing.T) {
+	bucket, fake := newTestGCS(t)
+	fake.corrupt = true
+
+	if err := bucket.UploadStream(strings.NewReader("archive"), "01-10:00-nginx.zip", "host/nginx/2024-03"); err == nil {
+		t.Errorf("Ожидалась ошибка несовпадения контрольной суммы, но ее не было")
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("Поврежденный объект должен быть удален")
+	}
+}
+
+func TestGCSConformance(t *testing.T) {
+	bucket, _ := newTestGCS(t)
+	storagetest.Run(t, bucket)
+}
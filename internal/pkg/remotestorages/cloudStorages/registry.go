@@ -0,0 +1,51 @@
+package cloudStorages
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+)
+
+// Factory создает хранилище по его настройкам. Клиент хранилища при этом еще не создается.
+type Factory func(conf *config.Storage) (Storage, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register регистрирует тип хранилища storageType. Пакеты хранилищ вызывают Register в init,
+// после чего тип можно указывать в блоке [storage.<name>]. Повторная регистрация типа вызывает панику.
+func Register(storageType string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[storageType]; ok {
+		panic(fmt.Sprintf("cloudStorages: тип хранилища %v уже зарегистрирован", storageType))
+	}
+	registry[storageType] = factory
+}
+
+// NewStorage создает хранилище по настройкам conf, используя зарегистрированный тип conf.Type.
+func NewStorage(conf *config.Storage) (Storage, error) {
+	registryMu.RLock()
+	factory, ok := registry[conf.Type]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("тип хранилища %v не поддерживается", conf.Type)
+	}
+	return factory(conf)
+}
+
+// Registered возвращает отсортированный список зарегистрированных типов хранилищ.
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,327 @@
+// Пакет resumable реализует возобновляемую загрузку файлов частями по протоколу Google API
+// (uploadType=resumable), который используют Google Drive и Google Cloud.
+//
+// Адрес сессии загрузки сохраняется в файл состояния, поэтому загрузка, прерванная обрывом связи
+// или перезапуском демона, при следующей попытке продолжается с последней принятой сервером части.
+// Файл состояния используют и другие хранилища с возобновляемой загрузкой, например FTP.
+package resumable
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// DefaultChunkSize - размер части загрузки по умолчанию. Размер части должен быть кратен 256 КиБ.
+const DefaultChunkSize = 16 << 20
+
+// chunkAlign - кратность размера части, которую требует Google API.
+const chunkAlign = 256 << 10
+
+// sessionTTL - срок жизни сессии загрузки Google API.
+const sessionTTL = 7 * 24 * time.Hour
+
+// statusResumeIncomplete - ответ сервера на принятую часть, после которой загрузка еще не завершена.
+const statusResumeIncomplete = 308
+
+// Upload описывает возобновляемую загрузку файлов в одно хранилище.
+type Upload struct {
+	Client    *http.Client // Авторизованный HTTP клиент
+	URL       string       // Адрес создания сессии загрузки, например https://www.googleapis.com/upload/drive/v3/files?uploadType=resumable
+	ChunkSize int64        // Размер части, округляется вниз до кратного 256 КиБ
+	State     *State       // Файл состояния с адресами незавершенных сессий
+}
+
+// UploadURL возвращает адрес создания сессии возобновляемой загрузки для API с базовым адресом basePath,
+// например https://www.googleapis.com/drive/v3/.
+func UploadURL(basePath string) (string, error) {
+	u, err := url.Parse(basePath)
+	if err != nil {
+		return "", err
+	}
+	u.Path = "/upload" + strings.TrimSuffix(u.Path, "/") + "/files"
+	u.RawQuery = "uploadType=resumable"
+	return u.String(), nil
+}
+
+// File загружает file с метаданными metadata и декодирует ответ сервера о созданном файле в result.
+// Незавершенная сессия ищется в файле состояния по ключу key и используется, если размер и время изменения
+// файла не изменились. При ошибке сессия остается в файле состояния для следующей попытки.
+func (u *Upload) File(file *os.File, key string, metadata interface{}, result interface{}) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("не удалось получить информацию о файле: %w", err)
+	}
+	size := info.Size()
+
+	var offset int64
+	session, ok := u.State.Load(key)
+	if ok && session.Size == size && session.ModTime.Equal(info.ModTime()) && time.Since(session.Created) < sessionTTL {
+		var done bool
+		offset, done, err = u.query(session.URI, size, result)
+		if err != nil {
+			return err
+		}
+		if done {
+			return u.State.Remove(key)
+		}
+	} else {
+		session = nil
+	}
+
+	// Сессии нет или она устарела, начинаем загрузку заново
+	if offset < 0 || session == nil {
+		uri, err := u.start(metadata, size)
+		if err != nil {
+			return err
+		}
+		session = &Session{URI: uri, Size: size, ModTime: info.ModTime(), Created: time.Now()}
+		if err := u.State.Save(key, session); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	chunkSize := u.ChunkSize / chunkAlign * chunkAlign
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	for {
+		n := size - offset
+		if n > chunkSize {
+			n = chunkSize
+		}
+		next, done, err := u.put(session.URI, io.NewSectionReader(file, offset, n), offset, n, size, result)
+		if err != nil {
+			return fmt.Errorf("ошибка загрузки части с позиции %v: %w", offset, err)
+		}
+		if done {
+			return u.State.Remove(key)
+		}
+		if next < 0 {
+			// Сессия истекла во время загрузки, при следующей попытке будет создана новая
+			u.State.Remove(key)
+			return fmt.Errorf("сессия загрузки истекла")
+		}
+		offset = next
+	}
+}
+
+// start создает сессию загрузки и возвращает ее адрес.
+func (u *Upload) start(metadata interface{}, size int64) (string, error) {
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("не удалось сформировать метаданные файла: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, u.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", "application/octet-stream")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("не удалось создать сессию загрузки: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("не удалось создать сессию загрузки: %w", responseError(resp))
+	}
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return "", fmt.Errorf("сервер не вернул адрес сессии загрузки")
+	}
+	return uri, nil
+}
+
+// query запрашивает у сервера, сколько байт уже принято в сессии uri. Возвращает -1, если сессия истекла.
+func (u *Upload) query(uri string, size int64, result interface{}) (int64, bool, error) {
+	return u.put(uri, nil, -1, 0, size, result)
+}
+
+// put отправляет часть body длиной n с позиции offset и возвращает позицию следующей части.
+// Если offset отрицателен, отправляется пустой запрос состояния сессии. Возвращает done, если загрузка завершена,
+// и -1, если сессия истекла.
+func (u *Upload) put(uri string, body io.Reader, offset, n, size int64, result interface{}) (int64, bool, error) {
+	req, err := http.NewRequest(http.MethodPut, uri, body)
+	if err != nil {
+		return 0, false, err
+	}
+	req.ContentLength = n
+	if offset < 0 || n == 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+	}
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		if result != nil {
+			if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+				return 0, false, fmt.Errorf("не удалось разобрать ответ сервера: %w", err)
+			}
+		}
+		return size, true, nil
+	case resp.StatusCode == statusResumeIncomplete:
+		return received(resp.Header.Get("Range")), false, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return -1, false, nil
+	default:
+		return 0, false, responseError(resp)
+	}
+}
+
+// received возвращает количество принятых сервером байт по заголовку Range вида "bytes=0-1234".
+func received(header string) int64 {
+	_, last, ok := strings.Cut(strings.TrimPrefix(header, "bytes="), "-")
+	if !ok {
+		return 0
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return end + 1
+}
+
+// responseError формирует ошибку *googleapi.Error по неожиданному ответу сервера, чтобы по коду ответа
+// можно было определить временную ошибку.
+func responseError(resp *http.Response) error {
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	return &googleapi.Error{Code: resp.StatusCode, Message: resp.Status}
+}
+
+// Session описывает незавершенную сессию загрузки.
+type Session struct {
+	URI     string    `json:"uri"`     // Адрес сессии загрузки или путь временного файла на сервере
+	Size    int64     `json:"size"`    // Размер загружаемого файла
+	ModTime time.Time `json:"modTime"` // Время изменения загружаемого файла
+	Created time.Time `json:"created"` // Время создания сессии
+}
+
+// stateMu защищает файлы состояния: несколько хранилищ могут использовать один файл.
+var stateMu sync.Mutex
+
+// State хранит незавершенные сессии загрузки в JSON файле.
+type State struct {
+	path string
+}
+
+// NewState возвращает состояние, хранящееся в файле path. Если path пустой, используется DefaultStatePath.
+func NewState(path string) *State {
+	if path == "" {
+		path = DefaultStatePath()
+	}
+	return &State{path: path}
+}
+
+// DefaultStatePath возвращает путь к файлу состояния по умолчанию в папке кэша пользователя.
+func DefaultStatePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "KronosKeeper", "uploads.json")
+}
+
+// read читает все сессии из файла состояния. Отсутствующий файл означает отсутствие сессий.
+func (s *State) read() (map[string]*Session, error) {
+	sessions := map[string]*Session{}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать файл состояния загрузок: %w", err)
+	}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("не удалось разобрать файл состояния загрузок %v: %w", s.path, err)
+	}
+	return sessions, nil
+}
+
+// write записывает сессии в файл состояния через временный файл.
+func (s *State) write(sessions map[string]*Session) error {
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("не удалось создать папку файла состояния загрузок: %w", err)
+	}
+	// Адрес сессии позволяет загружать данные без авторизации, поэтому файл доступен только владельцу
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("не удалось записать файл состояния загрузок: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("не удалось записать файл состояния загрузок: %w", err)
+	}
+	return nil
+}
+
+// Load возвращает сессию по ключу key.
+func (s *State) Load(key string) (*Session, bool) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	sessions, err := s.read()
+	if err != nil {
+		return nil, false
+	}
+	session, ok := sessions[key]
+	return session, ok
+}
+
+// Save сохраняет сессию по ключу key и удаляет сессии старше недели.
+func (s *State) Save(key string, session *Session) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	sessions, err := s.read()
+	if err != nil {
+		// Поврежденный файл состояния не должен мешать загрузке, перезаписываем его
+		sessions = map[string]*Session{}
+	}
+	for k, old := range sessions {
+		if time.Since(old.Created) >= sessionTTL {
+			delete(sessions, k)
+		}
+	}
+	sessions[key] = session
+	return s.write(sessions)
+}
+
+// Remove удаляет сессию по ключу key.
+func (s *State) Remove(key string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	sessions, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := sessions[key]; !ok {
+		return nil
+	}
+	delete(sessions, key)
+	return s.write(sessions)
+}
@@ -0,0 +1,150 @@
+package resumable
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUpload - сервер возобновляемой загрузки Google API в памяти.
+type fakeUpload struct {
+	mu       sync.Mutex
+	url      string
+	sessions map[string][]byte // Принятые данные по идентификатору сессии
+	started  int               // Количество созданных сессий
+	failAt   int               // Номер части, на которой сервер отвечает ошибкой, 0 - без ошибок
+	chunks   int               // Количество принятых частей
+}
+
+func (f *fakeUpload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodPost {
+		f.started++
+		id := strconv.Itoa(f.started)
+		f.sessions[id] = nil
+		w.Header().Set("Location", f.url+"/session/"+id)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/session/")
+	data, ok := f.sessions[id]
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	contentRange := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+	span, total, _ := strings.Cut(contentRange, "/")
+	size, _ := strconv.Atoi(total)
+	if span != "*" {
+		f.chunks++
+		if f.chunks == f.failAt {
+			http.Error(w, "backend error", http.StatusServiceUnavailable)
+			return
+		}
+		var start int
+		fmt.Sscanf(span, "%d-", &start)
+		if start != len(data) {
+			http.Error(w, "wrong offset", http.StatusBadRequest)
+			return
+		}
+		data = append(data, body...)
+		f.sessions[id] = data
+	}
+
+	if len(data) == size {
+		fmt.Fprintf(w, `{"id": "file-%s", "size": "%d"}`, id, size)
+		return
+	}
+	if len(data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(data)-1))
+	}
+	w.WriteHeader(statusResumeIncomplete)
+}
+
+func TestUploadResume(t *testing.T) {
+	fake := &fakeUpload{sessions: map[string][]byte{}, failAt: 3}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	fake.url = server.URL
+
+	data := make([]byte, 4*chunkAlign+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	localPath := filepath.Join(t.TempDir(), "15-12:00-nginx.tar.gz")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		t.Fatalf("Ошибка открытия файла: %v", err)
+	}
+	defer file.Close()
+
+	statePath := filepath.Join(t.TempDir(), "state", "uploads.json")
+	upload := &Upload{Client: server.Client(), URL: server.URL + "/upload", ChunkSize: chunkAlign, State: NewState(statePath)}
+	metadata := map[string]string{"name": "15-12:00-nginx.tar.gz"}
+
+	// Третья часть не принимается, загрузка прерывается, а сессия остается в файле состояния
+	var result struct{ Id string }
+	if err := upload.File(file, "key", metadata, &result); err == nil {
+		t.Fatalf("Ожидалась ошибка загрузки")
+	}
+	if session, ok := NewState(statePath).Load("key"); !ok || session.Size != int64(len(data)) {
+		t.Fatalf("Сессия должна была сохраниться в файле состояния: %+v", session)
+	}
+
+	// Новый экземпляр, как после перезапуска демона, продолжает ту же сессию
+	upload = &Upload{Client: server.Client(), URL: server.URL + "/upload", ChunkSize: chunkAlign, State: NewState(statePath)}
+	if err := upload.File(file, "key", metadata, &result); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if fake.started != 1 || result.Id != "file-1" {
+		t.Errorf("Загрузка должна была продолжиться в той же сессии: сессий %v, файл %v", fake.started, result.Id)
+	}
+	if !bytes.Equal(fake.sessions["1"], data) {
+		t.Errorf("Загруженные данные не совпадают с файлом")
+	}
+	if _, ok := NewState(statePath).Load("key"); ok {
+		t.Errorf("Завершенная сессия должна была быть удалена из файла состояния")
+	}
+
+	// Если сессия на сервере истекла, загрузка начинается заново
+	fake.failAt = 0
+	info, _ := file.Stat()
+	expired := &Session{URI: server.URL + "/session/missing", Size: info.Size(), ModTime: info.ModTime(), Created: time.Now()}
+	if err := NewState(statePath).Save("key2", expired); err != nil {
+		t.Fatalf("Ошибка записи файла состояния: %v", err)
+	}
+	if err := upload.File(file, "key2", metadata, &result); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if fake.started != 2 || !bytes.Equal(fake.sessions["2"], data) {
+		t.Errorf("Ожидалась новая сессия загрузки, создано сессий: %v", fake.started)
+	}
+}
+
+func TestUploadURL(t *testing.T) {
+	testCases := map[string]string{
+		"https://www.googleapis.com/drive/v3/": "https://www.googleapis.com/upload/drive/v3/files?uploadType=resumable",
+		"https://www.googleapis.com/drive/v2/": "https://www.googleapis.com/upload/drive/v2/files?uploadType=resumable",
+	}
+	for basePath, expect := range testCases {
+		uploadURL, err := UploadURL(basePath)
+		if err != nil || uploadURL != expect {
+			t.Errorf("Тест не пройден ожидалось: %v, полученно: %v (%v)", expect, uploadURL, err)
+		}
+	}
+}
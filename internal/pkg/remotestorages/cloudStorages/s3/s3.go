@@ -0,0 +1,284 @@
+// Пакет s3 реализует хранилище резервных копий в Amazon S3 и S3-совместимых хранилищах, например MinIO.
+//
+// Папки хранилища соответствуют префиксам ключей объектов: архив remotePath/name сохраняется
+// в объект <prefix>/<remotePath>/<name> бакета. Большие архивы загружаются по частям (multipart upload).
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// DefaultEndpoint используется, если в настройках хранилища не указан endpoint.
+const DefaultEndpoint = "https://s3.amazonaws.com"
+
+// DefaultPartSizeMB - размер части multipart загрузки по умолчанию в мегабайтах.
+const DefaultPartSizeMB = 64
+
+func init() {
+	cloudStorages.Register("s3", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		return New(*settings)
+	})
+}
+
+// Config содержит настройки хранилища типа s3.
+type Config struct {
+	Bucket       string `toml:"bucket"`       // Имя бакета
+	Prefix       string `toml:"prefix"`       // Префикс ключей объектов внутри бакета
+	Endpoint     string `toml:"endpoint"`     // Адрес хранилища, например https://minio.local:9000
+	Region       string `toml:"region"`       // Регион бакета
+	PathStyle    bool   `toml:"pathStyle"`    // Обращаться к бакету по пути (endpoint/bucket), а не по имени хоста
+	AccessKey    string `toml:"accessKey"`    // Ключ доступа, если не указан - берется из переменных окружения AWS_*
+	SecretKey    string `toml:"secretKey"`    // Секретный ключ
+	SessionToken string `toml:"sessionToken"` // Временный токен сессии
+	PartSizeMB   uint64 `toml:"partSizeMB"`   // Размер части multipart загрузки в мегабайтах, не меньше 5
+}
+
+// S3 представляет хранилище в бакете S3.
+type S3 struct {
+	conf   Config
+	ctx    context.Context
+	client *minio.Client
+}
+
+// New создает хранилище S3 с настройками conf. Клиент создается методом NewClient.
+func New(conf Config) (*S3, error) {
+	if conf.Bucket == "" {
+		return nil, fmt.Errorf("s3: не указан бакет")
+	}
+	if conf.Endpoint == "" {
+		conf.Endpoint = DefaultEndpoint
+	}
+	if conf.PartSizeMB == 0 {
+		conf.PartSizeMB = DefaultPartSizeMB
+	}
+	if conf.PartSizeMB < 5 {
+		return nil, fmt.Errorf("s3: размер части multipart загрузки не может быть меньше 5 МБ")
+	}
+	conf.Prefix = strings.Trim(conf.Prefix, "/")
+
+	return &S3{conf: conf, ctx: context.Background()}, nil
+}
+
+// NewClient создает клиента S3 и проверяет, что бакет существует.
+func (s *S3) NewClient() error {
+	endpoint, secure, err := parseEndpoint(s.conf.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	creds := credentials.NewEnvAWS()
+	if s.conf.AccessKey != "" {
+		creds = credentials.NewStaticV4(s.conf.AccessKey, s.conf.SecretKey, s.conf.SessionToken)
+	}
+	lookup := minio.BucketLookupAuto
+	if s.conf.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       secure,
+		Region:       s.conf.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return fmt.Errorf("s3 NewClient: не удалось создать клиент: %w", err)
+	}
+
+	exists, err := client.BucketExists(s.ctx, s.conf.Bucket)
+	if err != nil {
+		return fmt.Errorf("s3 NewClient: не удалось проверить бакет %v: %w", s.conf.Bucket, err)
+	}
+	if !exists {
+		return fmt.Errorf("s3 NewClient: бакет %v не существует", s.conf.Bucket)
+	}
+
+	s.client = client
+	return nil
+}
+
+// parseEndpoint возвращает адрес хоста и признак TLS. Адрес без схемы считается https.
+func parseEndpoint(endpoint string) (string, bool, error) {
+	if !strings.Contains(endpoint, "://") {
+		return strings.TrimSuffix(endpoint, "/"), true, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, fmt.Errorf("s3: некорректный endpoint %v: %w", endpoint, err)
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host, true, nil
+	case "http":
+		return u.Host, false, nil
+	default:
+		return "", false, fmt.Errorf("s3: неподдерживаемая схема endpoint %v", endpoint)
+	}
+}
+
+// key возвращает ключ объекта для пути remotePath внутри хранилища.
+func (s *S3) key(remotePath string) string {
+	return strings.Trim(path.Join(s.conf.Prefix, remotePath), "/")
+}
+
+// putOptions возвращает параметры загрузки объекта. Каждая часть отправляется с заголовком Content-MD5,
+// поэтому сервер отклоняет части, поврежденные при передаче.
+func (s *S3) putOptions() minio.PutObjectOptions {
+	return minio.PutObjectOptions{
+		ContentType:    "application/octet-stream",
+		PartSize:       s.conf.PartSizeMB << 20,
+		SendContentMd5: true,
+	}
+}
+
+// UploadFile загружает локальный файл localPath в папку remotePath.
+func (s *S3) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл для чтения: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("не удалось получить размер файла: %w", err)
+	}
+
+	key := s.key(path.Join(remotePath, filepath.Base(localPath)))
+	if _, err := s.client.PutObject(s.ctx, s.conf.Bucket, key, file, info.Size(), s.putOptions()); err != nil {
+		return fmt.Errorf("s3: не удалось загрузить объект %v: %w", key, err)
+	}
+	return nil
+}
+
+// UploadStream загружает в папку remotePath объект с именем name, читая его содержимое из r.
+// Размер потока заранее неизвестен, поэтому архивы больше размера части загружаются по частям.
+func (s *S3) UploadStream(r io.Reader, name string, remotePath string) error {
+	key := s.key(path.Join(remotePath, name))
+	if _, err := s.client.PutObject(s.ctx, s.conf.Bucket, key, r, -1, s.putOptions()); err != nil {
+		return fmt.Errorf("s3: не удалось загрузить объект %v: %w", key, err)
+	}
+	return nil
+}
+
+// ListDirItems возвращает объекты и вложенные папки (префиксы) в папке remotePath.
+func (s *S3) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	prefix := s.key(remotePath)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var items []cloudStorages.File
+	for object := range s.client.ListObjects(s.ctx, s.conf.Bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("s3: не удалось получить список объектов %v: %w", prefix, object.Err)
+		}
+		items = append(items, cloudStorages.File{
+			Id:       object.Key,
+			Name:     strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), "/"),
+			Size:     object.Size,
+			MimeType: object.ContentType,
+			Dir:      strings.HasSuffix(object.Key, "/"),
+		})
+	}
+	return items, nil
+}
+
+// Stat возвращает информацию об объекте по его пути в хранилище.
+func (s *S3) Stat(remotePath string) (*cloudStorages.File, error) {
+	key := s.key(remotePath)
+	info, err := s.client.StatObject(s.ctx, s.conf.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("s3: не удалось получить информацию об объекте %v: %w", key, err)
+	}
+	return &cloudStorages.File{
+		Id:       info.Key,
+		Name:     path.Base(info.Key),
+		Size:     info.Size,
+		MimeType: info.ContentType,
+		MD5:      etagMD5(info),
+	}, nil
+}
+
+// etagMD5 возвращает MD5 объекта из его ETag. ETag совпадает с MD5 только у объектов, загруженных одной частью
+// и не зашифрованных ключом KMS или ключом клиента, для остальных возвращается пустая строка.
+func etagMD5(info minio.ObjectInfo) string {
+	etag := strings.ToLower(strings.Trim(info.ETag, `"`))
+	if len(etag) != 2*md5.Size || strings.Contains(etag, "-") {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	if sse := info.Metadata.Get("X-Amz-Server-Side-Encryption"); sse != "" && sse != "AES256" {
+		return ""
+	}
+	if info.Metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "" {
+		return ""
+	}
+	return etag
+}
+
+// DownloadFile скачивает объект с ключом fileID в файл localPath.
+func (s *S3) DownloadFile(fileID string, localPath string) error {
+	if err := s.client.FGetObject(s.ctx, s.conf.Bucket, fileID, localPath, minio.GetObjectOptions{}); err != nil {
+		return fmt.Errorf("s3: не удалось скачать объект %v: %w", fileID, err)
+	}
+	return nil
+}
+
+// OpenRange открывает на чтение length байт объекта с ключом fileID, начиная с offset.
+func (s *S3) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	// Core выполняет один запрос с заголовком Range, в отличие от Client.GetObject, который
+	// откладывает запрос до первого чтения и сам управляет диапазоном
+	object, _, _, err := (&minio.Core{Client: s.client}).GetObject(s.ctx, s.conf.Bucket, fileID, opts)
+	if err != nil {
+		return nil, fmt.Errorf("s3: не удалось прочитать часть объекта %v: %w", fileID, err)
+	}
+	return object, nil
+}
+
+// DeleteFile удаляет объект с ключом fileID.
+func (s *S3) DeleteFile(fileID string) error {
+	if err := s.client.RemoveObject(s.ctx, s.conf.Bucket, fileID, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("s3: не удалось удалить объект %v: %w", fileID, err)
+	}
+	return nil
+}
+
+// DeleteDir удаляет все объекты с префиксом dirID. Пустых папок в S3 не бывает,
+// поэтому после удаления объектов папка исчезает сама.
+func (s *S3) DeleteDir(dirID string) error {
+	prefix := strings.TrimSuffix(dirID, "/") + "/"
+	for object := range s.client.ListObjects(s.ctx, s.conf.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("s3: не удалось получить список объектов %v: %w", prefix, object.Err)
+		}
+		if err := s.DeleteFile(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/storagetest"
+)
+
+// fakeS3 - минимальный S3-совместимый сервер в памяти с адресацией бакета по пути.
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	parts   int             // количество загруженных частей multipart загрузок
+	multi   map[string]bool // объекты, собранные из частей, их ETag не совпадает с MD5
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}, multi: map[string]bool{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	// Как и S3, отклоняем данные, не совпадающие с заголовком Content-MD5
+	if sum := r.Header.Get("Content-MD5"); sum != "" {
+		if actual := md5.Sum(body); sum != base64.StdEncoding.EncodeToString(actual[:]) {
+			writeError(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case key == "" && query.Has("location"):
+		fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`)
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, query.Get("prefix"), query.Get("delimiter"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, bucket, key, id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][number] = body
+		f.parts++
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, number))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts := f.uploads[query.Get("uploadId")]
+		numbers := make([]int, 0, len(parts))
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, number := range numbers {
+			data = append(data, parts[number]...)
+		}
+		f.objects[key] = data
+		f.multi[key] = true
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"multipart"</ETag></CompleteMultipartUploadResult>`, bucket, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		delete(f.multi, key)
+		w.Header().Set("ETag", f.etag(key))
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", f.etag(key))
+		// ServeContent отвечает на запросы с заголовком Range, как S3
+		http.ServeContent(w, r, key, time.Now(), bytes.NewReader(data))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// etag возвращает ETag объекта key: MD5 для объектов, загруженных одной частью.
+func (f *fakeS3) etag(key string) string {
+	if f.multi[key] {
+		return `"multipart-3"`
+	}
+	sum := md5.Sum(f.objects[key])
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// list отвечает на запрос ListObjectsV2.
+func (f *fakeS3) list(w http.ResponseWriter, prefix, delimiter string) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		Delimiter      string
+		KeyCount       int
+		MaxKeys        int
+		IsTruncated    bool
+		Contents       []content
+		CommonPrefixes []commonPrefix
+	}{Name: f.bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: 1000}
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			dir := prefix + rest[:i+len(delimiter)]
+			if !seen[dir] {
+				seen[dir] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: dir})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: time.Now().UTC().Format(time.RFC3339),
+			ETag:         `"object"`,
+			Size:         len(f.objects[key]),
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// readBody читает тело запроса, раскодируя потоковую подпись aws-chunked, которую minio-go использует без TLS.
+func readBody(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2) // данные и \r\n
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func newTestS3(t *testing.T) (*S3, *fakeS3) {
+	fake := newFakeS3("backups")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	storage, err := New(Config{
+		Bucket:     "backups",
+		Prefix:     "/kk/",
+		Endpoint:   server.URL,
+		Region:     "us-east-1",
+		PathStyle:  true,
+		AccessKey:  "access",
+		SecretKey:  "secret",
+		PartSizeMB: 5,
+	})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	return storage, fake
+}
+
+func TestS3(t *testing.T) {
+	storage, fake := newTestS3(t)
+
+	// Архив больше части загрузки уходит по частям
+	large := make([]byte, 11<<20)
+	rand.New(rand.NewSource(1)).Read(large)
+	if err := storage.UploadStream(bytes.NewReader(large), "15-12:00-nginx.tar.gz", "host/nginx/2024-03"); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if fake.parts < 3 {
+		t.Errorf("Ожидалась multipart загрузка минимум из 3 частей, полученно частей: %v", fake.parts)
+	}
+	if !bytes.Equal(fake.objects["kk/host/nginx/2024-03/15-12:00-nginx.tar.gz"], large) {
+		t.Errorf("Содержимое объекта не совпадает с загруженным архивом")
+	}
+
+	localPath := filepath.Join(t.TempDir(), "20-13:37-nginx.zip")
+	if err := os.WriteFile(localPath, []byte("archive"), 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if err := storage.UploadFile(localPath, "host/nginx/2024-02"); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	// Папки юнита - общие префиксы ключей
+	items, err := storage.ListDirItems("host/nginx")
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if len(items) != 2 || items[0].Name != "2024-02" || !items[0].IsDir() || items[1].Name != "2024-03" {
+		t.Fatalf("Неверный список папок: %+v", items)
+	}
+
+	items, err = storage.ListDirItems("host/nginx/2024-03")
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if len(items) != 1 || items[0].Name != "15-12:00-nginx.tar.gz" || items[0].IsDir() || items[0].Size != int64(len(large)) {
+		t.Fatalf("Неверный список архивов: %+v", items)
+	}
+
+	// У объекта из частей ETag не является MD5
+	info, err := storage.Stat("host/nginx/2024-03/15-12:00-nginx.tar.gz")
+	if err != nil || info.Size != int64(len(large)) || info.MD5 != "" {
+		t.Errorf("Неверная информация об объекте: %+v, %v", info, err)
+	}
+	sum := md5.Sum([]byte("archive"))
+	if info, err := storage.Stat("host/nginx/2024-02/20-13:37-nginx.zip"); err != nil || info.MD5 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Тест не пройден ожидалось: %x, полученно: %+v, %v", sum, info, err)
+	}
+
+	downloaded := filepath.Join(t.TempDir(), "restore.tar.gz")
+	if err := storage.DownloadFile(items[0].Id, downloaded); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	data, err := os.ReadFile(downloaded)
+	if err != nil || !bytes.Equal(data, large) {
+		t.Errorf("Скачанный архив не совпадает с загруженным: %v", err)
+	}
+
+	if err := storage.DeleteFile(items[0].Id); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.DeleteDir("kk/host/nginx/2024-02/"); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("Все объекты должны быть удалены, осталось: %v", len(fake.objects))
+	}
+}
+
+func TestNewClientMissingBucket(t *testing.T) {
+	server := httptest.NewServer(newFakeS3("other"))
+	defer server.Close()
+
+	storage, err := New(Config{Bucket: "backups", Endpoint: server.URL, Region: "us-east-1", PathStyle: true, AccessKey: "a", SecretKey: "s"})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err == nil {
+		t.Errorf("Ожидалась ошибка для несуществующего бакета, но ее не было")
+	}
+}
+
+func TestS3Conformance(t *testing.T) {
+	storage, _ := newTestS3(t)
+	storagetest.Run(t, storage)
+}
@@ -0,0 +1,402 @@
+// Пакет ftp реализует хранилище резервных копий на сервере FTP или FTPS.
+//
+// Архивы сохраняются в baseDir/<remotePath>/<name>. Передача данных всегда выполняется в пассивном режиме
+// (EPSV или PASV). Архив загружается во временный файл и переименовывается после сверки размера.
+// Если загрузка файла прервалась, при следующей попытке она продолжается с места обрыва командой REST.
+// Размер и время изменения загружаемого файла сохраняются в файл состояния, поэтому продолжается только
+// загрузка того же файла, а временный файл другой загрузки удаляется и архив загружается заново.
+package ftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/resumable"
+	ftpclient "github.com/jlaffaye/ftp"
+)
+
+func init() {
+	cloudStorages.Register("ftp", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		return New(*settings)
+	})
+}
+
+// Config содержит настройки хранилища типа ftp.
+type Config struct {
+	Host               string `toml:"host"`               // Адрес сервера
+	Port               int    `toml:"port"`               // Порт, по умолчанию 21, для implicit TLS - 990
+	User               string `toml:"user"`               // Имя пользователя
+	Password           string `toml:"password"`           // Пароль
+	TLS                string `toml:"tls"`                // "explicit" - FTPS через AUTH TLS, "implicit" - FTPS на отдельном порту, пусто - без шифрования
+	CAFile             string `toml:"caFile"`             // Сертификат центра сертификации для самоподписанного сертификата сервера
+	InsecureSkipVerify bool   `toml:"insecureSkipVerify"` // Не проверять сертификат сервера
+	DisableEPSV        bool   `toml:"disableEPSV"`        // Использовать только PASV, если сервер за NAT неверно отвечает на EPSV
+	BaseDir            string `toml:"baseDir"`            // Папка на сервере, в которой хранятся резервные копии
+	Timeout            int    `toml:"timeout"`            // Таймаут подключения в секундах, по умолчанию 30
+	StateFile          string `toml:"stateFile"`          // Файл с незавершенными загрузками, по умолчанию в папке кэша пользователя
+}
+
+// FTP представляет хранилище на FTP сервере.
+type FTP struct {
+	conf  Config
+	conn  *ftpclient.ServerConn
+	state *resumable.State // Незавершенные загрузки.
+}
+
+// New создает хранилище FTP с настройками conf. Подключение выполняется методом NewClient.
+func New(conf Config) (*FTP, error) {
+	if conf.Host == "" || conf.User == "" {
+		return nil, fmt.Errorf("ftp: не указан host или user")
+	}
+	switch conf.TLS {
+	case "", "explicit":
+		if conf.Port == 0 {
+			conf.Port = 21
+		}
+	case "implicit":
+		if conf.Port == 0 {
+			conf.Port = 990
+		}
+	default:
+		return nil, fmt.Errorf("ftp: неизвестный режим tls %q, ожидается explicit или implicit", conf.TLS)
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 30
+	}
+	return &FTP{conf: conf, state: resumable.NewState(conf.StateFile)}, nil
+}
+
+// NewClient подключается к серверу, при необходимости включает TLS и выполняет вход.
+func (f *FTP) NewClient() error {
+	options := []ftpclient.DialOption{
+		ftpclient.DialWithTimeout(time.Duration(f.conf.Timeout) * time.Second),
+		ftpclient.DialWithDisabledEPSV(f.conf.DisableEPSV),
+	}
+	if f.conf.TLS != "" {
+		tlsConfig, err := f.tlsConfig()
+		if err != nil {
+			return err
+		}
+		if f.conf.TLS == "explicit" {
+			options = append(options, ftpclient.DialWithExplicitTLS(tlsConfig))
+		} else {
+			options = append(options, ftpclient.DialWithTLS(tlsConfig))
+		}
+	}
+
+	addr := net.JoinHostPort(f.conf.Host, strconv.Itoa(f.conf.Port))
+	conn, err := ftpclient.Dial(addr, options...)
+	if err != nil {
+		return fmt.Errorf("ftp: не удалось подключиться к %v: %w", addr, err)
+	}
+	if err := conn.Login(f.conf.User, f.conf.Password); err != nil {
+		conn.Quit()
+		return fmt.Errorf("ftp: ошибка входа на %v: %w", addr, err)
+	}
+	f.conn = conn
+	return nil
+}
+
+// tlsConfig возвращает настройки TLS для подключения к серверу.
+func (f *FTP) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         f.conf.Host,
+		InsecureSkipVerify: f.conf.InsecureSkipVerify,
+		// Серверы FTPS обычно требуют, чтобы соединение данных продолжало TLS сессию управляющего соединения
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	if f.conf.CAFile != "" {
+		pem, err := os.ReadFile(f.conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ftp: не удалось прочитать caFile: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ftp: в caFile %v нет сертификатов", f.conf.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// Close завершает сессию FTP.
+func (f *FTP) Close() error {
+	if f.conn == nil {
+		return nil
+	}
+	err := f.conn.Quit()
+	f.conn = nil
+	return err
+}
+
+// abs возвращает путь на сервере для пути remotePath внутри хранилища.
+func (f *FTP) abs(remotePath string) string {
+	return path.Join(f.conf.BaseDir, remotePath)
+}
+
+// UploadFile загружает локальный файл localPath в папку remotePath. Если на сервере остался временный файл
+// от прерванной загрузки этого же файла, загрузка продолжается с его размера.
+func (f *FTP) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл для чтения: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("не удалось получить информацию о файле: %w", err)
+	}
+
+	dir, err := f.mkdirAll(remotePath)
+	if err != nil {
+		return err
+	}
+	part := path.Join(dir, filepath.Base(localPath)) + cloudStorages.PartSuffix
+
+	offset, err := f.resume(part, info)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("не удалось перейти к позиции %v в файле: %w", offset, err)
+	}
+	// Временный файл не удаляется при ошибке, чтобы следующая попытка продолжила загрузку
+	if err := f.conn.StorFrom(part, file, uint64(offset)); err != nil {
+		return fmt.Errorf("ftp: ошибка загрузки файла %v с позиции %v: %w", part, offset, err)
+	}
+
+	if err := f.commit(part, info.Size()); err != nil {
+		return err
+	}
+	return f.state.Remove(f.stateKey(part))
+}
+
+// resume возвращает позицию, с которой продолжается загрузка файла info во временный файл part.
+// Загрузка продолжается, только если по файлу состояния part остался от загрузки файла того же размера
+// и с тем же временем изменения. Иначе временный файл удаляется, а в файл состояния записывается новая загрузка.
+func (f *FTP) resume(part string, info os.FileInfo) (int64, error) {
+	key := f.stateKey(part)
+	if session, ok := f.state.Load(key); ok && session.Size == info.Size() && session.ModTime.Equal(info.ModTime()) {
+		if size, err := f.conn.FileSize(part); err == nil && size > 0 && size <= info.Size() {
+			return size, nil
+		}
+	}
+
+	// Временный файл мог остаться от другого архива с тем же именем или от архива, измененного после обрыва
+	f.conn.Delete(part)
+	session := &resumable.Session{URI: part, Size: info.Size(), ModTime: info.ModTime(), Created: time.Now()}
+	if err := f.state.Save(key, session); err != nil {
+		return 0, fmt.Errorf("ftp: %w", err)
+	}
+	return 0, nil
+}
+
+// stateKey возвращает ключ загрузки во временный файл part в файле состояния.
+func (f *FTP) stateKey(part string) string {
+	return fmt.Sprintf("ftp://%v@%v%v", f.conf.User, net.JoinHostPort(f.conf.Host, strconv.Itoa(f.conf.Port)), path.Join("/", part))
+}
+
+// UploadStream загружает в папку remotePath файл с именем name, читая его содержимое из r.
+// Поток нельзя перечитать, поэтому прерванная потоковая загрузка начинается заново.
+func (f *FTP) UploadStream(r io.Reader, name string, remotePath string) error {
+	dir, err := f.mkdirAll(remotePath)
+	if err != nil {
+		return err
+	}
+	part := path.Join(dir, name) + cloudStorages.PartSuffix
+
+	counter := &countingReader{Reader: r}
+	if err := f.conn.Stor(part, counter); err != nil {
+		f.conn.Delete(part)
+		return fmt.Errorf("ftp: ошибка загрузки файла %v: %w", part, err)
+	}
+	return f.commit(part, counter.n)
+}
+
+// countingReader считает прочитанные байты.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// commit сверяет размер временного файла part с ожидаемым size и переименовывает его в архив.
+// Если сервер не поддерживает команду SIZE, размер не сверяется.
+func (f *FTP) commit(part string, size int64) error {
+	if remoteSize, err := f.conn.FileSize(part); err == nil && remoteSize != size {
+		f.conn.Delete(part)
+		return fmt.Errorf("ftp: размер файла %v на сервере %v байт, ожидалось %v байт", part, remoteSize, size)
+	}
+
+	target := strings.TrimSuffix(part, cloudStorages.PartSuffix)
+	// Не все серверы заменяют существующий файл при переименовании
+	f.conn.Delete(target)
+	if err := f.conn.Rename(part, target); err != nil {
+		return fmt.Errorf("ftp: не удалось переименовать %v в %v: %w", part, target, err)
+	}
+	return nil
+}
+
+// mkdirAll создает по очереди все папки пути remotePath внутри baseDir, которых еще нет, и возвращает путь папки.
+func (f *FTP) mkdirAll(remotePath string) (string, error) {
+	dir := f.conf.BaseDir
+	for _, folder := range strings.Split(remotePath, "/") {
+		if folder == "" {
+			continue
+		}
+		parent := dir
+		dir = path.Join(dir, folder)
+		if err := f.conn.MakeDir(dir); err == nil {
+			continue
+		}
+
+		// Ошибка MKD для существующей папки у разных серверов разная, поэтому проверяем папку по списку
+		entry, err := f.find(parent, folder)
+		if err != nil {
+			return "", fmt.Errorf("ftp: ошибка при создании папки %v: %w", dir, err)
+		}
+		if entry == nil || entry.Type != ftpclient.EntryTypeFolder {
+			return "", fmt.Errorf("ftp: не удалось создать папку %v", dir)
+		}
+	}
+	return dir, nil
+}
+
+// find ищет элемент name в папке dir. Если элемента нет, возвращается nil.
+func (f *FTP) find(dir, name string) (*ftpclient.Entry, error) {
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := f.conn.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListDirItems возвращает файлы и папки в папке remotePath, отсортированные по имени.
+func (f *FTP) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	dir := f.abs(remotePath)
+	entries, err := f.conn.List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ftp: не удалось прочитать папку %v: %w", dir, err)
+	}
+
+	var items []cloudStorages.File
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." || strings.HasSuffix(entry.Name, cloudStorages.PartSuffix) {
+			continue
+		}
+		if entry.Type != ftpclient.EntryTypeFile && entry.Type != ftpclient.EntryTypeFolder {
+			continue
+		}
+		items = append(items, toFile(path.Join(dir, entry.Name), entry))
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+// Stat возвращает информацию о файле по его пути в хранилище.
+func (f *FTP) Stat(remotePath string) (*cloudStorages.File, error) {
+	target := f.abs(remotePath)
+	entry, err := f.find(path.Dir(target), path.Base(target))
+	if err != nil {
+		return nil, fmt.Errorf("ftp: не удалось получить информацию о файле %v: %w", target, err)
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("ftp: файл %v не найден", target)
+	}
+	file := toFile(target, entry)
+	return &file, nil
+}
+
+// DownloadFile скачивает файл с путем fileID на сервере в localPath.
+func (f *FTP) DownloadFile(fileID string, localPath string) error {
+	return cloudStorages.DownloadFile(f, fileID, localPath)
+}
+
+// Open открывает на чтение файл с путем fileID на сервере. Пока файл не закрыт, другие команды
+// подключения недоступны.
+func (f *FTP) Open(fileID string) (io.ReadCloser, error) {
+	resp, err := f.conn.Retr(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("ftp: не удалось открыть файл %v: %w", fileID, err)
+	}
+	return resp, nil
+}
+
+// DeleteFile удаляет файл с путем fileID на сервере.
+func (f *FTP) DeleteFile(fileID string) error {
+	if err := f.conn.Delete(fileID); err != nil {
+		return fmt.Errorf("ftp: не удалось удалить файл %v: %w", fileID, err)
+	}
+	return nil
+}
+
+// DeleteDir удаляет папку с путем dirID на сервере вместе со всем содержимым.
+func (f *FTP) DeleteDir(dirID string) error {
+	if err := f.removeAll(dirID); err != nil {
+		return fmt.Errorf("ftp: не удалось удалить папку %v: %w", dirID, err)
+	}
+	return nil
+}
+
+// removeAll удаляет папку dir рекурсивно. В отличие от RemoveDirRecur не меняет текущую папку сессии.
+func (f *FTP) removeAll(dir string) error {
+	entries, err := f.conn.List(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		child := path.Join(dir, entry.Name)
+		if entry.Type == ftpclient.EntryTypeFolder {
+			err = f.removeAll(child)
+		} else {
+			err = f.conn.Delete(child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.conn.RemoveDir(dir)
+}
+
+// toFile преобразует элемент списка FTP в описание файла хранилища.
+func toFile(fullPath string, entry *ftpclient.Entry) cloudStorages.File {
+	return cloudStorages.File{
+		Id:   fullPath,
+		Name: entry.Name,
+		Size: int64(entry.Size),
+		Dir:  entry.Type == ftpclient.EntryTypeFolder,
+	}
+}
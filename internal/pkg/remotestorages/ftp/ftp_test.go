@@ -0,0 +1,415 @@
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/resumable"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/storagetest"
+)
+
+// fakeFTP - минимальный FTP сервер для тестов, хранящий файлы в папке root. Поддерживает только пассивный
+// режим, MLSD, REST и явный TLS (AUTH TLS).
+type fakeFTP struct {
+	root      string
+	password  string
+	tlsConfig *tls.Config
+
+	mu      sync.Mutex
+	rests   []int64 // Позиции, с которых продолжались загрузки
+	secured bool    // Последняя передача данных шла через TLS
+}
+
+// serve обслуживает одно управляющее соединение.
+func (f *fakeFTP) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var (
+		loggedIn bool
+		protect  bool
+		offset   int64
+		rename   string
+		listener net.Listener
+	)
+	// dataConn принимает соединение данных на порту, открытом командой EPSV или PASV
+	dataConn := func() (net.Conn, error) {
+		if listener == nil {
+			return nil, fmt.Errorf("не открыт порт данных")
+		}
+		defer func() { listener.Close(); listener = nil }()
+		data, err := listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		f.mu.Lock()
+		f.secured = protect
+		f.mu.Unlock()
+		if protect {
+			return tls.Server(data, f.tlsConfig), nil
+		}
+		return data, nil
+	}
+
+	reply("220 fake ftp")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command, arg, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+		local := filepath.Join(f.root, filepath.FromSlash(path.Clean("/"+arg)))
+
+		if !loggedIn && command != "USER" && command != "PASS" && command != "AUTH" && command != "QUIT" {
+			reply("530 Please login")
+			continue
+		}
+
+		switch command {
+		case "AUTH":
+			reply("234 AUTH TLS ok")
+			tlsConn := tls.Server(conn, f.tlsConfig)
+			conn, reader = tlsConn, bufio.NewReader(tlsConn)
+		case "USER":
+			reply("331 Password required")
+		case "PASS":
+			if arg != f.password {
+				reply("530 Login incorrect")
+				continue
+			}
+			loggedIn = true
+			reply("230 Logged in")
+		case "FEAT":
+			reply("211-Features:\r\n MLST type*;size*;\r\n SIZE\r\n REST STREAM\r\n UTF8\r\n211 End")
+		case "TYPE", "OPTS", "PBSZ":
+			reply("200 OK")
+		case "PROT":
+			protect = arg == "P"
+			reply("200 OK")
+		case "EPSV", "PASV":
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				reply("425 Can't open data connection")
+				continue
+			}
+			port := listener.Addr().(*net.TCPAddr).Port
+			if command == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				reply("227 Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256)
+			}
+		case "MKD":
+			if err := os.Mkdir(local, 0755); err != nil {
+				reply("550 %v", err)
+				continue
+			}
+			reply(`257 "%s" created`, arg)
+		case "RMD", "DELE":
+			info, err := os.Stat(local)
+			if err != nil || info.IsDir() != (command == "RMD") {
+				reply("550 Not found")
+				continue
+			}
+			if err := os.Remove(local); err != nil {
+				reply("550 %v", err)
+				continue
+			}
+			reply("250 OK")
+		case "SIZE":
+			info, err := os.Stat(local)
+			if err != nil || info.IsDir() {
+				reply("550 Not found")
+				continue
+			}
+			reply("213 %d", info.Size())
+		case "REST":
+			offset, _ = strconv.ParseInt(arg, 10, 64)
+			f.mu.Lock()
+			f.rests = append(f.rests, offset)
+			f.mu.Unlock()
+			reply("350 Restarting at %d", offset)
+		case "STOR":
+			reply("150 Ok to send data")
+			data, err := dataConn()
+			if err != nil {
+				reply("425 %v", err)
+				continue
+			}
+			err = f.store(local, data, offset)
+			data.Close()
+			offset = 0
+			if err != nil {
+				reply("451 %v", err)
+				continue
+			}
+			reply("226 Transfer complete")
+		case "RETR":
+			file, err := os.Open(local)
+			if err != nil {
+				reply("550 Not found")
+				continue
+			}
+			reply("150 Opening data connection")
+			data, err := dataConn()
+			if err == nil {
+				io.Copy(data, file)
+				data.Close()
+			}
+			file.Close()
+			reply("226 Transfer complete")
+		case "MLSD":
+			entries, err := os.ReadDir(local)
+			if err != nil {
+				reply("550 Not found")
+				continue
+			}
+			reply("150 Here comes the listing")
+			data, err := dataConn()
+			if err != nil {
+				reply("425 %v", err)
+				continue
+			}
+			for _, entry := range entries {
+				info, _ := entry.Info()
+				kind := "file"
+				if entry.IsDir() {
+					kind = "dir"
+				}
+				fmt.Fprintf(data, "type=%s;size=%d; %s\r\n", kind, info.Size(), entry.Name())
+			}
+			data.Close()
+			reply("226 Transfer complete")
+		case "RNFR":
+			rename = local
+			reply("350 Ready for RNTO")
+		case "RNTO":
+			if err := os.Rename(rename, local); err != nil {
+				reply("550 %v", err)
+				continue
+			}
+			reply("250 Renamed")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// store записывает поток data в файл local, начиная с позиции offset.
+func (f *fakeFTP) store(local string, data io.Reader, offset int64) error {
+	file, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(file, data)
+	return err
+}
+
+// startServer запускает FTP сервер с пользователем backup и возвращает его порт и путь к сертификату TLS.
+func startServer(t *testing.T, root string) (*fakeFTP, int, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Ошибка генерации ключа: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake ftp"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Ошибка создания сертификата: %v", err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Ошибка записи сертификата: %v", err)
+	}
+
+	server := &fakeFTP{
+		root:      root,
+		password:  "secret",
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка запуска сервера: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server, listener.Addr().(*net.TCPAddr).Port, caFile
+}
+
+// connect создает хранилище и подключается к тестовому серверу.
+func connect(t *testing.T, conf Config) *FTP {
+	if conf.StateFile == "" {
+		conf.StateFile = filepath.Join(t.TempDir(), "uploads.json")
+	}
+	storage, err := New(conf)
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestFTP(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "backups"), 0755); err != nil {
+		t.Fatalf("Ошибка при создании папки: %v", err)
+	}
+	_, port, _ := startServer(t, root)
+
+	storage := connect(t, Config{Host: "127.0.0.1", Port: port, User: "backup", Password: "secret", BaseDir: "/backups", DisableEPSV: true})
+	storagetest.Run(t, storage)
+}
+
+func TestFTPExplicitTLS(t *testing.T) {
+	server, port, caFile := startServer(t, t.TempDir())
+
+	storage := connect(t, Config{Host: "127.0.0.1", Port: port, User: "backup", Password: "secret", TLS: "explicit", CAFile: caFile})
+	storagetest.Run(t, storage)
+	if !server.secured {
+		t.Errorf("Передача данных должна была идти через TLS")
+	}
+
+	// Без сертификата центра сертификации самоподписанный сертификат сервера не принимается
+	untrusted, err := New(Config{Host: "127.0.0.1", Port: port, User: "backup", Password: "secret", TLS: "explicit"})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := untrusted.NewClient(); err == nil {
+		untrusted.Close()
+		t.Errorf("Ожидалась ошибка проверки сертификата")
+	}
+}
+
+func TestFTPResume(t *testing.T) {
+	root := t.TempDir()
+	server, port, _ := startServer(t, root)
+	storage := connect(t, Config{Host: "127.0.0.1", Port: port, User: "backup", Password: "secret"})
+
+	data := bytes.Repeat([]byte("kronos"), 100000)
+	localPath := filepath.Join(t.TempDir(), "15-12:00-nginx.tar.gz")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		t.Fatalf("Ошибка получения информации о файле: %v", err)
+	}
+	dir := filepath.Join(root, "host/nginx/2024-03")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Ошибка при создании папки: %v", err)
+	}
+	part := filepath.Join(dir, "15-12:00-nginx.tar.gz"+cloudStorages.PartSuffix)
+
+	// Временный файл без записи в файле состояния не продолжается, архив загружается заново
+	if err := os.WriteFile(part, []byte("другой архив"), 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if err := storage.UploadFile(localPath, "host/nginx/2024-03"); err != nil {
+		t.Fatalf("Ошибка загрузки: %v", err)
+	}
+	if len(server.rests) != 0 {
+		t.Errorf("Загрузка не должна была продолжаться, полученно: %v", server.rests)
+	}
+	if saved, err := os.ReadFile(filepath.Join(dir, "15-12:00-nginx.tar.gz")); err != nil || !bytes.Equal(saved, data) {
+		t.Errorf("Архив не сохранен на сервере или поврежден: %v", err)
+	}
+	if _, ok := storage.state.Load(storage.stateKey("host/nginx/2024-03/15-12:00-nginx.tar.gz" + cloudStorages.PartSuffix)); ok {
+		t.Errorf("Завершенная загрузка должна быть удалена из файла состояния")
+	}
+
+	// Предыдущая загрузка этого же файла оборвалась на середине
+	key := storage.stateKey("host/nginx/2024-03/15-12:00-nginx.tar.gz" + cloudStorages.PartSuffix)
+	session := &resumable.Session{URI: key, Size: info.Size(), ModTime: info.ModTime(), Created: time.Now()}
+	if err := storage.state.Save(key, session); err != nil {
+		t.Fatalf("Ошибка записи файла состояния: %v", err)
+	}
+	if err := os.WriteFile(part, data[:len(data)/2], 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if err := storage.UploadFile(localPath, "host/nginx/2024-03"); err != nil {
+		t.Fatalf("Ошибка загрузки: %v", err)
+	}
+	if len(server.rests) != 1 || server.rests[0] != int64(len(data)/2) {
+		t.Errorf("Тест не пройден ожидалось продолжение с позиции: %v, полученно: %v", len(data)/2, server.rests)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, "15-12:00-nginx.tar.gz"))
+	if err != nil || !bytes.Equal(saved, data) {
+		t.Errorf("Архив не сохранен на сервере или поврежден: %v", err)
+	}
+	if _, err := os.Stat(part); err == nil {
+		t.Errorf("Временный файл должен был быть переименован")
+	}
+
+	// Архив изменился после обрыва: временный файл удаляется, загрузка начинается заново
+	session.ModTime = info.ModTime().Add(-time.Hour)
+	if err := storage.state.Save(key, session); err != nil {
+		t.Fatalf("Ошибка записи файла состояния: %v", err)
+	}
+	if err := os.WriteFile(part, data[:len(data)/2], 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if err := storage.UploadFile(localPath, "host/nginx/2024-03"); err != nil {
+		t.Fatalf("Ошибка загрузки: %v", err)
+	}
+	if len(server.rests) != 1 {
+		t.Errorf("Загрузка измененного архива не должна была продолжаться, полученно: %v", server.rests)
+	}
+	if saved, err := os.ReadFile(filepath.Join(dir, "15-12:00-nginx.tar.gz")); err != nil || !bytes.Equal(saved, data) {
+		t.Errorf("Архив не сохранен на сервере или поврежден: %v", err)
+	}
+}
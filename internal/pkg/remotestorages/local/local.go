@@ -0,0 +1,254 @@
+// Пакет local реализует хранилище резервных копий в локальной папке: на другом диске, USB накопителе
+// или в уже смонтированной сетевой папке (autofs, fstab).
+//
+// Архивы сохраняются в <path>/<remotePath>/<name>. Архив записывается во временный файл, сбрасывается на диск
+// и только затем переименовывается, поэтому в папке юнита не бывает недокачанных архивов.
+// Пакет служит образцом реализации хранилища: на нем проверяются тесты пакета storagetest.
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/diskusage"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+)
+
+func init() {
+	cloudStorages.Register("local", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		return New(*settings)
+	})
+}
+
+// Config содержит настройки хранилища типа local.
+type Config struct {
+	Path    string `toml:"path"`    // Папка, в которой хранятся резервные копии
+	MinFree string `toml:"minFree"` // Сколько места должно остаться свободным после загрузки, например "5GB"
+}
+
+// Local представляет хранилище в локальной папке.
+type Local struct {
+	Path    string // Папка, в которой хранятся резервные копии
+	MinFree int64  // Сколько байт должно остаться свободным после загрузки
+}
+
+// New создает хранилище в папке conf.Path. Папка проверяется методом NewClient.
+func New(conf Config) (*Local, error) {
+	if conf.Path == "" {
+		return nil, fmt.Errorf("local: не указан path")
+	}
+	minFree, err := diskusage.ParseLimit(conf.MinFree)
+	if err != nil {
+		return nil, fmt.Errorf("local: некорректный minFree: %w", err)
+	}
+	if minFree.Percent > 0 {
+		return nil, fmt.Errorf("local: minFree задается в байтах, а не в процентах")
+	}
+	return &Local{Path: conf.Path, MinFree: minFree.Bytes}, nil
+}
+
+// NewClient проверяет, что папка хранилища существует. Папка не создается, чтобы не записывать архивы
+// на корневой диск, если USB накопитель или сетевая папка не подключены.
+func (l *Local) NewClient() error {
+	info, err := os.Stat(l.Path)
+	if err != nil {
+		return fmt.Errorf("local: папка %v недоступна: %w", l.Path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("local: %v не является папкой", l.Path)
+	}
+	return nil
+}
+
+// abs возвращает путь в файловой системе для пути remotePath внутри хранилища.
+func (l *Local) abs(remotePath string) string {
+	return filepath.Join(l.Path, filepath.FromSlash(remotePath))
+}
+
+// UploadFile копирует локальный файл localPath в папку remotePath, предварительно проверив свободное место.
+func (l *Local) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл для чтения: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("не удалось получить информацию о файле: %w", err)
+	}
+	return l.upload(file, info.Size(), filepath.Base(localPath), remotePath)
+}
+
+// UploadStream загружает в папку remotePath файл с именем name, читая его содержимое из r.
+// Размер потока заранее неизвестен, поэтому проверяется только запас свободного места minFree.
+func (l *Local) UploadStream(r io.Reader, name string, remotePath string) error {
+	return l.upload(r, 0, name, remotePath)
+}
+
+// upload записывает файл размером size во временный файл, сбрасывает его на диск и переименовывает.
+func (l *Local) upload(r io.Reader, size int64, name string, remotePath string) error {
+	dir := l.abs(remotePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("local: не удалось создать папку %v: %w", dir, err)
+	}
+	if err := l.checkFree(dir, size); err != nil {
+		return err
+	}
+
+	target := filepath.Join(dir, name)
+	part := target + cloudStorages.PartSuffix
+	file, err := os.Create(part)
+	if err != nil {
+		return fmt.Errorf("local: не удалось создать файл %v: %w", part, err)
+	}
+	written, err := io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(part)
+		return fmt.Errorf("local: ошибка записи файла %v: %w", part, err)
+	}
+
+	// Сверяем размер записанного файла, чтобы не оставить обрезанный архив
+	info, err := os.Stat(part)
+	if err != nil {
+		os.Remove(part)
+		return fmt.Errorf("local: не удалось проверить файл %v: %w", part, err)
+	}
+	if info.Size() != written {
+		os.Remove(part)
+		return fmt.Errorf("local: размер файла %v %v байт не совпадает с отправленными %v байт", part, info.Size(), written)
+	}
+
+	if err := os.Rename(part, target); err != nil {
+		os.Remove(part)
+		return fmt.Errorf("local: не удалось переименовать %v в %v: %w", part, target, err)
+	}
+	return syncDir(dir)
+}
+
+// checkFree проверяет, что после записи size байт в папку dir останется не меньше MinFree байт.
+// Если свободное место нельзя определить на этой платформе, проверка пропускается.
+func (l *Local) checkFree(dir string, size int64) error {
+	free, err := diskusage.Free(dir)
+	if errors.Is(err, diskusage.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("local: %w", err)
+	}
+	if need := size + l.MinFree; need > 0 && uint64(need) > free {
+		return fmt.Errorf("local: недостаточно места в %v: нужно %v байт, свободно %v байт", dir, need, free)
+	}
+	return nil
+}
+
+// syncDir сбрасывает на диск запись папки dir, чтобы переименование файла пережило отключение питания.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("local: не удалось открыть папку %v: %w", dir, err)
+	}
+	defer d.Close()
+	// Не все файловые системы поддерживают fsync папки, это не ошибка загрузки
+	d.Sync()
+	return nil
+}
+
+// ListDirItems возвращает файлы и папки в папке remotePath, отсортированные по имени.
+func (l *Local) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	dir := l.abs(remotePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("local: не удалось прочитать папку %v: %w", dir, err)
+	}
+
+	var items []cloudStorages.File
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), cloudStorages.PartSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("local: не удалось получить информацию о файле %v: %w", entry.Name(), err)
+		}
+		items = append(items, toFile(filepath.Join(dir, entry.Name()), info))
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+// Stat возвращает информацию о файле по его пути в хранилище.
+func (l *Local) Stat(remotePath string) (*cloudStorages.File, error) {
+	target := l.abs(remotePath)
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, fmt.Errorf("local: не удалось получить информацию о файле %v: %w", target, err)
+	}
+	file := toFile(target, info)
+	return &file, nil
+}
+
+// DownloadFile копирует файл с путем fileID в localPath.
+func (l *Local) DownloadFile(fileID string, localPath string) error {
+	return cloudStorages.DownloadFile(l, fileID, localPath)
+}
+
+// Open открывает на чтение файл с путем fileID.
+func (l *Local) Open(fileID string) (io.ReadCloser, error) {
+	file, err := os.Open(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("local: не удалось открыть файл %v: %w", fileID, err)
+	}
+	return file, nil
+}
+
+// OpenRange открывает на чтение length байт файла fileID, начиная с offset.
+func (l *Local) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("local: не удалось открыть файл %v: %w", fileID, err)
+	}
+	return cloudStorages.NewSection(file, offset, length), nil
+}
+
+// DeleteFile удаляет файл с путем fileID.
+func (l *Local) DeleteFile(fileID string) error {
+	if err := os.Remove(fileID); err != nil {
+		return fmt.Errorf("local: не удалось удалить файл %v: %w", fileID, err)
+	}
+	return nil
+}
+
+// DeleteDir удаляет папку с путем dirID вместе со всем содержимым.
+func (l *Local) DeleteDir(dirID string) error {
+	if err := os.RemoveAll(dirID); err != nil {
+		return fmt.Errorf("local: не удалось удалить папку %v: %w", dirID, err)
+	}
+	return nil
+}
+
+// toFile преобразует информацию о файле в описание файла хранилища.
+func toFile(fullPath string, info os.FileInfo) cloudStorages.File {
+	return cloudStorages.File{
+		Id:   fullPath,
+		Name: info.Name(),
+		Size: info.Size(),
+		Dir:  info.IsDir(),
+	}
+}
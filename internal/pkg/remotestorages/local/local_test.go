@@ -0,0 +1,73 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/storagetest"
+)
+
+func TestLocal(t *testing.T) {
+	storage, err := New(Config{Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	storagetest.Run(t, storage)
+}
+
+func TestLocalPartFiles(t *testing.T) {
+	root := t.TempDir()
+	storage, err := New(Config{Path: root})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	// Недокачанный архив не попадает в список
+	dir := filepath.Join(root, "host/nginx/2024-03")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Ошибка при создании папки: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "03-13:37-nginx.zip"+cloudStorages.PartSuffix), nil, 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	items, err := storage.ListDirItems("host/nginx/2024-03")
+	if err != nil || len(items) != 0 {
+		t.Errorf("Недокачанный архив не должен попадать в список: %+v, %v", items, err)
+	}
+}
+
+func TestLocalConfig(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Errorf("Ожидалась ошибка без path")
+	}
+	if _, err := New(Config{Path: "/mnt/usb", MinFree: "10%"}); err == nil {
+		t.Errorf("Ожидалась ошибка для minFree в процентах")
+	}
+
+	// Папка не создается, если накопитель не подключен
+	storage, err := New(Config{Path: filepath.Join(t.TempDir(), "missing")})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err == nil {
+		t.Errorf("Ожидалась ошибка для несуществующей папки")
+	}
+
+	// Свободного места заведомо меньше, чем требуется оставить
+	storage, err = New(Config{Path: t.TempDir(), MinFree: "1000000TB"})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.UploadStream(strings.NewReader("archive"), "03-13:37-nginx.zip", "host/nginx/2024-03"); err == nil {
+		t.Errorf("Ожидалась ошибка нехватки места")
+	}
+	if items, _ := storage.ListDirItems("host/nginx/2024-03"); len(items) != 0 {
+		t.Errorf("Архив не должен был быть записан: %+v", items)
+	}
+}
@@ -0,0 +1,28 @@
+package remotestorages
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// UploadManifest загружает манифест data с именем name в папку r.RemotePath хранилищ, в которые архив
+// был успешно загружен и проверен. Ошибка загрузки манифеста записывается в ManifestErr отчета
+// и не делает загрузку архива неудачной.
+func (r *Remotestorages) UploadManifest(reports UploadReports, name string, data []byte) {
+	for _, TO := range reports.Names() {
+		report := reports[TO]
+		if !report.Status {
+			continue
+		}
+		storage, err := r.storage(TO)
+		if err == nil {
+			// Манифест небольшой, поэтому каждая попытка загружает его потоком заново
+			err = storage.do(func() error {
+				return storage.Storage.UploadStream(bytes.NewReader(data), name, r.RemotePath)
+			})
+		}
+		if err != nil {
+			report.ManifestErr = fmt.Errorf("UploadManifest %v: %w", TO, err)
+		}
+	}
+}
@@ -1,11 +1,235 @@
+// Пакет nfs реализует хранилище резервных копий на NFS ресурсе.
+//
+// Хранилище работает либо с уже смонтированной папкой path, либо само монтирует экспорт export
+// с параметрами mountOptions в отдельную временную точку монтирования и отмонтирует его при закрытии.
+// Архивы сохраняются в <папка>/<baseDir>/<remotePath>/<name>. Запись, перечисление и удаление архивов
+// выполняет хранилище local, поэтому архивы так же записываются атомарно через временный файл.
+// Папка path должна находиться на файловой системе NFS: если ресурс не смонтирован, хранилище
+// возвращает ошибку, а не записывает архивы в пустую точку монтирования на локальном диске.
 package nfs
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/local"
+)
+
+func init() {
+	cloudStorages.Register("nfs", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		return New(*settings)
+	})
+}
+
+// Config содержит настройки хранилища типа nfs.
+type Config struct {
+	Path         string `toml:"path"`         // Уже смонтированная папка NFS
+	Export       string `toml:"export"`       // Экспорт вида server:/export, который нужно смонтировать
+	MountOptions string `toml:"mountOptions"` // Параметры монтирования, например "vers=4.1,hard"
+	BaseDir      string `toml:"baseDir"`      // Папка внутри ресурса, в которой хранятся резервные копии
+}
+
+// errNotConnected возвращается при работе с файлами до NewClient или после Close.
+var errNotConnected = errors.New("nfs: хранилище не подключено")
+
+// onNFS проверяет, что папка находится на файловой системе NFS. Подменяется в тестах.
+var onNFS = isNFS
+
+// NFS представляет хранилище на NFS ресурсе. После подключения работа с файлами выполняется через local.Local.
 type NFS struct {
 	Path         string
 	MountOptions string
+
+	export     string       // Экспорт, который монтирует хранилище
+	baseDir    string       // Папка внутри ресурса
+	mountpoint string       // Временная точка монтирования, пока экспорт смонтирован
+	local      *local.Local // Хранилище в папке baseDir ресурса, пустое до NewClient и после Close
 }
 
-func (r *NFS) Push() error {
+// New создает хранилище NFS с настройками conf. Монтирование выполняется методом NewClient.
+func New(conf Config) (*NFS, error) {
+	if (conf.Path == "") == (conf.Export == "") {
+		return nil, fmt.Errorf("nfs: нужно указать либо path, либо export")
+	}
+	if conf.Export != "" && !strings.Contains(conf.Export, ":") {
+		return nil, fmt.Errorf("nfs: некорректный export %q, ожидается server:/export", conf.Export)
+	}
+	return &NFS{
+		Path:         conf.Path,
+		MountOptions: conf.MountOptions,
+		export:       conf.Export,
+		baseDir:      conf.BaseDir,
+	}, nil
+}
 
+// NewClient монтирует экспорт во временную точку монтирования или проверяет, что папка path существует
+// и находится на NFS ресурсе.
+func (n *NFS) NewClient() error {
+	root := n.Path
+	if n.export != "" {
+		mountpoint, err := n.mount()
+		if err != nil {
+			return err
+		}
+		root = mountpoint
+	}
+
+	// Сначала проверяем сам ресурс, чтобы не создать baseDir на локальном диске, если ресурс не смонтирован
+	if err := (&local.Local{Path: root}).NewClient(); err != nil {
+		n.Close()
+		return fmt.Errorf("nfs: %w", err)
+	}
+	if n.export == "" {
+		mounted, err := onNFS(root)
+		if err != nil {
+			return fmt.Errorf("nfs: не удалось определить файловую систему папки %v: %w", root, err)
+		}
+		if !mounted {
+			return fmt.Errorf("nfs: папка %v не находится на NFS ресурсе, возможно ресурс не смонтирован", root)
+		}
+	}
+	storage := &local.Local{Path: filepath.Join(root, n.baseDir)}
+	if err := os.MkdirAll(storage.Path, 0755); err != nil {
+		n.Close()
+		return fmt.Errorf("nfs: не удалось создать папку %v: %w", storage.Path, err)
+	}
+	n.local = storage
 	return nil
 }
+
+// mount монтирует экспорт во временную точку монтирования и возвращает ее путь.
+func (n *NFS) mount() (string, error) {
+	mountpoint, err := os.MkdirTemp("", "kronoskeeper-nfs-")
+	if err != nil {
+		return "", fmt.Errorf("nfs: не удалось создать точку монтирования: %w", err)
+	}
+	if out, err := exec.Command("mount", mountArgs(n.export, n.MountOptions, mountpoint)...).CombinedOutput(); err != nil {
+		os.Remove(mountpoint)
+		return "", fmt.Errorf("nfs: не удалось смонтировать %v: %w: %s", n.export, err, strings.TrimSpace(string(out)))
+	}
+	n.mountpoint = mountpoint
+	return mountpoint, nil
+}
+
+// mountArgs возвращает аргументы команды mount для монтирования export в mountpoint.
+func mountArgs(export, options, mountpoint string) []string {
+	args := []string{"-t", "nfs"}
+	if options != "" {
+		args = append(args, "-o", options)
+	}
+	return append(args, export, mountpoint)
+}
+
+// Close отмонтирует экспорт, если хранилище монтировало его само, и удаляет точку монтирования.
+func (n *NFS) Close() error {
+	if n.mountpoint == "" {
+		n.local = nil
+		return nil
+	}
+	if out, err := exec.Command("umount", n.mountpoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("nfs: не удалось отмонтировать %v: %w: %s", n.mountpoint, err, strings.TrimSpace(string(out)))
+	}
+	err := os.Remove(n.mountpoint)
+	n.mountpoint, n.local = "", nil
+	return err
+}
+
+// storage возвращает хранилище подключенного ресурса или ошибку, если NewClient не вызывался.
+func (n *NFS) storage() (*local.Local, error) {
+	if n.local == nil {
+		return nil, errNotConnected
+	}
+	return n.local, nil
+}
+
+// UploadFile загружает файл localPath в папку remotePath на ресурсе.
+func (n *NFS) UploadFile(localPath, remotePath string) error {
+	storage, err := n.storage()
+	if err != nil {
+		return err
+	}
+	return storage.UploadFile(localPath, remotePath)
+}
+
+// UploadStream сохраняет на ресурсе файл с именем name, читая его содержимое из r.
+func (n *NFS) UploadStream(r io.Reader, name string, remotePath string) error {
+	storage, err := n.storage()
+	if err != nil {
+		return err
+	}
+	return storage.UploadStream(r, name, remotePath)
+}
+
+// ListDirItems возвращает список файлов и папок в папке remotePath на ресурсе.
+func (n *NFS) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	storage, err := n.storage()
+	if err != nil {
+		return nil, err
+	}
+	return storage.ListDirItems(remotePath)
+}
+
+// Stat возвращает информацию о файле remotePath на ресурсе.
+func (n *NFS) Stat(remotePath string) (*cloudStorages.File, error) {
+	storage, err := n.storage()
+	if err != nil {
+		return nil, err
+	}
+	return storage.Stat(remotePath)
+}
+
+// DownloadFile копирует файл fileID с ресурса в localPath.
+func (n *NFS) DownloadFile(fileID string, localPath string) error {
+	storage, err := n.storage()
+	if err != nil {
+		return err
+	}
+	return storage.DownloadFile(fileID, localPath)
+}
+
+// Open открывает файл fileID на ресурсе для чтения.
+func (n *NFS) Open(fileID string) (io.ReadCloser, error) {
+	storage, err := n.storage()
+	if err != nil {
+		return nil, err
+	}
+	return storage.Open(fileID)
+}
+
+// OpenRange открывает на чтение length байт файла fileID на ресурсе, начиная с offset.
+func (n *NFS) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	storage, err := n.storage()
+	if err != nil {
+		return nil, err
+	}
+	return storage.OpenRange(fileID, offset, length)
+}
+
+// DeleteFile удаляет файл fileID на ресурсе.
+func (n *NFS) DeleteFile(fileID string) error {
+	storage, err := n.storage()
+	if err != nil {
+		return err
+	}
+	return storage.DeleteFile(fileID)
+}
+
+// DeleteDir удаляет папку dirID на ресурсе вместе с содержимым.
+func (n *NFS) DeleteDir(dirID string) error {
+	storage, err := n.storage()
+	if err != nil {
+		return err
+	}
+	return storage.DeleteDir(dirID)
+}
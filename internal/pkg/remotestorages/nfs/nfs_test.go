@@ -0,0 +1,90 @@
+package nfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/storagetest"
+)
+
+// fakeNFS считает любую папку папкой на NFS ресурсе до конца теста.
+func fakeNFS(t *testing.T) {
+	onNFS = func(string) (bool, error) { return true, nil }
+	t.Cleanup(func() { onNFS = isNFS })
+}
+
+func TestNFS(t *testing.T) {
+	fakeNFS(t)
+	root := t.TempDir()
+	storage, err := New(Config{Path: root, BaseDir: "backups"})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	// До подключения операции с файлами возвращают ошибку, а не завершают программу
+	if _, err := storage.ListDirItems("host"); !errors.Is(err, errNotConnected) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", errNotConnected, err)
+	}
+
+	if err := storage.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	storagetest.Run(t, storage)
+	if _, err := os.Stat(filepath.Join(root, "backups", "host")); err != nil {
+		t.Errorf("Архивы должны храниться в папке baseDir: %v", err)
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.UploadStream(strings.NewReader("data"), "archive.zip", "host"); !errors.Is(err, errNotConnected) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", errNotConnected, err)
+	}
+}
+
+func TestNFSNotMounted(t *testing.T) {
+	// Временная папка находится на локальном диске, как пустая точка монтирования несмонтированного ресурса
+	root := t.TempDir()
+	storage, err := New(Config{Path: root, BaseDir: "backups"})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if mounted, _ := isNFS(root); mounted {
+		t.Skip("временная папка находится на NFS")
+	}
+	if err := storage.NewClient(); err == nil {
+		t.Errorf("Ожидалась ошибка для папки не на NFS ресурсе")
+	}
+	if _, err := os.Stat(filepath.Join(root, "backups")); !os.IsNotExist(err) {
+		t.Errorf("Папка baseDir не должна создаваться на локальном диске: %v", err)
+	}
+}
+
+func TestNFSConfig(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Errorf("Ожидалась ошибка без path и export")
+	}
+	if _, err := New(Config{Path: "/mnt/nfs", Export: "nas:/backups"}); err == nil {
+		t.Errorf("Ожидалась ошибка при одновременном path и export")
+	}
+	if _, err := New(Config{Export: "/backups"}); err == nil {
+		t.Errorf("Ожидалась ошибка для export без сервера")
+	}
+
+	storage, err := New(Config{Path: filepath.Join(t.TempDir(), "missing")})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err == nil {
+		t.Errorf("Ожидалась ошибка для несуществующей папки")
+	}
+
+	expect := []string{"-t", "nfs", "-o", "vers=4.1,hard", "nas:/backups", "/tmp/mnt"}
+	if args := mountArgs("nas:/backups", "vers=4.1,hard", "/tmp/mnt"); !reflect.DeepEqual(args, expect) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", expect, args)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package nfs
+
+import "syscall"
+
+// nfsSuperMagic - тип файловой системы NFS в statfs.
+const nfsSuperMagic = 0x6969
+
+// isNFS проверяет, что папка dir находится на файловой системе NFS.
+func isNFS(dir string) (bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return false, err
+	}
+	return uint32(stat.Type) == nfsSuperMagic, nil
+}
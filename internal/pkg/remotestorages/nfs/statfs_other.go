@@ -0,0 +1,8 @@
+//go:build !linux
+
+package nfs
+
+// isNFS вне Linux не проверяет тип файловой системы и считает любую папку папкой NFS.
+func isNFS(dir string) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,45 @@
+package remotestorages
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/retention"
+)
+
+// PruneReports содержит отчеты об очистке старых резервных копий по имени хранилища.
+type PruneReports map[string]*retention.Report
+
+// Names возвращает отсортированный список имен хранилищ в отчете.
+func (p PruneReports) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PruneBackups удаляет из папки юнита unitPath архивы, срок хранения которых истек, в хранилищах,
+// в которые архив current был успешно загружен и проверен согласно uploads. В хранилищах с неудачной
+// загрузкой очистка не выполняется, чтобы не удалить прежние копии, пока новой нет.
+// Архив current не удаляется.
+func (r *Remotestorages) PruneBackups(uploads UploadReports, unitPath string, policy retention.Policy, current retention.Backup) PruneReports {
+	reports := PruneReports{}
+	now := time.Now()
+
+	for _, TO := range uploads.Names() {
+		if !uploads[TO].Status {
+			continue
+		}
+		storage, err := r.Storage(TO)
+		if err != nil {
+			reports[TO] = &retention.Report{DryRun: policy.DryRun, Err: fmt.Errorf("PruneBackups: %w", err)}
+			continue
+		}
+		reports[TO] = retention.PruneRemote(storage, unitPath, policy, current, now)
+	}
+
+	return reports
+}
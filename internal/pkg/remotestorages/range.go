@@ -0,0 +1,106 @@
+package remotestorages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+)
+
+// Размер части файла, запрашиваемой у хранилища. При последовательном чтении размер удваивается,
+// чтобы содержимое больших файлов архива не скачивалось множеством мелких запросов.
+const (
+	minRangeBlock = 256 << 10
+	maxRangeBlock = 16 << 20
+)
+
+// ErrRangeUnsupported возвращается OpenRange, если хранилище не умеет читать часть файла.
+var ErrRangeUnsupported = errors.New("хранилище не поддерживает чтение части файла")
+
+// RangeReader читает файл хранилища по частям, запрашивая только нужные диапазоны байт.
+// Реализует io.ReaderAt, поэтому подходит для чтения центрального каталога и отдельных файлов
+// ZIP-архива без скачивания архива целиком. Не предназначен для одновременного использования.
+type RangeReader struct {
+	storage *retryStorage
+	opener  cloudStorages.RangeOpener
+	fileID  string
+	size    int64  // размер файла
+	block   int64  // размер следующего запроса
+	offset  int64  // смещение buf в файле
+	buf     []byte // последняя прочитанная часть файла
+	fetched int64  // всего получено байт от хранилища
+}
+
+// OpenRange открывает файл remotePath хранилища name для чтения по частям.
+// Если хранилище не реализует cloudStorages.RangeOpener, возвращается ErrRangeUnsupported.
+func (r *Remotestorages) OpenRange(name, remotePath string) (*RangeReader, error) {
+	storage, err := r.storage(name)
+	if err != nil {
+		return nil, err
+	}
+	opener, ok := storage.Storage.(cloudStorages.RangeOpener)
+	if !ok {
+		return nil, ErrRangeUnsupported
+	}
+	file, err := storage.Stat(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("файл %v не найден в хранилище %v: %w", remotePath, name, err)
+	}
+	return &RangeReader{storage: storage, opener: opener, fileID: file.Id, size: file.Size, block: minRangeBlock}, nil
+}
+
+// Size возвращает размер файла.
+func (rr *RangeReader) Size() int64 {
+	return rr.size
+}
+
+// Fetched возвращает количество байт, полученных от хранилища.
+func (rr *RangeReader) Fetched() int64 {
+	return rr.fetched
+}
+
+// ReadAt читает len(p) байт файла, начиная с off.
+func (rr *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("отрицательное смещение %v", off)
+	}
+	n := 0
+	for n < len(p) && off < rr.size {
+		if off < rr.offset || off >= rr.offset+int64(len(rr.buf)) {
+			if err := rr.fetch(off); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(p[n:], rr.buf[off-rr.offset:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch запрашивает у хранилища часть файла, начиная с off.
+func (rr *RangeReader) fetch(off int64) error {
+	if len(rr.buf) > 0 && off == rr.offset+int64(len(rr.buf)) && rr.block < maxRangeBlock {
+		rr.block *= 2
+	}
+	buf := make([]byte, min(rr.block, rr.size-off))
+	err := rr.storage.do(func() error {
+		reader, err := rr.opener.OpenRange(rr.fileID, off, int64(len(buf)))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		_, err = io.ReadFull(reader, buf)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("не удалось прочитать часть файла %v: %w", rr.fileID, err)
+	}
+	rr.buf, rr.offset = buf, off
+	rr.fetched += int64(len(buf))
+	return nil
+}
@@ -1,124 +1,178 @@
 // Пакет remotestorages реализует взаимодействие с различными удаленными хранилищами.
+//
+// Хранилища регистрируются по имени в пакете cloudStorages, поэтому новое хранилище подключается
+// без изменений в этом пакете, в сервисе и в демоне.
 package remotestorages
 
 import (
 	"fmt"
+	"io"
+	"sort"
+	"strings"
 
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/retry"
 
-	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/gCloud"
-	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/gDrive"
-	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/nfs"
-	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/samba"
+	// Регистрация поддерживаемых хранилищ
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/gCloud"
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/gDrive"
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/gcs"
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages/s3"
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/ftp"
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/local"
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/nfs"
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/samba"
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/sftp"
+	_ "github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/webdav"
 )
 
 // Remotestorages представляет собой структуру для работы с удаленными хранилищами.
 type Remotestorages struct {
 	UploadConfig
-	gCloud *gCloud.GCloud
-	gDrive *gDrive.GDrive
-	smb    *samba.Samba
-	nfs    *nfs.NFS
+	conf     config.RemoteStorages
+	storages map[string]*retryStorage // хранилища, клиенты которых уже созданы
 }
 
 // UploadConfig содержит настройки для отправки резервных копий в удаленное хранилище.
 type UploadConfig struct {
-	UploadTO   []string // куда заливать резервные копии, например: []string{"gCloud", "nfs"}
+	UploadTO   []string // имена хранилищ из [storage.<name>], куда заливать резервные копии, например: []string{"gCloud", "work-drive"}
 	LocalPath  string   // Путь к загружаемой директории
 	RemotePath string   // Путь к папке, в которую загружаем
 }
 
-// UploadReports представляет отчет о выполнении операции отправки резервных копий в удаленное хранилище.
-type UploadReports struct {
-	GCloud struct {
-		Status bool  // выполнелся ли push на удаленное хранилище
-		Err    error // есть ли ошибка в отправке резервных копий
-	}
-	NFS struct {
-		Status bool  // выполнелся ли push на удаленное хранилище
-		Err    error // есть ли ошибка в отправке резервных копий
-	}
-	Samba struct {
-		Status bool  // выполнелся ли push на удаленное хранилище
-		Err    error // есть ли ошибка в отправке резервных копий
-	}
-	GDrive struct {
-		Status bool  // выполнелся ли push на удаленное хранилище
-		Err    error // есть ли ошибка в отправке резервных копий
+// UploadReport представляет отчет об отправке резервной копии в одно удаленное хранилище.
+type UploadReport struct {
+	Status      bool   // выполнелся ли push на удаленное хранилище
+	Err         error  // есть ли ошибка в отправке резервных копий
+	Attempts    int    // количество попыток загрузки, больше 1 если были временные ошибки
+	Verified    string // способ проверки загруженной копии: md5, sha256 или size, пустой - копия не проверялась
+	ManifestErr error  // ошибка загрузки манифеста архива, сам архив при этом загружен
+}
+
+// UploadReports содержит отчеты об отправке резервных копий по имени хранилища.
+type UploadReports map[string]*UploadReport
+
+// NewReports создает отчеты для хранилищ names.
+func NewReports(names []string) UploadReports {
+	reports := UploadReports{}
+	for _, name := range names {
+		reports[name] = &UploadReport{}
 	}
+	return reports
 }
 
-// NewReports создает новый объект UploadReports.
-func NewReports() *UploadReports {
-	return &UploadReports{
-		GCloud: struct {
-			Status bool
-			Err    error
-		}{false, nil},
-		NFS: struct {
-			Status bool
-			Err    error
-		}{false, nil},
-		Samba: struct {
-			Status bool
-			Err    error
-		}{false, nil},
-		GDrive: struct {
-			Status bool
-			Err    error
-		}{false, nil},
+// Names возвращает отсортированный список имен хранилищ в отчете.
+func (u UploadReports) Names() []string {
+	names := make([]string, 0, len(u))
+	for name := range u {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
 // New создает новый объект Remotestorages на основе конфигурации.
-func New(UploadConfig *UploadConfig, remote *config.RemoteStorages) (*Remotestorages, error) {
-	remotestorages := &Remotestorages{
+// Клиенты хранилищ создаются при первом обращении к ним.
+func New(UploadConfig *UploadConfig, remote config.RemoteStorages) (*Remotestorages, error) {
+	return &Remotestorages{
 		UploadConfig: *UploadConfig,
-		gCloud:       gCloud.New(remote.GCloud.CredentialsJSON),
-	}
+		conf:         remote,
+		storages:     map[string]*retryStorage{},
+	}, nil
+}
 
-	if remote.GDrive.ApiKeyJson != "" {
-		gDrive, err := gDrive.New(remote.GDrive.ApiKeyJson, remote.GDrive.TokenFile)
+// UploadBackups выполняет операцию отправки резервных копий в удаленные хранилища.
+// Загрузка повторяется при временных ошибках, количество попыток записывается в отчет.
+func (r *Remotestorages) UploadBackups() UploadReports {
+	reports := NewReports(r.UploadTO)
+
+	for _, TO := range r.UploadTO {
+		storage, err := r.storage(TO)
+		if err != nil {
+			reports[TO].Err = fmt.Errorf("UploadBackups %v: %w", TO, err)
+			continue
+		}
+		err = storage.UploadFile(r.LocalPath, r.RemotePath)
+		reports[TO].Attempts = storage.attempts
 		if err != nil {
-			return remotestorages, err
+			reports[TO].Err = fmt.Errorf("UploadBackups %v: %w", TO, err)
+			continue
 		}
-		remotestorages.gDrive = gDrive
+		reports[TO].Status = true
 	}
 
-	return remotestorages, nil
+	return reports
 }
 
-// UploadBackups выполняет операцию отправки резервных копий в удаленные хранилища.
-func (r *Remotestorages) UploadBackups() *UploadReports {
-	UploadReports := NewReports()
+// Storage возвращает хранилище с именем name из [storage.<name>], создавая его клиента при первом обращении.
+// Операции возвращенного хранилища повторяются при временных ошибках по настройкам блока [retry].
+func (r *Remotestorages) Storage(name string) (cloudStorages.Storage, error) {
+	storage, err := r.storage(name)
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
 
-	for _, TO := range r.UploadTO {
-		switch TO {
-		case "gCloud":
-			if err := r.gCloud.NewClient(); err != nil {
-				UploadReports.GCloud.Err = fmt.Errorf("UploadBackups: %v", err)
-				continue
-			}
-			if err := r.gCloud.UploadFile(r.LocalPath, r.RemotePath); err != nil {
-				UploadReports.GCloud.Err = fmt.Errorf("UploadBackups: %v", err)
-				continue
-			}
-			UploadReports.GCloud.Status = true
-			continue
+// Open открывает файл remotePath хранилища name на чтение. Хранилища без метода Open скачивают файл
+// во временный файл, который удаляется при закрытии.
+func (r *Remotestorages) Open(name, remotePath string) (io.ReadCloser, error) {
+	storage, err := r.storage(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := storage.Stat(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("файл %v не найден в хранилище %v: %w", remotePath, name, err)
+	}
+	var reader io.ReadCloser
+	err = storage.do(func() (err error) {
+		reader, err = storage.open(file.Id)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать файл %v из хранилища %v: %w", remotePath, name, err)
+	}
+	return reader, nil
+}
 
-		case "gDrive":
-			if err := r.gDrive.NewClient(); err != nil {
-				UploadReports.GDrive.Err = fmt.Errorf("UploadBackups: %v", err)
-				continue
-			}
-			if err := r.gDrive.UploadFile(r.LocalPath, r.RemotePath); err != nil {
-				UploadReports.GDrive.Err = fmt.Errorf("UploadBackups: %v", err)
-				continue
-			}
-			UploadReports.GDrive.Status = true
-			continue
+// storage возвращает хранилище с повторами операций, подключаясь к нему при первом обращении.
+func (r *Remotestorages) storage(name string) (*retryStorage, error) {
+	if storage, ok := r.storages[name]; ok {
+		return storage, nil
+	}
+
+	conf, ok := r.conf[name]
+	if !ok {
+		return nil, fmt.Errorf("хранилище %v не описано в конфигурации", name)
+	}
+	backend, err := cloudStorages.NewStorage(conf)
+	if err != nil {
+		return nil, err
+	}
+	storage := newRetryStorage(backend, retry.NewPolicy(conf.Retry))
+	if err := storage.NewClient(); err != nil {
+		if storage.attempts > 1 {
+			return nil, fmt.Errorf("не удалось подключиться за %v попыток: %w", storage.attempts, err)
 		}
+		return nil, err
 	}
+	r.storages[name] = storage
+	return storage, nil
+}
 
-	return UploadReports
+// Close закрывает подключения хранилищ, которые их держат, например SSH подключение SFTP.
+func (r *Remotestorages) Close() error {
+	var errs []string
+	for name, storage := range r.storages {
+		if err := storage.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", name, err))
+		}
+		delete(r.storages, name)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("не удалось закрыть подключения к хранилищам: %v", strings.Join(errs, "; "))
+	}
+	return nil
 }
@@ -1 +1,365 @@
 package remotestorages
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/retention"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/retry"
+)
+
+// memStorage - хранилище в памяти для тестов: путь файла -> содержимое.
+type memStorage struct {
+	files   map[string][]byte
+	fail    error // ошибка, которую возвращает загрузка
+	flaky   int   // количество загрузок, которые завершатся временной ошибкой
+	corrupt bool  // загрузка портит данные
+	md5     bool  // Stat сообщает MD5 файла, как Google Drive
+	dials   int   // количество вызовов NewClient
+	refused int   // количество подключений, которые завершатся временной ошибкой
+}
+
+var memStorages = map[string]*memStorage{
+	"memory":  {files: map[string][]byte{}},
+	"memory2": {files: map[string][]byte{}},
+	"broken":  {files: map[string][]byte{}, fail: errors.New("хранилище недоступно")},
+	"flaky":   {files: map[string][]byte{}},
+	"corrupt": {files: map[string][]byte{}, corrupt: true, md5: true},
+}
+
+func init() {
+	for name, storage := range memStorages {
+		storage := storage
+		cloudStorages.Register(name, func(*config.Storage) (cloudStorages.Storage, error) { return storage, nil })
+	}
+}
+
+// memConfig описывает тестовые хранилища так, как они были бы описаны в блоках [storage.<name>].
+var memConfig = config.RemoteStorages{
+	"memory":  config.NewStorage("memory", "memory"),
+	"memory2": config.NewStorage("memory2", "memory2"),
+	"broken":  config.NewStorage("broken", "broken"),
+	"corrupt": config.NewStorage("corrupt", "corrupt"),
+	"flaky":   &config.Storage{Name: "flaky", Type: "flaky", Retry: config.Retry{MaxAttempts: 3, BaseDelay: time.Millisecond}},
+}
+
+func (m *memStorage) NewClient() error {
+	m.dials++
+	if m.refused > 0 {
+		m.refused--
+		return fmt.Errorf("dial tcp 127.0.0.1:443: %w", syscall.ECONNREFUSED)
+	}
+	return nil
+}
+
+func (m *memStorage) Close() error { return nil }
+
+func (m *memStorage) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return m.UploadStream(file, filepath.Base(localPath), remotePath)
+}
+
+func (m *memStorage) UploadStream(r io.Reader, name, remotePath string) error {
+	if m.fail != nil {
+		return m.fail
+	}
+	if m.flaky > 0 {
+		m.flaky--
+		return fmt.Errorf("write tcp 127.0.0.1:443: %w", syscall.ECONNRESET)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if m.corrupt && len(data) > 0 {
+		data[0]++
+	}
+	m.files[path.Join(remotePath, name)] = data
+	return nil
+}
+
+func (m *memStorage) ListDirItems(string) ([]cloudStorages.File, error) { return nil, nil }
+
+func (m *memStorage) DownloadFile(fileID string, localPath string) error {
+	data, ok := m.files[fileID]
+	if !ok {
+		return os.ErrNotExist
+	}
+	return os.WriteFile(localPath, data, 0644)
+}
+
+func (m *memStorage) Stat(remotePath string) (*cloudStorages.File, error) {
+	data, ok := m.files[remotePath]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	file := &cloudStorages.File{Id: remotePath, Name: path.Base(remotePath), Size: int64(len(data))}
+	if m.md5 {
+		sum := md5.Sum(data)
+		file.MD5 = hex.EncodeToString(sum[:])
+	}
+	return file, nil
+}
+
+func (m *memStorage) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	data, ok := m.files[fileID]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data[offset : offset+length])), nil
+}
+
+func (m *memStorage) DeleteFile(fileID string) error {
+	delete(m.files, fileID)
+	return nil
+}
+
+func (m *memStorage) DeleteDir(string) error { return nil }
+
+func TestUploadBackups(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "01-10:00-nginx.zip")
+	if err := os.WriteFile(localPath, []byte("archive"), 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+
+	r, err := New(&UploadConfig{
+		UploadTO:   []string{"memory", "broken", "unknown"},
+		LocalPath:  localPath,
+		RemotePath: "host/nginx/2024-03",
+	}, memConfig)
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	reports := r.UploadBackups()
+	if !reports["memory"].Status || reports["memory"].Err != nil {
+		t.Errorf("Загрузка в memory должна быть успешной: %+v", reports["memory"])
+	}
+	for _, name := range []string{"broken", "unknown"} {
+		if reports[name].Status || reports[name].Err == nil {
+			t.Errorf("Загрузка в %v должна завершиться ошибкой: %+v", name, reports[name])
+		}
+	}
+
+	storage, err := r.Storage("memory")
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	info, err := storage.Stat("host/nginx/2024-03/01-10:00-nginx.zip")
+	if err != nil || info.Size != int64(len("archive")) {
+		t.Errorf("Архив не найден в хранилище memory: %+v, %v", info, err)
+	}
+}
+
+func TestUploadBackupsRetry(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "01-10:00-nginx.zip")
+	if err := os.WriteFile(localPath, []byte("archive"), 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	r, _ := New(&UploadConfig{UploadTO: []string{"flaky", "broken"}, LocalPath: localPath, RemotePath: "host/nginx/2024-03"}, memConfig)
+
+	// Две временные ошибки укладываются в три попытки
+	memStorages["flaky"].flaky = 2
+	reports := r.UploadBackups()
+	if !reports["flaky"].Status || reports["flaky"].Attempts != 3 {
+		t.Errorf("Тест не пройден ожидалось: успешная загрузка с 3 попыток, полученно: %+v", reports["flaky"])
+	}
+	// Постоянная ошибка не повторяется
+	if reports["broken"].Status || reports["broken"].Attempts != 1 {
+		t.Errorf("Тест не пройден ожидалось: ошибка с 1 попытки, полученно: %+v", reports["broken"])
+	}
+
+	// Попытки закончились раньше, чем временные ошибки
+	memStorages["flaky"].flaky = 5
+	reports = r.UploadBackups()
+	if reports["flaky"].Status || reports["flaky"].Err == nil || reports["flaky"].Attempts != 3 {
+		t.Errorf("Тест не пройден ожидалось: ошибка после 3 попыток, полученно: %+v", reports["flaky"])
+	}
+	memStorages["flaky"].flaky = 0
+}
+
+func TestVerifyUploads(t *testing.T) {
+	data := []byte("archive")
+	localPath := filepath.Join(t.TempDir(), "02-10:00-nginx.zip")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	sha := sha256.Sum256(data)
+	md5sum := md5.Sum(data)
+	sums := Checksums{Size: int64(len(data)), SHA256: hex.EncodeToString(sha[:]), MD5: hex.EncodeToString(md5sum[:])}
+
+	r, _ := New(&UploadConfig{UploadTO: []string{"memory", "corrupt"}, LocalPath: localPath, RemotePath: "host/nginx/2024-03"}, memConfig)
+	reports := r.UploadBackups()
+	r.VerifyUploads(reports, "02-10:00-nginx.zip", sums)
+
+	// Хранилище без контрольных сумм проверяется повторным чтением архива
+	if !reports["memory"].Status || reports["memory"].Verified != VerifiedSHA256 {
+		t.Errorf("Тест не пройден ожидалось: проверка %v, полученно: %+v", VerifiedSHA256, reports["memory"])
+	}
+	// Поврежденная копия считается неудачной загрузкой и удаляется
+	if reports["corrupt"].Status || reports["corrupt"].Err == nil {
+		t.Errorf("Загрузка в corrupt должна завершиться ошибкой проверки: %+v", reports["corrupt"])
+	}
+	if _, ok := memStorages["corrupt"].files["host/nginx/2024-03/02-10:00-nginx.zip"]; ok {
+		t.Errorf("Поврежденная копия архива должна быть удалена")
+	}
+
+	// Совпадающий MD5 хранилища не требует повторного чтения
+	memStorages["corrupt"].corrupt = false
+	defer func() { memStorages["corrupt"].corrupt = true }()
+	reports = r.UploadBackups()
+	r.VerifyUploads(reports, "02-10:00-nginx.zip", sums)
+	if !reports["corrupt"].Status || reports["corrupt"].Verified != VerifiedMD5 {
+		t.Errorf("Тест не пройден ожидалось: проверка %v, полученно: %+v", VerifiedMD5, reports["corrupt"])
+	}
+}
+
+func TestUploadManifest(t *testing.T) {
+	r, _ := New(&UploadConfig{UploadTO: []string{"memory", "flaky", "broken"}, RemotePath: "host/nginx/2024-04"}, memConfig)
+	reports := NewReports(r.UploadTO)
+	reports["memory"].Status = true
+	reports["flaky"].Status = true
+	reports["broken"].Status = true
+	memStorages["flaky"].flaky = 1
+
+	data := []byte(`{"archive": "03-10:00-nginx.zip"}`)
+	r.UploadManifest(reports, "03-10:00-nginx.zip.manifest.json", data)
+
+	// Манифест загружается заново при временной ошибке
+	for _, name := range []string{"memory", "flaky"} {
+		if reports[name].ManifestErr != nil {
+			t.Errorf("Не ожидалась ошибка загрузки манифеста в %v: %v", name, reports[name].ManifestErr)
+		}
+		if got := memStorages[name].files["host/nginx/2024-04/03-10:00-nginx.zip.manifest.json"]; !bytes.Equal(got, data) {
+			t.Errorf("Тест не пройден ожидалось: %s, полученно: %s", data, got)
+		}
+	}
+	reader, err := r.Open("memory", "host/nginx/2024-04/03-10:00-nginx.zip.manifest.json")
+	if err != nil {
+		t.Fatalf("Ошибка открытия манифеста: %v", err)
+	}
+	got, _ := io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("Тест не пройден ожидалось: %s, полученно: %s", data, got)
+	}
+
+	// Ошибка манифеста не делает загрузку архива неудачной
+	if !reports["broken"].Status || reports["broken"].ManifestErr == nil {
+		t.Errorf("Ожидалась ошибка загрузки манифеста при успешной загрузке архива: %+v", reports["broken"])
+	}
+}
+
+func TestOpenStream(t *testing.T) {
+	r, _ := New(&UploadConfig{UploadTO: []string{"memory2", "broken"}, RemotePath: "host/nginx/2024-03"}, memConfig)
+
+	data := bytes.Repeat([]byte("kronos"), 100000)
+	stream := r.OpenStream("01-10:00-nginx.tar.gz")
+	if _, err := stream.Write(data); err != nil {
+		t.Fatalf("Запись должна продолжаться, пока хотя бы одно хранилище принимает данные: %v", err)
+	}
+	reports := stream.Close()
+
+	if !reports["memory2"].Status {
+		t.Errorf("Загрузка в memory2 должна быть успешной: %+v", reports["memory2"])
+	}
+	if reports["broken"].Err == nil {
+		t.Errorf("Загрузка в broken должна завершиться ошибкой")
+	}
+	if got := memStorages["memory2"].files["host/nginx/2024-03/01-10:00-nginx.tar.gz"]; !bytes.Equal(got, data) {
+		t.Errorf("Тест не пройден ожидалось: %v байт, полученно: %v байт", len(data), len(got))
+	}
+}
+
+func TestOpenRange(t *testing.T) {
+	r, _ := New(&UploadConfig{}, memConfig)
+	data := make([]byte, 3*minRangeBlock)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	memStorages["memory"].files["host/nginx/2024-05/01-10:00-nginx.zip"] = data
+
+	reader, err := r.OpenRange("memory", "host/nginx/2024-05/01-10:00-nginx.zip")
+	if err != nil {
+		t.Fatalf("Ошибка открытия файла: %v", err)
+	}
+	if reader.Size() != int64(len(data)) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", len(data), reader.Size())
+	}
+
+	// Читается только часть файла с нужным диапазоном
+	p := make([]byte, 100)
+	if _, err := reader.ReadAt(p, 2*minRangeBlock+10); err != nil || !bytes.Equal(p, data[2*minRangeBlock+10:2*minRangeBlock+110]) {
+		t.Errorf("Неверные данные части файла: %v", err)
+	}
+	if reader.Fetched() >= int64(len(data)) {
+		t.Errorf("Получено %v байт, а должна была быть прочитана только часть файла", reader.Fetched())
+	}
+
+	// Чтение на границе частей и за концом файла
+	p = make([]byte, minRangeBlock)
+	if _, err := reader.ReadAt(p, minRangeBlock/2); err != nil || !bytes.Equal(p, data[minRangeBlock/2:3*minRangeBlock/2]) {
+		t.Errorf("Неверные данные на границе частей файла: %v", err)
+	}
+	if n, err := reader.ReadAt(p, int64(len(data))-10); n != 10 || err != io.EOF {
+		t.Errorf("Тест не пройден ожидалось: 10, EOF, полученно: %v, %v", n, err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	r, _ := New(&UploadConfig{UploadTO: []string{"memory", "memory2"}, RemotePath: "host/nginx/2024-05"}, memConfig)
+	uploads := NewReports(r.UploadTO)
+	uploads["memory"].Status = true
+	uploads["memory2"].Err = errors.New("загрузка не удалась")
+
+	policy := retention.Policy{Retention: config.Retention{KeepLast: 1}}
+	reports := r.PruneBackups(uploads, "host/nginx", policy, retention.Backup{YearMonth: "2024-05", Name: "01-10:00-nginx.zip"})
+
+	// В хранилище с неудачной загрузкой прежние архивы не трогаются
+	if _, ok := reports["memory2"]; ok {
+		t.Errorf("Очистка не должна выполняться в хранилище с неудачной загрузкой: %+v", reports["memory2"])
+	}
+	if report, ok := reports["memory"]; !ok || report.Err != nil {
+		t.Errorf("Ожидалась очистка в хранилище memory: %+v", report)
+	}
+}
+
+func TestRetryNewClient(t *testing.T) {
+	storage := &memStorage{files: map[string][]byte{}, refused: 2}
+	s := newRetryStorage(storage, retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	// Каждая попытка подключения открывает ровно одно подключение
+	if err := s.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if storage.dials != 3 || s.attempts != 3 {
+		t.Errorf("Тест не пройден ожидалось: 3 подключения, полученно: %v (попыток %v)", storage.dials, s.attempts)
+	}
+
+	// Перед повтором операции с данными хранилище переподключается
+	storage.flaky = 1
+	if err := s.UploadStream(bytes.NewReader(nil), "a", "b"); err == nil {
+		t.Errorf("Загрузка потоком не повторяется и должна завершиться ошибкой")
+	}
+	storage.flaky = 1
+	if err := s.do(func() error { return storage.UploadStream(bytes.NewReader(nil), "a", "b") }); err != nil || storage.dials != 4 {
+		t.Errorf("Ожидалось переподключение перед повтором, подключений: %v, ошибка: %v", storage.dials, err)
+	}
+}
@@ -0,0 +1,103 @@
+package remotestorages
+
+import (
+	"io"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/retry"
+)
+
+// retryStorage повторяет операции хранилища при временных ошибках согласно политике повторов хранилища.
+// Загрузка потоком не повторяется: уже прочитанную из потока часть архива нельзя прочитать заново.
+type retryStorage struct {
+	cloudStorages.Storage
+	policy   retry.Policy // политика повторов из [retry] и [storage.<name>.retry]
+	attempts int          // количество попыток последней операции
+}
+
+// newRetryStorage оборачивает storage повторами операций по политике policy.
+func newRetryStorage(storage cloudStorages.Storage, policy retry.Policy) *retryStorage {
+	return &retryStorage{Storage: storage, policy: policy}
+}
+
+// do выполняет операцию с данными fn с повторами. Хранилища с постоянным подключением, например SFTP и FTP,
+// перед повтором переподключаются, так как после обрыва соединения старый клиент уже не работает.
+func (s *retryStorage) do(fn func() error) error {
+	first := true
+	attempts, err := s.policy.Do(func() error {
+		if !first {
+			if err := s.reconnect(); err != nil {
+				return err
+			}
+		}
+		first = false
+		return fn()
+	})
+	s.attempts = attempts
+	return err
+}
+
+// reconnect заново подключается к хранилищу, если оно держит подключение.
+func (s *retryStorage) reconnect() error {
+	closer, ok := s.Storage.(io.Closer)
+	if !ok {
+		return nil
+	}
+	closer.Close()
+	return s.Storage.NewClient()
+}
+
+// NewClient создает клиента хранилища, повторяя подключение при временных ошибках. Подключение
+// повторяется без do: переподключение перед повтором открыло бы второе подключение.
+func (s *retryStorage) NewClient() error {
+	attempts, err := s.policy.Do(s.Storage.NewClient)
+	s.attempts = attempts
+	return err
+}
+
+// Close закрывает подключение хранилища, если оно его держит.
+func (s *retryStorage) Close() error {
+	if closer, ok := s.Storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (s *retryStorage) UploadFile(localPath, remotePath string) error {
+	return s.do(func() error { return s.Storage.UploadFile(localPath, remotePath) })
+}
+
+func (s *retryStorage) UploadStream(r io.Reader, name string, remotePath string) error {
+	s.attempts = 1
+	return s.Storage.UploadStream(r, name, remotePath)
+}
+
+func (s *retryStorage) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	var items []cloudStorages.File
+	err := s.do(func() (err error) {
+		items, err = s.Storage.ListDirItems(remotePath)
+		return err
+	})
+	return items, err
+}
+
+func (s *retryStorage) DownloadFile(fileID string, localPath string) error {
+	return s.do(func() error { return s.Storage.DownloadFile(fileID, localPath) })
+}
+
+func (s *retryStorage) Stat(remotePath string) (*cloudStorages.File, error) {
+	var file *cloudStorages.File
+	err := s.do(func() (err error) {
+		file, err = s.Storage.Stat(remotePath)
+		return err
+	})
+	return file, err
+}
+
+func (s *retryStorage) DeleteFile(fileID string) error {
+	return s.do(func() error { return s.Storage.DeleteFile(fileID) })
+}
+
+func (s *retryStorage) DeleteDir(dirID string) error {
+	return s.do(func() error { return s.Storage.DeleteDir(dirID) })
+}
@@ -1,12 +1,272 @@
+// Пакет samba реализует хранилище резервных копий в сетевой папке SMB2/3 (Samba, Windows)
+// с помощью встроенного клиента, без mount.cifs.
+//
+// Архивы сохраняются в //server/share/<папка>/<remotePath>/<name>. Архив сначала записывается
+// во временный файл и переименовывается после успешной загрузки.
 package samba
 
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/hirochachacha/go-smb2"
+)
+
+func init() {
+	cloudStorages.Register("samba", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		return New(*settings)
+	})
+}
+
+// Config содержит настройки хранилища типа samba.
+type Config struct {
+	SambaPath string `toml:"samba"`    // Путь к сетевому ресурсу: //server[:port]/share[/папка]
+	Username  string `toml:"username"` // Имя пользователя для доступа к ресурсу
+	Password  string `toml:"password"` // Пароль для доступа к ресурсу
+	Domain    string `toml:"domain"`   // Домен пользователя
+}
+
+// Samba представляет хранилище в сетевой папке SMB.
 type Samba struct {
 	SambaPath string
 	Username  string
 	Password  string
+	Domain    string
+
+	addr    string // Адрес сервера host:port
+	share   string // Имя общего ресурса
+	baseDir string // Папка внутри общего ресурса
+
+	conn    net.Conn
+	session *smb2.Session
+	fs      *smb2.Share
+}
+
+// New создает хранилище SMB с настройками conf. Подключение выполняется методом NewClient.
+func New(conf Config) (*Samba, error) {
+	addr, share, baseDir, err := parsePath(conf.SambaPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Samba{
+		SambaPath: conf.SambaPath,
+		Username:  conf.Username,
+		Password:  conf.Password,
+		Domain:    conf.Domain,
+		addr:      addr,
+		share:     share,
+		baseDir:   baseDir,
+	}, nil
+}
+
+// parsePath разбирает путь //server[:port]/share[/папка] на адрес сервера, общий ресурс и папку.
+// Допускаются также записи smb://server/share и \\server\share.
+func parsePath(sambaPath string) (addr, share, baseDir string, err error) {
+	p := strings.ReplaceAll(sambaPath, `\`, "/")
+	p = strings.TrimPrefix(p, "smb:")
+	p = strings.TrimLeft(p, "/")
+
+	parts := strings.SplitN(p, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("samba: некорректный путь %q, ожидается //server/share[/папка]", sambaPath)
+	}
+
+	addr = parts[0]
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "445")
+	}
+	if len(parts) == 3 {
+		baseDir = strings.Trim(parts[2], "/")
+	}
+	return addr, parts[1], baseDir, nil
 }
 
-func (s *Samba) Push() error {
+// NewClient подключается к серверу, выполняет вход и подключает общий ресурс.
+func (s *Samba) NewClient() error {
+	conn, err := net.DialTimeout("tcp", s.addr, 30*time.Second)
+	if err != nil {
+		return fmt.Errorf("samba: не удалось подключиться к %v: %w", s.addr, err)
+	}
+
+	dialer := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{
+			User:     s.Username,
+			Password: s.Password,
+			Domain:   s.Domain,
+		},
+	}
+	session, err := dialer.Dial(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("samba: ошибка входа на %v: %w", s.addr, err)
+	}
 
+	fs, err := session.Mount(s.share)
+	if err != nil {
+		session.Logoff()
+		conn.Close()
+		return fmt.Errorf("samba: не удалось подключить ресурс %v: %w", s.share, err)
+	}
+
+	s.conn, s.session, s.fs = conn, session, fs
 	return nil
 }
+
+// Close отключает общий ресурс и закрывает подключение к серверу.
+func (s *Samba) Close() error {
+	if s.fs == nil {
+		return nil
+	}
+	s.fs.Umount()
+	s.session.Logoff()
+	err := s.conn.Close()
+	s.conn, s.session, s.fs = nil, nil, nil
+	return err
+}
+
+// abs возвращает путь внутри общего ресурса для пути remotePath внутри хранилища.
+func (s *Samba) abs(remotePath string) string {
+	return strings.Trim(path.Join(s.baseDir, remotePath), "/")
+}
+
+// UploadFile загружает локальный файл localPath в папку remotePath.
+func (s *Samba) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл для чтения: %w", err)
+	}
+	defer file.Close()
+
+	return s.UploadStream(file, filepath.Base(localPath), remotePath)
+}
+
+// UploadStream загружает в папку remotePath файл с именем name, читая его содержимое из r.
+func (s *Samba) UploadStream(r io.Reader, name string, remotePath string) error {
+	dir := s.abs(remotePath)
+	if dir != "" {
+		if err := s.fs.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("samba: не удалось создать папку %v: %w", dir, err)
+		}
+	}
+
+	target := path.Join(dir, name)
+	part := target + cloudStorages.PartSuffix
+	file, err := s.fs.Create(part)
+	if err != nil {
+		return fmt.Errorf("samba: не удалось создать файл %v: %w", part, err)
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		s.fs.Remove(part)
+		return fmt.Errorf("samba: ошибка записи файла %v: %w", part, err)
+	}
+	if err := file.Close(); err != nil {
+		s.fs.Remove(part)
+		return fmt.Errorf("samba: ошибка записи файла %v: %w", part, err)
+	}
+
+	// Переименование не заменяет существующий файл, поэтому сначала удаляем старую копию
+	if _, err := s.fs.Stat(target); err == nil {
+		if err := s.fs.Remove(target); err != nil {
+			s.fs.Remove(part)
+			return fmt.Errorf("samba: не удалось заменить файл %v: %w", target, err)
+		}
+	}
+	if err := s.fs.Rename(part, target); err != nil {
+		s.fs.Remove(part)
+		return fmt.Errorf("samba: не удалось переименовать %v в %v: %w", part, target, err)
+	}
+	return nil
+}
+
+// ListDirItems возвращает файлы и папки в папке remotePath, отсортированные по имени.
+func (s *Samba) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	dir := s.abs(remotePath)
+	infos, err := s.fs.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("samba: не удалось прочитать папку %v: %w", dir, err)
+	}
+
+	var items []cloudStorages.File
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), cloudStorages.PartSuffix) {
+			continue
+		}
+		items = append(items, toFile(path.Join(dir, info.Name()), info))
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+// Stat возвращает информацию о файле по его пути в хранилище.
+func (s *Samba) Stat(remotePath string) (*cloudStorages.File, error) {
+	target := s.abs(remotePath)
+	info, err := s.fs.Stat(target)
+	if err != nil {
+		return nil, fmt.Errorf("samba: не удалось получить информацию о файле %v: %w", target, err)
+	}
+	file := toFile(target, info)
+	return &file, nil
+}
+
+// DownloadFile скачивает файл с путем fileID в общем ресурсе в localPath.
+func (s *Samba) DownloadFile(fileID string, localPath string) error {
+	return cloudStorages.DownloadFile(s, fileID, localPath)
+}
+
+// Open открывает на чтение файл с путем fileID в общем ресурсе.
+func (s *Samba) Open(fileID string) (io.ReadCloser, error) {
+	file, err := s.fs.Open(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("samba: не удалось открыть файл %v: %w", fileID, err)
+	}
+	return file, nil
+}
+
+// OpenRange открывает на чтение length байт файла fileID, начиная с offset.
+func (s *Samba) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	file, err := s.fs.Open(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("samba: не удалось открыть файл %v: %w", fileID, err)
+	}
+	return cloudStorages.NewSection(file, offset, length), nil
+}
+
+// DeleteFile удаляет файл с путем fileID в общем ресурсе.
+func (s *Samba) DeleteFile(fileID string) error {
+	if err := s.fs.Remove(fileID); err != nil {
+		return fmt.Errorf("samba: не удалось удалить файл %v: %w", fileID, err)
+	}
+	return nil
+}
+
+// DeleteDir удаляет папку с путем dirID в общем ресурсе вместе со всем содержимым.
+func (s *Samba) DeleteDir(dirID string) error {
+	if err := s.fs.RemoveAll(dirID); err != nil {
+		return fmt.Errorf("samba: не удалось удалить папку %v: %w", dirID, err)
+	}
+	return nil
+}
+
+// toFile преобразует информацию о файле в общем ресурсе в описание файла хранилища.
+func toFile(fullPath string, info os.FileInfo) cloudStorages.File {
+	return cloudStorages.File{
+		Id:   fullPath,
+		Name: info.Name(),
+		Size: info.Size(),
+		Dir:  info.IsDir(),
+	}
+}
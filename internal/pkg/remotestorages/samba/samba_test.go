@@ -0,0 +1,38 @@
+package samba
+
+import "testing"
+
+func TestParsePath(t *testing.T) {
+	testCases := []struct {
+		path        string
+		addr        string
+		share       string
+		baseDir     string
+		expectError bool
+	}{
+		{path: "//nas/backups", addr: "nas:445", share: "backups"},
+		{path: "//nas:1445/backups/kronos/", addr: "nas:1445", share: "backups", baseDir: "kronos"},
+		{path: `\\nas\backups\kronos\hosts`, addr: "nas:445", share: "backups", baseDir: "kronos/hosts"},
+		{path: "smb://10.0.0.5/share", addr: "10.0.0.5:445", share: "share"},
+		{path: "//nas", expectError: true},
+		{path: "", expectError: true},
+	}
+
+	for _, testCase := range testCases {
+		addr, share, baseDir, err := parsePath(testCase.path)
+		if testCase.expectError {
+			if err == nil {
+				t.Errorf("Для %q ожидалась ошибка, но ее не было", testCase.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Для %q не ожидалась ошибка, но она произошла: %v", testCase.path, err)
+			continue
+		}
+		if addr != testCase.addr || share != testCase.share || baseDir != testCase.baseDir {
+			t.Errorf("Тест не пройден ожидалось: %v %v %v, полученно: %v %v %v",
+				testCase.addr, testCase.share, testCase.baseDir, addr, share, baseDir)
+		}
+	}
+}
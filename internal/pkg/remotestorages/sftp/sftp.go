@@ -0,0 +1,318 @@
+// Пакет sftp реализует хранилище резервных копий на сервере, доступном по SSH (SFTP).
+//
+// Архивы сохраняются в baseDir/<remotePath>/<name>. Ключ сервера обязательно проверяется по файлу known_hosts.
+// Архив сначала записывается во временный файл и переименовывается после успешной загрузки,
+// поэтому в папке юнита не бывает недокачанных архивов.
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	sftpclient "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	cloudStorages.Register("sftp", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		return New(*settings)
+	})
+}
+
+// Config содержит настройки хранилища типа sftp.
+type Config struct {
+	Host       string `toml:"host"`       // Адрес сервера
+	Port       int    `toml:"port"`       // Порт SSH, по умолчанию 22
+	User       string `toml:"user"`       // Имя пользователя
+	Password   string `toml:"password"`   // Пароль, если не используется ключ
+	PrivateKey string `toml:"privateKey"` // Путь к приватному ключу
+	Passphrase string `toml:"passphrase"` // Пароль приватного ключа
+	KnownHosts string `toml:"knownHosts"` // Путь к файлу known_hosts, по умолчанию ~/.ssh/known_hosts
+	BaseDir    string `toml:"baseDir"`    // Папка на сервере, в которой хранятся резервные копии
+	Timeout    int    `toml:"timeout"`    // Таймаут подключения в секундах, по умолчанию 30
+}
+
+// SFTP представляет хранилище на SFTP сервере.
+type SFTP struct {
+	conf   Config
+	ssh    *ssh.Client
+	client *sftpclient.Client
+}
+
+// New создает хранилище SFTP с настройками conf. Подключение выполняется методом NewClient.
+func New(conf Config) (*SFTP, error) {
+	if conf.Host == "" || conf.User == "" {
+		return nil, fmt.Errorf("sftp: не указан host или user")
+	}
+	if conf.Password == "" && conf.PrivateKey == "" {
+		return nil, fmt.Errorf("sftp: не указан ни password, ни privateKey")
+	}
+	if conf.Port == 0 {
+		conf.Port = 22
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 30
+	}
+	if conf.KnownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("sftp: не указан knownHosts и не удалось определить домашнюю папку: %w", err)
+		}
+		conf.KnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if conf.BaseDir == "" {
+		conf.BaseDir = "."
+	}
+
+	return &SFTP{conf: conf}, nil
+}
+
+// NewClient подключается к серверу по SSH, проверяя его ключ по known_hosts, и открывает сессию SFTP.
+func (s *SFTP) NewClient() error {
+	hostKeyCallback, err := knownhosts.New(s.conf.KnownHosts)
+	if err != nil {
+		return fmt.Errorf("sftp: не удалось прочитать known_hosts %v: %w", s.conf.KnownHosts, err)
+	}
+
+	var auth []ssh.AuthMethod
+	if s.conf.PrivateKey != "" {
+		signer, err := s.signer()
+		if err != nil {
+			return err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if s.conf.Password != "" {
+		auth = append(auth, ssh.Password(s.conf.Password))
+	}
+
+	addr := net.JoinHostPort(s.conf.Host, strconv.Itoa(s.conf.Port))
+	sshClient, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            s.conf.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         time.Duration(s.conf.Timeout) * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("sftp: не удалось подключиться к %v: %w", addr, err)
+	}
+
+	client, err := sftpclient.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return fmt.Errorf("sftp: не удалось открыть сессию SFTP: %w", err)
+	}
+
+	s.ssh = sshClient
+	s.client = client
+	return nil
+}
+
+// signer читает приватный ключ из файла.
+func (s *SFTP) signer() (ssh.Signer, error) {
+	key, err := os.ReadFile(s.conf.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: не удалось прочитать приватный ключ: %w", err)
+	}
+	var signer ssh.Signer
+	if s.conf.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(s.conf.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sftp: не удалось разобрать приватный ключ: %w", err)
+	}
+	return signer, nil
+}
+
+// Close закрывает сессию SFTP и SSH подключение.
+func (s *SFTP) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	s.client.Close()
+	err := s.ssh.Close()
+	s.client, s.ssh = nil, nil
+	return err
+}
+
+// abs возвращает путь на сервере для пути remotePath внутри хранилища.
+func (s *SFTP) abs(remotePath string) string {
+	return path.Join(s.conf.BaseDir, remotePath)
+}
+
+// UploadFile загружает локальный файл localPath в папку remotePath.
+func (s *SFTP) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл для чтения: %w", err)
+	}
+	defer file.Close()
+
+	return s.UploadStream(file, filepath.Base(localPath), remotePath)
+}
+
+// UploadStream загружает в папку remotePath файл с именем name, читая его содержимое из r.
+func (s *SFTP) UploadStream(r io.Reader, name string, remotePath string) error {
+	dir, err := s.getOrCreateDir(remotePath)
+	if err != nil {
+		return err
+	}
+
+	target := path.Join(dir, name)
+	part := target + cloudStorages.PartSuffix
+	file, err := s.client.Create(part)
+	if err != nil {
+		return fmt.Errorf("sftp: не удалось создать файл %v: %w", part, err)
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		s.client.Remove(part)
+		return fmt.Errorf("sftp: ошибка записи файла %v: %w", part, err)
+	}
+	if err := file.Close(); err != nil {
+		s.client.Remove(part)
+		return fmt.Errorf("sftp: ошибка записи файла %v: %w", part, err)
+	}
+
+	if err := s.rename(part, target); err != nil {
+		s.client.Remove(part)
+		return fmt.Errorf("sftp: не удалось переименовать %v в %v: %w", part, target, err)
+	}
+	return nil
+}
+
+// rename атомарно заменяет newname файлом oldname, если сервер поддерживает posix-rename.
+func (s *SFTP) rename(oldname, newname string) error {
+	if err := s.client.PosixRename(oldname, newname); err == nil {
+		return nil
+	}
+	// Обычный SFTP rename не заменяет существующий файл
+	if _, err := s.client.Stat(newname); err == nil {
+		if err := s.client.Remove(newname); err != nil {
+			return err
+		}
+	}
+	return s.client.Rename(oldname, newname)
+}
+
+// getOrCreateDir создает по очереди все папки пути remotePath внутри baseDir, которых еще нет.
+func (s *SFTP) getOrCreateDir(remotePath string) (string, error) {
+	dir := s.conf.BaseDir
+	for _, folder := range strings.Split(remotePath, "/") {
+		if folder == "" {
+			continue
+		}
+		dir = path.Join(dir, folder)
+
+		info, err := s.client.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return "", fmt.Errorf("sftp: %v не является папкой", dir)
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("sftp: ошибка при поиске папки %v: %w", dir, err)
+		}
+		if err := s.client.Mkdir(dir); err != nil {
+			return "", fmt.Errorf("sftp: ошибка при создании папки %v: %w", dir, err)
+		}
+	}
+	return dir, nil
+}
+
+// ListDirItems возвращает файлы и папки в папке remotePath, отсортированные по имени.
+func (s *SFTP) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	dir := s.abs(remotePath)
+	infos, err := s.client.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: не удалось прочитать папку %v: %w", dir, err)
+	}
+
+	var items []cloudStorages.File
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), cloudStorages.PartSuffix) {
+			continue
+		}
+		items = append(items, toFile(path.Join(dir, info.Name()), info))
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+// Stat возвращает информацию о файле по его пути в хранилище.
+func (s *SFTP) Stat(remotePath string) (*cloudStorages.File, error) {
+	target := s.abs(remotePath)
+	info, err := s.client.Stat(target)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: не удалось получить информацию о файле %v: %w", target, err)
+	}
+	file := toFile(target, info)
+	return &file, nil
+}
+
+// DownloadFile скачивает файл с путем fileID на сервере в localPath.
+func (s *SFTP) DownloadFile(fileID string, localPath string) error {
+	return cloudStorages.DownloadFile(s, fileID, localPath)
+}
+
+// Open открывает на чтение файл с путем fileID на сервере.
+func (s *SFTP) Open(fileID string) (io.ReadCloser, error) {
+	file, err := s.client.Open(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: не удалось открыть файл %v: %w", fileID, err)
+	}
+	return file, nil
+}
+
+// OpenRange открывает на чтение length байт файла fileID, начиная с offset.
+func (s *SFTP) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	file, err := s.client.Open(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: не удалось открыть файл %v: %w", fileID, err)
+	}
+	return cloudStorages.NewSection(file, offset, length), nil
+}
+
+// DeleteFile удаляет файл с путем fileID на сервере.
+func (s *SFTP) DeleteFile(fileID string) error {
+	if err := s.client.Remove(fileID); err != nil {
+		return fmt.Errorf("sftp: не удалось удалить файл %v: %w", fileID, err)
+	}
+	return nil
+}
+
+// DeleteDir удаляет папку с путем dirID на сервере вместе со всем содержимым.
+func (s *SFTP) DeleteDir(dirID string) error {
+	if err := s.client.RemoveAll(dirID); err != nil {
+		return fmt.Errorf("sftp: не удалось удалить папку %v: %w", dirID, err)
+	}
+	return nil
+}
+
+// toFile преобразует информацию о файле на сервере в описание файла хранилища.
+func toFile(fullPath string, info os.FileInfo) cloudStorages.File {
+	return cloudStorages.File{
+		Id:   fullPath,
+		Name: info.Name(),
+		Size: info.Size(),
+		Dir:  info.IsDir(),
+	}
+}
@@ -0,0 +1,226 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/storagetest"
+	sftpclient "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startServer запускает SSH сервер с подсистемой SFTP на случайном порту и возвращает его адрес и ключ.
+func startServer(t *testing.T, password string) (string, int, ssh.PublicKey) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Ошибка генерации ключа сервера: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("Ошибка генерации ключа сервера: %v", err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if conn.User() == "backup" && string(pass) == password {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Ошибка запуска сервера: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, serverConfig)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, signer.PublicKey()
+}
+
+// serveConn обслуживает одно SSH подключение, запуская сервер SFTP в запрошенной подсистеме.
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(channelRequests)
+
+		go func() {
+			defer channel.Close()
+			server, err := sftpclient.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+		}()
+	}
+}
+
+// writeKnownHosts записывает файл known_hosts с ключом key для адреса host:port.
+func writeKnownHosts(t *testing.T, host string, port int, key ssh.PublicKey) string {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))}, key) + "\n"
+	if err := os.WriteFile(path, []byte(line), 0600); err != nil {
+		t.Fatalf("Ошибка записи known_hosts: %v", err)
+	}
+	return path
+}
+
+func TestSFTP(t *testing.T) {
+	host, port, key := startServer(t, "secret")
+	baseDir := t.TempDir()
+
+	storage, err := New(Config{
+		Host:       host,
+		Port:       port,
+		User:       "backup",
+		Password:   "secret",
+		KnownHosts: writeKnownHosts(t, host, port, key),
+		BaseDir:    baseDir,
+	})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	defer storage.Close()
+
+	data := bytes.Repeat([]byte("kronos"), 100000)
+	if err := storage.UploadStream(bytes.NewReader(data), "15-12:00-nginx.tar.gz", "host/nginx/2024-03"); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	localPath := filepath.Join(t.TempDir(), "20-13:37-nginx.zip")
+	if err := os.WriteFile(localPath, []byte("archive"), 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if err := storage.UploadFile(localPath, "host/nginx/2024-02"); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	// Архив лежит в иерархии remotePath и временный файл не остался
+	uploaded, err := os.ReadFile(filepath.Join(baseDir, "host/nginx/2024-03/15-12:00-nginx.tar.gz"))
+	if err != nil || !bytes.Equal(uploaded, data) {
+		t.Fatalf("Архив не загружен или поврежден: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "host/nginx/2024-03/15-12:00-nginx.tar.gz"+cloudStorages.PartSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Временный файл загрузки не должен оставаться")
+	}
+
+	items, err := storage.ListDirItems("host/nginx")
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if len(items) != 2 || items[0].Name != "2024-02" || !items[0].IsDir() || items[1].Name != "2024-03" {
+		t.Fatalf("Неверный список папок: %+v", items)
+	}
+
+	info, err := storage.Stat("host/nginx/2024-03/15-12:00-nginx.tar.gz")
+	if err != nil || info.Size != int64(len(data)) {
+		t.Fatalf("Неверная информация о файле: %+v, %v", info, err)
+	}
+
+	downloaded := filepath.Join(t.TempDir(), "restore.tar.gz")
+	if err := storage.DownloadFile(info.Id, downloaded); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if got, err := os.ReadFile(downloaded); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Скачанный архив не совпадает с загруженным: %v", err)
+	}
+
+	if err := storage.DeleteFile(info.Id); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.DeleteDir(items[0].Id); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	items, err = storage.ListDirItems("host/nginx")
+	if err != nil || len(items) != 1 || items[0].Name != "2024-03" {
+		t.Errorf("Неверный список папок после удаления: %+v, %v", items, err)
+	}
+}
+
+func TestSFTPConformance(t *testing.T) {
+	host, port, key := startServer(t, "secret")
+	storage, err := New(Config{
+		Host:       host,
+		Port:       port,
+		User:       "backup",
+		Password:   "secret",
+		KnownHosts: writeKnownHosts(t, host, port, key),
+		BaseDir:    t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	defer storage.Close()
+
+	storagetest.Run(t, storage)
+}
+
+func TestSFTPUnknownHostKey(t *testing.T) {
+	host, port, _ := startServer(t, "secret")
+
+	// В known_hosts записан чужой ключ
+	otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	sshKey, err := ssh.NewPublicKey(otherKey)
+	if err != nil {
+		t.Fatalf("Ошибка генерации ключа: %v", err)
+	}
+
+	storage, err := New(Config{
+		Host:       host,
+		Port:       port,
+		User:       "backup",
+		Password:   "secret",
+		KnownHosts: writeKnownHosts(t, host, port, sshKey),
+		BaseDir:    t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err == nil {
+		storage.Close()
+		t.Errorf("Ожидалась ошибка проверки ключа сервера, но ее не было")
+	}
+}
@@ -0,0 +1,132 @@
+// Пакет storagetest содержит общие тесты хранилищ резервных копий. Каждое хранилище должно одинаково
+// загружать архивы в раскладку <remotePath>/<unit>/<YYYY-MM>, перечислять, скачивать и удалять их,
+// чтобы очистка по сроку хранения и команды kk работали с любым хранилищем.
+//
+// Использование в тесте пакета хранилища:
+//
+//	storage := newTestStorage(t)
+//	storagetest.Run(t, storage)
+package storagetest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+)
+
+// Run проверяет хранилище storage, к которому уже выполнено подключение методом NewClient.
+// Хранилище должно быть пустым.
+func Run(t *testing.T, storage cloudStorages.Storage) {
+	t.Helper()
+
+	const (
+		unitPath = "host/nginx"
+		month    = "2024-03"
+		monthDir = unitPath + "/" + month
+	)
+	archive := bytes.Repeat([]byte("kronoskeeper"), 1000)
+
+	// Загрузка файла создает недостающие папки
+	localPath := filepath.Join(t.TempDir(), "03-13:37-nginx.zip")
+	if err := os.WriteFile(localPath, []byte("old"), 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if err := storage.UploadFile(localPath, monthDir); err != nil {
+		t.Fatalf("Ошибка загрузки файла: %v", err)
+	}
+	// Повторная загрузка заменяет архив
+	if err := os.WriteFile(localPath, archive, 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if err := storage.UploadFile(localPath, monthDir); err != nil {
+		t.Fatalf("Ошибка повторной загрузки файла: %v", err)
+	}
+	if err := storage.UploadStream(strings.NewReader("stream"), "04-13:37-nginx.zip", monthDir); err != nil {
+		t.Fatalf("Ошибка потоковой загрузки: %v", err)
+	}
+
+	months, err := storage.ListDirItems(unitPath)
+	if err != nil {
+		t.Fatalf("Ошибка получения списка папок: %v", err)
+	}
+	if len(months) != 1 || months[0].Name != month || !months[0].IsDir() {
+		t.Fatalf("Тест не пройден ожидалась папка %v, полученно: %+v", month, months)
+	}
+
+	items, err := storage.ListDirItems(monthDir)
+	if err != nil {
+		t.Fatalf("Ошибка получения списка архивов: %v", err)
+	}
+	if len(items) != 2 || items[0].Name != "03-13:37-nginx.zip" || items[1].Name != "04-13:37-nginx.zip" {
+		t.Fatalf("Неверный список архивов: %+v", items)
+	}
+	if items[0].Size != int64(len(archive)) || items[0].IsDir() {
+		t.Errorf("Тест не пройден ожидалось: %v байт, полученно: %+v", len(archive), items[0])
+	}
+
+	file, err := storage.Stat(monthDir + "/03-13:37-nginx.zip")
+	if err != nil {
+		t.Fatalf("Ошибка получения информации о файле: %v", err)
+	}
+	if file.Id != items[0].Id || file.Size != int64(len(archive)) {
+		t.Errorf("Тест не пройден ожидалось: %+v, полученно: %+v", items[0], file)
+	}
+	if _, err := storage.Stat(monthDir + "/missing.zip"); err == nil {
+		t.Errorf("Для несуществующего файла ожидалась ошибка")
+	}
+
+	restored := filepath.Join(t.TempDir(), "restored.zip")
+	if err := storage.DownloadFile(file.Id, restored); err != nil {
+		t.Fatalf("Ошибка скачивания: %v", err)
+	}
+	if data, _ := os.ReadFile(restored); !bytes.Equal(data, archive) {
+		t.Errorf("Скачанный архив не совпадает с загруженным, %v байт вместо %v", len(data), len(archive))
+	}
+
+	// Хранилища с методом Open отдают архив без скачивания на диск
+	if opener, ok := storage.(cloudStorages.Opener); ok {
+		reader, err := opener.Open(file.Id)
+		if err != nil {
+			t.Fatalf("Ошибка открытия файла: %v", err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(data, archive) {
+			t.Errorf("Прочитанный архив не совпадает с загруженным: %v", err)
+		}
+	}
+
+	// Хранилища с методом OpenRange отдают часть архива
+	if opener, ok := storage.(cloudStorages.RangeOpener); ok {
+		reader, err := opener.OpenRange(file.Id, 100, 50)
+		if err != nil {
+			t.Fatalf("Ошибка открытия части файла: %v", err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(data, archive[100:150]) {
+			t.Errorf("Тест не пройден ожидалось: %q, полученно: %q, %v", archive[100:150], data, err)
+		}
+	}
+
+	if err := storage.DeleteFile(items[1].Id); err != nil {
+		t.Fatalf("Ошибка удаления файла: %v", err)
+	}
+	items, err = storage.ListDirItems(monthDir)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("После удаления должен остаться один архив: %+v, %v", items, err)
+	}
+
+	if err := storage.DeleteDir(months[0].Id); err != nil {
+		t.Fatalf("Ошибка удаления папки: %v", err)
+	}
+	months, _ = storage.ListDirItems(unitPath)
+	if len(months) != 0 {
+		t.Errorf("Папка месяца должна была быть удалена: %+v", months)
+	}
+}
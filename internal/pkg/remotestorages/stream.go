@@ -0,0 +1,101 @@
+package remotestorages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+)
+
+// errStreamStopped возвращается писателю, если хранилище перестало читать поток до его окончания.
+var errStreamStopped = errors.New("загрузка в хранилище остановлена")
+
+// Stream передает архив одновременно во все удаленные хранилища из UploadTO без сохранения на диск.
+// Ошибка одного хранилища не прерывает загрузку в остальные.
+type Stream struct {
+	targets []*streamTarget
+	reports UploadReports
+	wg      sync.WaitGroup
+}
+
+// streamTarget описывает загрузку потока в одно хранилище.
+type streamTarget struct {
+	pw     *io.PipeWriter
+	failed bool          // хранилище перестало принимать данные
+	report *UploadReport // отчет хранилища
+}
+
+// OpenStream создает клиентов удаленных хранилищ и запускает загрузку файла name в r.RemotePath.
+// Данные архива записываются в возвращаемый Stream, результат загрузки возвращает Close.
+func (r *Remotestorages) OpenStream(name string) *Stream {
+	s := &Stream{reports: NewReports(r.UploadTO)}
+
+	for _, TO := range r.UploadTO {
+		report := s.reports[TO]
+		uploader, err := r.Storage(TO)
+		if err != nil {
+			report.Err = fmt.Errorf("UploadStream %v: %w", TO, err)
+			continue
+		}
+
+		pr, pw := io.Pipe()
+		target := &streamTarget{pw: pw, report: report}
+		s.targets = append(s.targets, target)
+
+		s.wg.Add(1)
+		go func(TO string, uploader cloudStorages.Storage) {
+			defer s.wg.Done()
+			err := uploader.UploadStream(pr, name, r.RemotePath)
+			// Разблокируем писателя, даже если хранилище не дочитало поток
+			pr.CloseWithError(errStreamStopped)
+			target.report.Attempts = 1
+			if err != nil {
+				target.report.Err = fmt.Errorf("UploadStream %v: %w", TO, err)
+				return
+			}
+			target.report.Status = true
+		}(TO, uploader)
+	}
+
+	return s
+}
+
+// Write передает p во все хранилища, которые еще принимают данные.
+// Ошибка возвращается только когда ни одно хранилище больше не принимает данные.
+func (s *Stream) Write(p []byte) (int, error) {
+	active := 0
+	for _, target := range s.targets {
+		if target.failed {
+			continue
+		}
+		if _, err := target.pw.Write(p); err != nil {
+			target.failed = true
+			continue
+		}
+		active++
+	}
+	if active == 0 {
+		return 0, fmt.Errorf("ни одно удаленное хранилище не принимает архив")
+	}
+	return len(p), nil
+}
+
+// Close завершает поток и дожидается окончания загрузки во все хранилища.
+func (s *Stream) Close() UploadReports {
+	for _, target := range s.targets {
+		target.pw.Close()
+	}
+	s.wg.Wait()
+	return s.reports
+}
+
+// CloseWithError прерывает загрузку во все хранилища с ошибкой err, например если не удалось создать архив.
+func (s *Stream) CloseWithError(err error) UploadReports {
+	for _, target := range s.targets {
+		target.pw.CloseWithError(err)
+	}
+	s.wg.Wait()
+	return s.reports
+}
@@ -0,0 +1,154 @@
+package remotestorages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+)
+
+// Способы, которыми была проверена загруженная копия архива.
+const (
+	VerifiedMD5    = "md5"    // MD5, посчитанный хранилищем, совпал с MD5 архива
+	VerifiedSHA256 = "sha256" // SHA-256 архива, повторно прочитанного из хранилища, совпал с SHA-256 архива
+	VerifiedSize   = "size"   // совпал только размер, хранилище не сообщает контрольную сумму
+)
+
+// Checksums содержит размер и контрольные суммы архива, с которыми сверяется его загруженная копия.
+type Checksums struct {
+	Size   int64  // Размер архива в байтах
+	SHA256 string // SHA-256 архива в шестнадцатеричном виде
+	MD5    string // MD5 архива в шестнадцатеричном виде
+}
+
+// mismatchError означает, что загруженная копия архива отличается от локальной.
+type mismatchError struct {
+	msg string
+}
+
+func (e *mismatchError) Error() string {
+	return e.msg
+}
+
+// VerifyUploads проверяет целостность архива name, загруженного в папку r.RemotePath хранилищ из отчетов reports.
+// Проверяются только успешные загрузки. Если копия в хранилище отличается от архива, она удаляется,
+// а загрузка в отчете считается неудачной.
+func (r *Remotestorages) VerifyUploads(reports UploadReports, name string, sums Checksums) {
+	for _, TO := range reports.Names() {
+		report := reports[TO]
+		if !report.Status {
+			continue
+		}
+		storage, err := r.storage(TO)
+		if err == nil {
+			report.Verified, err = storage.verify(path.Join(r.RemotePath, name), sums, r.conf[TO].Verify)
+		}
+		if err != nil {
+			report.Status = false
+			report.Err = fmt.Errorf("VerifyUpload %v: %w", TO, err)
+		}
+	}
+}
+
+// verify сверяет файл remotePath в хранилище с размером и контрольными суммами sums способом mode
+// и возвращает способ, которым копия была проверена.
+func (s *retryStorage) verify(remotePath string, sums Checksums, mode string) (string, error) {
+	if mode == config.VerifyNone {
+		return "", nil
+	}
+
+	file, err := s.Stat(remotePath)
+	if err != nil {
+		return "", fmt.Errorf("не удалось получить информацию о загруженном архиве: %w", err)
+	}
+
+	verified, err := s.compare(file, sums, mode)
+	if _, ok := err.(*mismatchError); ok {
+		if deleteErr := s.DeleteFile(file.Id); deleteErr != nil {
+			return "", fmt.Errorf("архив %v поврежден при загрузке: %w, удалить поврежденную копию не удалось: %v", remotePath, err, deleteErr)
+		}
+		return "", fmt.Errorf("архив %v поврежден при загрузке и удален из хранилища: %w", remotePath, err)
+	}
+	return verified, err
+}
+
+// compare сравнивает файл хранилища с sums. Сначала сверяется размер, затем MD5, если хранилище его сообщает,
+// иначе для способа checksum архив читается из хранилища заново и сверяется SHA-256.
+func (s *retryStorage) compare(file *cloudStorages.File, sums Checksums, mode string) (string, error) {
+	if file.Size != sums.Size {
+		return "", &mismatchError{fmt.Sprintf("размер не совпадает: локально %v байт, в хранилище %v байт", sums.Size, file.Size)}
+	}
+	if file.MD5 != "" && sums.MD5 != "" {
+		if !strings.EqualFold(file.MD5, sums.MD5) {
+			return "", &mismatchError{fmt.Sprintf("MD5 не совпадает: локально %v, в хранилище %v", sums.MD5, file.MD5)}
+		}
+		return VerifiedMD5, nil
+	}
+	if mode == config.VerifySize || sums.SHA256 == "" {
+		return VerifiedSize, nil
+	}
+
+	var sum string
+	err := s.do(func() (err error) {
+		sum, err = s.readSHA256(file.Id)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("не удалось прочитать загруженный архив для проверки: %w", err)
+	}
+	if sum != sums.SHA256 {
+		return "", &mismatchError{fmt.Sprintf("SHA-256 не совпадает: локально %v, в хранилище %v", sums.SHA256, sum)}
+	}
+	return VerifiedSHA256, nil
+}
+
+// readSHA256 читает файл fileID из хранилища и возвращает его SHA-256.
+func (s *retryStorage) readSHA256(fileID string) (string, error) {
+	file, err := s.open(fileID)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// open открывает файл fileID хранилища на чтение. Хранилища без метода Open скачивают файл
+// во временный файл, который удаляется при закрытии.
+func (s *retryStorage) open(fileID string) (io.ReadCloser, error) {
+	if opener, ok := s.Storage.(cloudStorages.Opener); ok {
+		return opener.Open(fileID)
+	}
+
+	tmp, err := os.CreateTemp("", "kronoskeeper-*")
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать временный файл: %w", err)
+	}
+	if err := s.Storage.DownloadFile(fileID, tmp.Name()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &tempFile{tmp}, nil
+}
+
+// tempFile - временный файл, который удаляется при закрытии.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
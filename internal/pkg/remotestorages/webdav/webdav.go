@@ -0,0 +1,472 @@
+// Пакет webdav реализует хранилище резервных копий на сервере WebDAV: Nextcloud, ownCloud, Яндекс Диск и других.
+//
+// Архивы сохраняются в <url>/<remotePath>/<name>. Недостающие папки создаются запросами MKCOL, список файлов
+// получается запросом PROPFIND. Архив загружается запросом PUT во временный файл и переносится на место
+// запросом MOVE, поэтому в папке юнита не бывает недокачанных архивов.
+//
+// Для Nextcloud и ownCloud архивы больше ChunkSizeMB загружаются частями по протоколу загрузки частями
+// Nextcloud: части сохраняются в папку /remote.php/dav/uploads/<пользователь>/<id>, а запрос MOVE
+// виртуального файла .file собирает из них архив на месте. Так большие архивы не упираются в ограничения
+// размера запроса на прокси перед сервером.
+package webdav
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+)
+
+// filesPath - часть адреса Nextcloud перед именем пользователя, uploadsPath - адрес папок загрузки частями.
+const (
+	filesPath   = "/remote.php/dav/files/"
+	uploadsPath = "/remote.php/dav/uploads/"
+)
+
+// propfindBody запрашивает свойства, нужные для списка файлов.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/></d:prop></d:propfind>`
+
+func init() {
+	cloudStorages.Register("webdav", func(conf *config.Storage) (cloudStorages.Storage, error) {
+		settings := &Config{}
+		if err := conf.Decode(settings); err != nil {
+			return nil, err
+		}
+		return New(*settings)
+	})
+}
+
+// Config содержит настройки хранилища типа webdav.
+type Config struct {
+	URL      string `toml:"url"`      // Адрес папки на сервере WebDAV, например https://cloud.example.com/remote.php/dav/files/user/backups
+	Username string `toml:"username"` // Имя пользователя
+	Password string `toml:"password"` // Пароль или пароль приложения
+	Timeout  int    `toml:"timeout"`  // Таймаут подключения и ожидания ответа в секундах, по умолчанию 30
+	// Размер части в мегабайтах для загрузки частями Nextcloud, 0 - архив загружается одним запросом PUT.
+	// Требует адрес вида https://<сервер>/remote.php/dav/files/<пользователь>/...
+	ChunkSizeMB int `toml:"chunkSizeMB"`
+}
+
+// WebDAV представляет хранилище на сервере WebDAV.
+type WebDAV struct {
+	conf    Config
+	base    *url.URL
+	uploads *url.URL // Папка загрузок частями Nextcloud, nil если загрузка частями выключена
+	client  *http.Client
+}
+
+// New создает хранилище WebDAV с настройками conf. Доступность сервера проверяется методом NewClient.
+func New(conf Config) (*WebDAV, error) {
+	base, err := url.Parse(conf.URL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("webdav: некорректный url %q", conf.URL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+	if conf.Timeout == 0 {
+		conf.Timeout = 30
+	}
+	w := &WebDAV{conf: conf, base: base}
+	if conf.ChunkSizeMB > 0 {
+		// Папка загрузок принадлежит пользователю из адреса его файлов
+		_, rest, ok := strings.Cut(base.Path, filesPath)
+		user, _, _ := strings.Cut(rest, "/")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("webdav: загрузка частями поддерживается только для адреса вида .../remote.php/dav/files/<пользователь>/, указан %q", conf.URL)
+		}
+		w.uploads = &url.URL{Scheme: base.Scheme, Host: base.Host, Path: uploadsPath + user + "/"}
+	}
+	return w, nil
+}
+
+// NewClient создает HTTP клиент и проверяет, что папка url доступна с указанными учетными данными.
+func (w *WebDAV) NewClient() error {
+	timeout := time.Duration(w.conf.Timeout) * time.Second
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+	// Общий таймаут запроса не задается, чтобы не прерывать загрузку больших архивов
+	w.client = &http.Client{Transport: transport}
+
+	if _, err := w.propfind("", "0"); err != nil {
+		return fmt.Errorf("webdav: папка %v недоступна: %w", w.conf.URL, err)
+	}
+	return nil
+}
+
+// url возвращает адрес файла или папки с путем remotePath внутри хранилища.
+func (w *WebDAV) url(remotePath string) string {
+	u := *w.base
+	u.RawPath = ""
+	u.Path = path.Join(w.base.Path, remotePath)
+	if strings.HasSuffix(remotePath, "/") || remotePath == "" {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// uploadURL возвращает адрес части name в папке загрузки частями id, пустой name - адрес самой папки.
+func (w *WebDAV) uploadURL(id, name string) string {
+	u := *w.uploads
+	u.Path = path.Join(w.uploads.Path, id, name)
+	if name == "" {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// request выполняет запрос method к пути remotePath и возвращает ответ, если его код входит в expect.
+// Размер тела size передается серверу, если он неотрицателен, иначе тело передается с Transfer-Encoding: chunked.
+func (w *WebDAV) request(method, remotePath string, body io.Reader, size int64, header http.Header, expect ...int) (*http.Response, error) {
+	return w.send(method, w.url(remotePath), remotePath, body, size, header, expect...)
+}
+
+// send выполняет запрос method по адресу target, как request. Имя name используется в ошибках.
+func (w *WebDAV) send(method, target, name string, body io.Reader, size int64, header http.Header, expect ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if w.conf.Username != "" || w.conf.Password != "" {
+		req.SetBasicAuth(w.conf.Username, w.conf.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса %v %v: %w", method, name, err)
+	}
+	for _, code := range expect {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, &statusError{method: method, path: name, code: resp.StatusCode}
+}
+
+// do выполняет запрос без тела или с телом из памяти.
+func (w *WebDAV) do(method, remotePath string, body *bytes.Buffer, header http.Header, expect ...int) (*http.Response, error) {
+	if body == nil {
+		return w.request(method, remotePath, nil, 0, header, expect...)
+	}
+	return w.request(method, remotePath, body, int64(body.Len()), header, expect...)
+}
+
+// statusError описывает неожиданный ответ сервера.
+type statusError struct {
+	method string
+	path   string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%v %v: сервер ответил %v %v", e.method, e.path, e.code, http.StatusText(e.code))
+}
+
+// StatusCode возвращает код ответа сервера, по которому повторы определяют временную ошибку.
+func (e *statusError) StatusCode() int {
+	return e.code
+}
+
+// UploadFile загружает локальный файл localPath в папку remotePath.
+func (w *WebDAV) UploadFile(localPath, remotePath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл для чтения: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("не удалось получить информацию о файле: %w", err)
+	}
+	return w.upload(file, info.Size(), filepath.Base(localPath), remotePath)
+}
+
+// UploadStream загружает в папку remotePath файл с именем name, читая его содержимое из r.
+// Размер потока заранее неизвестен, поэтому архив передается с Transfer-Encoding: chunked без буферизации в памяти,
+// а при загрузке частями в памяти хранится одна часть.
+func (w *WebDAV) UploadStream(r io.Reader, name string, remotePath string) error {
+	return w.upload(r, -1, name, remotePath)
+}
+
+// upload загружает файл размером size во временный файл и переносит его на место запросом MOVE.
+// Файлы больше ChunkSizeMB и потоки неизвестного размера при включенной загрузке частями загружаются частями.
+func (w *WebDAV) upload(r io.Reader, size int64, name string, remotePath string) error {
+	if err := w.mkdirAll(remotePath); err != nil {
+		return err
+	}
+
+	target := path.Join(remotePath, name)
+	if w.uploads != nil && (size < 0 || size > w.chunkSize()) {
+		return w.uploadChunked(r, target)
+	}
+
+	part := target + cloudStorages.PartSuffix
+	resp, err := w.request(http.MethodPut, part, r, size, nil, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		// Сервер мог сохранить часть файла до обрыва загрузки
+		w.do(http.MethodDelete, part, nil, nil, http.StatusNoContent, http.StatusOK)
+		return fmt.Errorf("webdav: ошибка загрузки файла %v: %w", part, err)
+	}
+	resp.Body.Close()
+
+	header := http.Header{"Destination": {w.url(target)}, "Overwrite": {"T"}}
+	resp, err = w.do("MOVE", part, nil, header, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		w.do(http.MethodDelete, part, nil, nil, http.StatusNoContent, http.StatusOK)
+		return fmt.Errorf("webdav: не удалось переместить %v в %v: %w", part, target, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// chunkSize возвращает размер части в байтах для загрузки частями.
+func (w *WebDAV) chunkSize() int64 {
+	return int64(w.conf.ChunkSizeMB) << 20
+}
+
+// uploadChunked загружает файл из r в target частями по ChunkSizeMB мегабайт: создает папку загрузки запросом MKCOL,
+// загружает в нее части запросами PUT и собирает из них файл запросом MOVE виртуального файла .file.
+// При ошибке папка загрузки удаляется вместе с загруженными частями.
+func (w *WebDAV) uploadChunked(r io.Reader, target string) error {
+	id, err := transferID()
+	if err != nil {
+		return fmt.Errorf("webdav: %w", err)
+	}
+	dir := w.uploadURL(id, "")
+	resp, err := w.send("MKCOL", dir, id, nil, 0, nil, http.StatusCreated)
+	if err != nil {
+		return fmt.Errorf("webdav: не удалось начать загрузку частями %v: %w", target, err)
+	}
+	resp.Body.Close()
+
+	if err := w.putChunks(r, id, target); err != nil {
+		w.send(http.MethodDelete, dir, id, nil, 0, nil, http.StatusNoContent, http.StatusOK)
+		return err
+	}
+	return nil
+}
+
+// putChunks загружает части файла из r в папку загрузки id и собирает из них файл target.
+// Части называются по порядку 00001, 00002 и так далее, сервер соединяет их в порядке имен.
+func (w *WebDAV) putChunks(r io.Reader, id, target string) error {
+	buf := make([]byte, w.chunkSize())
+	var total int64
+	for n := 1; ; n++ {
+		read, readErr := io.ReadFull(r, buf)
+		if readErr == io.EOF && n > 1 {
+			break
+		}
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return fmt.Errorf("webdav: ошибка чтения файла %v: %w", target, readErr)
+		}
+
+		chunk := fmt.Sprintf("%05d", n)
+		resp, err := w.send(http.MethodPut, w.uploadURL(id, chunk), path.Join(id, chunk), bytes.NewReader(buf[:read]), int64(read), nil,
+			http.StatusCreated, http.StatusNoContent, http.StatusOK)
+		if err != nil {
+			return fmt.Errorf("webdav: ошибка загрузки части %v файла %v: %w", n, target, err)
+		}
+		resp.Body.Close()
+		total += int64(read)
+
+		// Последняя часть короче остальных
+		if readErr != nil {
+			break
+		}
+	}
+
+	header := http.Header{"Destination": {w.url(target)}, "Overwrite": {"T"}, "OC-Total-Length": {strconv.FormatInt(total, 10)}}
+	resp, err := w.send("MOVE", w.uploadURL(id, ".file"), path.Join(id, ".file"), nil, 0, header, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("webdav: не удалось собрать файл %v из частей: %w", target, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// transferID возвращает случайный идентификатор папки загрузки частями.
+func transferID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("не удалось создать идентификатор загрузки: %w", err)
+	}
+	return "kronoskeeper-" + hex.EncodeToString(b), nil
+}
+
+// mkdirAll создает запросами MKCOL по очереди все папки пути remotePath, которых еще нет.
+func (w *WebDAV) mkdirAll(remotePath string) error {
+	dir := ""
+	for _, folder := range strings.Split(remotePath, "/") {
+		if folder == "" {
+			continue
+		}
+		dir = path.Join(dir, folder)
+		// 405 означает, что папка уже существует
+		resp, err := w.do("MKCOL", dir+"/", nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return fmt.Errorf("webdav: ошибка при создании папки %v: %w", dir, err)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// multistatus описывает ответ на запрос PROPFIND.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind возвращает свойства пути remotePath (depth "0") или его содержимого (depth "1").
+// Сама папка remotePath в результат с depth "1" не попадает.
+func (w *WebDAV) propfind(remotePath, depth string) ([]cloudStorages.File, error) {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := w.do("PROPFIND", remotePath, bytes.NewBufferString(propfindBody), header, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("не удалось разобрать ответ PROPFIND %v: %w", remotePath, err)
+	}
+
+	self := strings.Trim(path.Join(w.base.Path, remotePath), "/")
+	var items []cloudStorages.File
+	for _, response := range result.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный href %q: %w", response.Href, err)
+		}
+		hrefPath := strings.Trim(href.Path, "/")
+		if depth != "0" && hrefPath == self {
+			continue
+		}
+
+		file := cloudStorages.File{Name: path.Base("/" + hrefPath)}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			if propstat.Prop.ResourceType.Collection != nil {
+				file.Dir = true
+			}
+			if propstat.Prop.ContentLength != "" {
+				file.Size, _ = strconv.ParseInt(propstat.Prop.ContentLength, 10, 64)
+			}
+		}
+		if depth == "0" {
+			file.Id = strings.Trim(remotePath, "/")
+		} else {
+			file.Id = path.Join(strings.Trim(remotePath, "/"), file.Name)
+		}
+		items = append(items, file)
+	}
+	return items, nil
+}
+
+// ListDirItems возвращает файлы и папки в папке remotePath, отсортированные по имени.
+func (w *WebDAV) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	all, err := w.propfind(strings.TrimSuffix(remotePath, "/")+"/", "1")
+	if err != nil {
+		return nil, fmt.Errorf("webdav: не удалось прочитать папку %v: %w", remotePath, err)
+	}
+
+	var items []cloudStorages.File
+	for _, item := range all {
+		if !strings.HasSuffix(item.Name, cloudStorages.PartSuffix) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+// Stat возвращает информацию о файле по его пути в хранилище.
+func (w *WebDAV) Stat(remotePath string) (*cloudStorages.File, error) {
+	items, err := w.propfind(remotePath, "0")
+	if err != nil {
+		return nil, fmt.Errorf("webdav: не удалось получить информацию о файле %v: %w", remotePath, err)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("webdav: файл %v не найден", remotePath)
+	}
+	return &items[0], nil
+}
+
+// DownloadFile скачивает файл с путем fileID в localPath.
+func (w *WebDAV) DownloadFile(fileID string, localPath string) error {
+	return cloudStorages.DownloadFile(w, fileID, localPath)
+}
+
+// Open открывает на чтение файл с путем fileID.
+func (w *WebDAV) Open(fileID string) (io.ReadCloser, error) {
+	resp, err := w.do(http.MethodGet, fileID, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("webdav: не удалось скачать файл %v: %w", fileID, err)
+	}
+	return resp.Body, nil
+}
+
+// OpenRange открывает на чтение length байт файла с путем fileID, начиная с offset.
+func (w *WebDAV) OpenRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": {cloudStorages.RangeHeader(offset, length)}}
+	resp, err := w.do(http.MethodGet, fileID, nil, header, http.StatusPartialContent)
+	if err != nil {
+		return nil, fmt.Errorf("webdav: не удалось прочитать часть файла %v: %w", fileID, err)
+	}
+	return resp.Body, nil
+}
+
+// DeleteFile удаляет файл с путем fileID.
+func (w *WebDAV) DeleteFile(fileID string) error {
+	resp, err := w.do(http.MethodDelete, fileID, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("webdav: не удалось удалить файл %v: %w", fileID, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeleteDir удаляет папку с путем dirID вместе со всем содержимым.
+func (w *WebDAV) DeleteDir(dirID string) error {
+	resp, err := w.do(http.MethodDelete, strings.TrimSuffix(dirID, "/")+"/", nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("webdav: не удалось удалить папку %v: %w", dirID, err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,239 @@
+package webdav
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/storagetest"
+	"golang.org/x/net/webdav"
+)
+
+// testServer - сервер WebDAV для тестов.
+type testServer struct {
+	url     string   // Адрес папки пользователя backup
+	chunked int      // Количество запросов PUT с Transfer-Encoding: chunked
+	chunks  int      // Количество загруженных частей при загрузке частями
+	deleted []string // Пути запросов DELETE
+}
+
+// startServer запускает сервер WebDAV с базовой авторизацией, хранящий файлы в папке root
+// по адресу /remote.php/dav/files/backup/, как Nextcloud. Загрузка частями Nextcloud хранит части
+// в папке uploads внутри root, запрос MOVE файла .file собирает их в файл. Запрос PUT файла,
+// имя которого содержит "full", завершается ошибкой 507.
+func startServer(t *testing.T, root string) *testServer {
+	files := &webdav.Handler{
+		Prefix:     "/remote.php/dav/files/backup",
+		FileSystem: webdav.Dir(root),
+		LockSystem: webdav.NewMemLS(),
+	}
+	uploadsDir := filepath.Join(root, "uploads")
+	uploads := &webdav.Handler{
+		Prefix:     "/remote.php/dav/uploads/backup",
+		FileSystem: webdav.Dir(uploadsDir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	if err := os.Mkdir(uploadsDir, 0755); err != nil {
+		t.Fatalf("Ошибка при создании папки: %v", err)
+	}
+
+	ts := &testServer{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "backup" || pass != "app-password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPut && r.ContentLength < 0 {
+			ts.chunked++
+		}
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "full") {
+			w.WriteHeader(http.StatusInsufficientStorage)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			ts.deleted = append(ts.deleted, r.URL.Path)
+		}
+		if !strings.HasPrefix(r.URL.Path, uploads.Prefix) {
+			files.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodPut {
+			ts.chunks++
+		}
+		if r.Method == "MOVE" && path.Base(r.URL.Path) == ".file" {
+			assemble(t, w, r, filepath.Join(uploadsDir, path.Dir(strings.TrimPrefix(r.URL.Path, uploads.Prefix))), root, files.Prefix)
+			return
+		}
+		uploads.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	ts.url = server.URL + "/remote.php/dav/files/backup/"
+	return ts
+}
+
+// assemble соединяет части из папки dir в порядке имен и сохраняет результат в файл из заголовка Destination.
+func assemble(t *testing.T, w http.ResponseWriter, r *http.Request, dir, root, prefix string) {
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	chunks, err := os.ReadDir(dir)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var data []byte
+	for _, chunk := range chunks {
+		part, err := os.ReadFile(filepath.Join(dir, chunk.Name()))
+		if err != nil {
+			t.Errorf("Ошибка чтения части: %v", err)
+		}
+		data = append(data, part...)
+	}
+	if r.Header.Get("OC-Total-Length") != strconv.Itoa(len(data)) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(root, strings.TrimPrefix(destination.Path, prefix)), data, 0644); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	os.RemoveAll(dir)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestWebDAV(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "kronoskeeper"), 0755); err != nil {
+		t.Fatalf("Ошибка при создании папки: %v", err)
+	}
+	server := startServer(t, root)
+
+	storage, err := New(Config{URL: server.url + "kronoskeeper", Username: "backup", Password: "app-password"})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	storagetest.Run(t, storage)
+
+	// Потоковая загрузка передается частями без Content-Length
+	if server.chunked == 0 {
+		t.Errorf("Ожидалась загрузка с Transfer-Encoding: chunked")
+	}
+
+	data := bytes.Repeat([]byte("kronos"), 100000)
+	if err := storage.UploadStream(bytes.NewReader(data), "15-12:00-nginx.tar.gz", "host/nginx/2024-03"); err != nil {
+		t.Fatalf("Ошибка загрузки: %v", err)
+	}
+	saved, err := os.ReadFile(filepath.Join(root, "kronoskeeper/host/nginx/2024-03/15-12:00-nginx.tar.gz"))
+	if err != nil || !bytes.Equal(saved, data) {
+		t.Errorf("Архив не сохранен на сервере или поврежден: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "kronoskeeper/host/nginx/2024-03/15-12:00-nginx.tar.gz"+cloudStorages.PartSuffix)); err == nil {
+		t.Errorf("Временный файл должен был быть перемещен")
+	}
+
+	// Временный файл неудачной загрузки удаляется
+	server.deleted = nil
+	if err := storage.UploadStream(bytes.NewReader(data), "16-12:00-full.tar.gz", "host/nginx/2024-03"); err == nil {
+		t.Errorf("Ожидалась ошибка загрузки")
+	}
+	expectDeleted := []string{"/remote.php/dav/files/backup/kronoskeeper/host/nginx/2024-03/16-12:00-full.tar.gz" + cloudStorages.PartSuffix}
+	if !reflect.DeepEqual(server.deleted, expectDeleted) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", expectDeleted, server.deleted)
+	}
+}
+
+func TestWebDAVChunked(t *testing.T) {
+	root := t.TempDir()
+	server := startServer(t, root)
+
+	storage, err := New(Config{URL: server.url, Username: "backup", Password: "app-password", ChunkSizeMB: 1})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+
+	// Файл больше части загружается тремя частями
+	data := bytes.Repeat([]byte("kronos"), 450000)
+	localPath := filepath.Join(t.TempDir(), "15-12:00-nginx.tar.gz")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+	if err := storage.UploadFile(localPath, "host/nginx/2024-03"); err != nil {
+		t.Fatalf("Ошибка загрузки: %v", err)
+	}
+	if server.chunks != 3 {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", 3, server.chunks)
+	}
+	saved, err := os.ReadFile(filepath.Join(root, "host/nginx/2024-03/15-12:00-nginx.tar.gz"))
+	if err != nil || !bytes.Equal(saved, data) {
+		t.Errorf("Архив не сохранен на сервере или поврежден: %v", err)
+	}
+
+	// Поток неизвестного размера тоже загружается частями
+	if err := storage.UploadStream(bytes.NewReader(data[:1000]), "16-12:00-nginx.tar.gz", "host/nginx/2024-03"); err != nil {
+		t.Fatalf("Ошибка загрузки: %v", err)
+	}
+	if saved, err := os.ReadFile(filepath.Join(root, "host/nginx/2024-03/16-12:00-nginx.tar.gz")); err != nil || !bytes.Equal(saved, data[:1000]) {
+		t.Errorf("Архив не сохранен на сервере или поврежден: %v", err)
+	}
+	if server.chunks != 4 || server.chunked != 0 {
+		t.Errorf("Неверное количество частей: %v, запросов chunked: %v", server.chunks, server.chunked)
+	}
+
+	// При ошибке чтения после загрузки части папка загрузки удаляется вместе с ней
+	broken := io.MultiReader(bytes.NewReader(data[:1<<20]), iotest.ErrReader(errors.New("архив поврежден")))
+	if err := storage.UploadStream(broken, "17-12:00-nginx.tar.gz", "host/nginx/2024-03"); err == nil {
+		t.Errorf("Ожидалась ошибка загрузки")
+	}
+	if len(server.deleted) != 1 || !strings.HasPrefix(server.deleted[0], "/remote.php/dav/uploads/backup/") {
+		t.Errorf("Ожидалось удаление папки загрузки, удалено: %v", server.deleted)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "uploads")); len(entries) != 0 {
+		t.Errorf("Папки загрузки должны быть удалены: %v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(root, "host/nginx/2024-03/17-12:00-nginx.tar.gz")); !os.IsNotExist(err) {
+		t.Errorf("Архив не должен был быть сохранен: %v", err)
+	}
+
+	// Загрузка частями требует адрес Nextcloud
+	if _, err := New(Config{URL: "https://webdav.yandex.ru/kronoskeeper", ChunkSizeMB: 1}); err == nil {
+		t.Errorf("Ожидалась ошибка для адреса без /remote.php/dav/files/<пользователь>")
+	}
+}
+
+func TestWebDAVUnauthorized(t *testing.T) {
+	server := startServer(t, t.TempDir())
+
+	storage, err := New(Config{URL: server.url, Username: "backup", Password: "wrong"})
+	if err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", err)
+	}
+	if err := storage.NewClient(); err == nil {
+		t.Errorf("Ожидалась ошибка авторизации")
+	}
+
+	if _, err := New(Config{URL: "ftp://example.com"}); err == nil {
+		t.Errorf("Ожидалась ошибка для адреса без http или https")
+	}
+}
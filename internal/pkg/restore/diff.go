@@ -0,0 +1,265 @@
+package restore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/compress"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/encryption"
+)
+
+// Отличия измененного файла.
+const (
+	ReasonType    = "тип"
+	ReasonSize    = "размер"
+	ReasonModTime = "время изменения"
+	ReasonContent = "SHA-256"
+	ReasonLink    = "ссылка"
+)
+
+// Change описывает файл на диске, отличающийся от архива.
+type Change struct {
+	Path    string   // Путь элемента в архиве
+	Reasons []string // Отличия: ReasonType, ReasonSize, ReasonModTime, ReasonContent, ReasonLink
+}
+
+// Diff содержит различия между файлами на диске и архивом. Папки не сравниваются.
+type Diff struct {
+	Archive      string   // Архив: <YYYY-MM>/<архив>
+	From         string   // Хранилище, из которого взят архив, или Local
+	FromManifest bool     // Содержимое архива взято из манифеста
+	Added        []string // Файлы на диске, которых нет в архиве
+	Deleted      []string // Файлы архива, которых нет на диске
+	Modified     []Change // Файлы, отличающиеся от архива
+	Unchanged    int      // Количество файлов, совпадающих с архивом
+}
+
+// Diff сравнивает архив юнита, выбранный по opts, с текущим состоянием inputPaths юнита.
+// Элемент архива nginx/nginx.conf сравнивается с файлом nginx.conf входной директории .../nginx.
+// Учитываются opts.Paths и исключения compressExclude юнита.
+func (r *Restorer) Diff(opts Options) (*Diff, error) {
+	roots := make(map[string]string, len(r.unit.InputPaths))
+	for _, inputPath := range r.unit.InputPaths {
+		roots[filepath.Base(inputPath)] = inputPath
+	}
+	return r.diff(opts, func(entries []compress.Entry) map[string]string { return roots })
+}
+
+// Plan сравнивает архив юнита, выбранный по opts, с файлами в папке opts.Target, ничего не записывая.
+// Файлы из Deleted восстановление создаст, файлы из Modified перезапишет, а файлы из Added не изменит.
+func (r *Restorer) Plan(opts Options) (*Diff, error) {
+	if opts.Target == "" {
+		return nil, fmt.Errorf("не указана папка восстановления")
+	}
+	return r.diff(opts, func(entries []compress.Entry) map[string]string {
+		roots := map[string]string{}
+		for _, entry := range entries {
+			top, _, _ := strings.Cut(entry.Path, "/")
+			roots[top] = filepath.Join(opts.Target, top)
+		}
+		return roots
+	})
+}
+
+// diff сравнивает архив с файлами на диске. Функция roots возвращает папки на диске, соответствующие
+// папкам верхнего уровня архива.
+func (r *Restorer) diff(opts Options, roots func(entries []compress.Entry) map[string]string) (*Diff, error) {
+	src, err := r.locate(opts)
+	if err != nil {
+		return nil, err
+	}
+	report := newReport(src)
+	entries, err := r.entries(src, report)
+	if err != nil {
+		return nil, err
+	}
+	diff := &Diff{Archive: report.Archive, From: report.From, FromManifest: report.FromManifest}
+
+	// Файлы архива сравниваем с файлами на диске
+	dirs := roots(entries)
+	archived := map[string]bool{}
+	for _, entry := range entries {
+		if entry.Mode.IsDir() {
+			continue
+		}
+		matched, err := compress.MatchPath(opts.Paths, entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		archived[entry.Path] = true
+
+		top, rest, _ := strings.Cut(entry.Path, "/")
+		dir, ok := dirs[top]
+		if !ok {
+			diff.Deleted = append(diff.Deleted, entry.Path)
+			continue
+		}
+		reasons, err := compare(filepath.Join(dir, filepath.FromSlash(rest)), entry)
+		switch {
+		case os.IsNotExist(err):
+			diff.Deleted = append(diff.Deleted, entry.Path)
+		case err != nil:
+			return nil, err
+		case len(reasons) > 0:
+			diff.Modified = append(diff.Modified, Change{Path: entry.Path, Reasons: reasons})
+		default:
+			diff.Unchanged++
+		}
+	}
+
+	// Файлы на диске, которых нет в архиве
+	for top, dir := range dirs {
+		added, err := r.walk(top, dir, archived, opts.Paths)
+		if err != nil {
+			return nil, err
+		}
+		diff.Added = append(diff.Added, added...)
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Deleted)
+	sort.Slice(diff.Modified, func(i, j int) bool { return diff.Modified[i].Path < diff.Modified[j].Path })
+	return diff, nil
+}
+
+// entries возвращает элементы архива src с SHA-256 файлов: из манифеста, если он есть, иначе из самого архива.
+func (r *Restorer) entries(src *source, report *Report) ([]compress.Entry, error) {
+	if src.backup.Manifest != "" {
+		m, err := r.readManifest(src)
+		if err != nil {
+			return nil, err
+		}
+		report.FromManifest = true
+		return m.Files, nil
+	}
+
+	if src.storage == "" && !encryption.IsEncrypted(src.backup.Name) {
+		format, err := compress.FormatOf(src.backup.Name)
+		if err != nil {
+			return nil, err
+		}
+		return compress.ListSums(filepath.Join(src.dir, src.backup.YearMonth, src.backup.Name), format)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "kronoskeeper-restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать временную папку: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archive, format, err := r.prepare(src, tmpDir, report)
+	if err != nil {
+		return nil, err
+	}
+	return compress.ListSums(archive, format)
+}
+
+// walk возвращает пути в архиве файлов папки dir, которых нет среди archived. Папка dir соответствует
+// папке top архива. Исключенные из сжатия файлы и файлы, не подходящие под шаблоны paths, пропускаются.
+func (r *Restorer) walk(top, dir string, archived map[string]bool, paths []string) ([]string, error) {
+	var added []string
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && name == dir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		for _, pattern := range r.unit.CompressExclude {
+			if matched, _ := filepath.Match(pattern, d.Name()); matched {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		archivePath := path.Join(top, filepath.ToSlash(rel))
+		if archived[archivePath] {
+			return nil
+		}
+		if matched, _ := compress.MatchPath(paths, archivePath); matched {
+			added = append(added, archivePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения папки %v: %v", dir, err)
+	}
+	return added, nil
+}
+
+// compare сравнивает файл name на диске с элементом архива entry и возвращает отличия.
+// Время изменения сравнивается с точностью до секунды, если оно сохранено в архиве,
+// SHA-256 считается только для файлов одинакового размера.
+func compare(name string, entry compress.Entry) ([]string, error) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Type() != entry.Mode.Type() {
+		return []string{ReasonType}, nil
+	}
+	if entry.Mode&fs.ModeSymlink != 0 {
+		if link, err := os.Readlink(name); err != nil || link != entry.Link {
+			return []string{ReasonLink}, nil
+		}
+		return nil, nil
+	}
+	if !entry.Mode.IsRegular() {
+		return nil, nil
+	}
+
+	var reasons []string
+	if info.Size() != entry.Size {
+		reasons = append(reasons, ReasonSize)
+	}
+	if !entry.ModTime.IsZero() && entry.ModTime.Year() >= 1981 {
+		// Tar округляет время изменения до секунды, поэтому меньшая разница не считается отличием
+		if delta := entry.ModTime.Sub(info.ModTime()); delta >= time.Second || delta <= -time.Second {
+			reasons = append(reasons, ReasonModTime)
+		}
+	}
+	if info.Size() == entry.Size && entry.SHA256 != "" {
+		sum, err := fileSHA256(name)
+		if err != nil {
+			return nil, err
+		}
+		if sum != entry.SHA256 {
+			reasons = append(reasons, ReasonContent)
+		}
+	}
+	return reasons, nil
+}
+
+// fileSHA256 возвращает SHA-256 содержимого файла name.
+func fileSHA256(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("не удалось открыть файл: %v", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("не удалось прочитать файл %v: %v", name, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
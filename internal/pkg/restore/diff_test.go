@@ -0,0 +1,103 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/manifest"
+)
+
+func TestDiff(t *testing.T) {
+	unit, report := newUnit(t, "tar.gz", nil)
+	unit.CompressExclude = []string{"*.log"}
+	inputDir := unit.InputPaths[0]
+
+	// Файл того же размера с другим содержимым и прежним временем изменения
+	conf := filepath.Join(inputDir, "nginx.conf")
+	info, err := os.Stat(conf)
+	if err != nil {
+		t.Fatalf("Ошибка получения информации о файле: %v", err)
+	}
+	if err := os.WriteFile(conf, []byte("SERVER {}"), 0644); err != nil {
+		t.Fatalf("Ошибка при изменении файла: %v", err)
+	}
+	if err := os.Chtimes(conf, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("Ошибка при изменении времени файла: %v", err)
+	}
+	if err := os.Remove(filepath.Join(inputDir, "conf.d", "site.conf")); err != nil {
+		t.Fatalf("Ошибка удаления файла: %v", err)
+	}
+	for _, name := range []string{"new.conf", "error.log"} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte("new"), 0644); err != nil {
+			t.Fatalf("Не удалось создать тестовый файл %s: %v", name, err)
+		}
+	}
+
+	expect := &Diff{
+		Archive:      report.YearMoth + "/" + report.ArchiveName,
+		From:         Local,
+		FromManifest: true,
+		Added:        []string{"nginx/new.conf"},
+		Deleted:      []string{"nginx/conf.d/site.conf"},
+		Modified:     []Change{{Path: "nginx/nginx.conf", Reasons: []string{ReasonContent}}},
+	}
+	diff, err := New(unit, nil).Diff(Options{})
+	if err != nil {
+		t.Fatalf("Ошибка сравнения: %v", err)
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("Тест не пройден ожидалось: %+v, полученно: %+v", expect, diff)
+	}
+
+	// Без манифеста сравнивается содержимое самого архива
+	if err := os.Remove(filepath.Join(report.ArchivePath, manifest.Name(report.ArchiveName))); err != nil {
+		t.Fatalf("Ошибка удаления манифеста: %v", err)
+	}
+	expect.FromManifest = false
+	diff, err = New(unit, nil).Diff(Options{})
+	if err != nil {
+		t.Fatalf("Ошибка сравнения: %v", err)
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("Тест не пройден ожидалось: %+v, полученно: %+v", expect, diff)
+	}
+
+	// Сравниваются только файлы, подходящие под шаблоны
+	diff, err = New(unit, nil).Diff(Options{Paths: []string{"conf.d"}})
+	if err != nil || len(diff.Added) != 0 || len(diff.Modified) != 0 || len(diff.Deleted) != 1 {
+		t.Errorf("Неверный результат сравнения: %+v, %v", diff, err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	unit, _ := newUnit(t, "tar.gz", nil)
+	target := t.TempDir()
+
+	// Пробный запуск ничего не записывает
+	plan, err := New(unit, nil).Plan(Options{Target: target})
+	if err != nil {
+		t.Fatalf("Ошибка сравнения: %v", err)
+	}
+	if len(plan.Deleted) != 2 || plan.Unchanged != 0 {
+		t.Errorf("Все файлы архива должны быть созданы: %+v", plan)
+	}
+	if entries, _ := os.ReadDir(target); len(entries) != 0 {
+		t.Errorf("Пробный запуск не должен записывать файлы: %v", entries)
+	}
+
+	if _, err := New(unit, nil).Restore(Options{Target: target}); err != nil {
+		t.Fatalf("Ошибка восстановления: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "nginx", "nginx.conf"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("Ошибка при изменении файла: %v", err)
+	}
+	plan, err = New(unit, nil).Plan(Options{Target: target})
+	if err != nil {
+		t.Fatalf("Ошибка сравнения: %v", err)
+	}
+	if plan.Unchanged != 1 || len(plan.Modified) != 1 || plan.Modified[0].Path != "nginx/nginx.conf" || len(plan.Deleted) != 0 {
+		t.Errorf("Неверный план восстановления: %+v", plan)
+	}
+}
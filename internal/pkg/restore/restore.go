@@ -0,0 +1,430 @@
+// Пакет restore реализует восстановление резервной копии юнита: поиск архива на локальном диске или
+// в удаленном хранилище, скачивание, проверку контрольной суммы по манифесту, расшифровку и распаковку.
+//
+// Отдельные файлы незашифрованного ZIP-архива восстанавливаются из хранилищ, умеющих читать часть файла,
+// без скачивания архива целиком: читаются только центральный каталог и выбранные файлы.
+package restore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/compress"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/encryption"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/manifest"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/retention"
+)
+
+// Local - имя источника в отчете, если архив найден на локальном диске.
+const Local = "локальный диск"
+
+// timeLayouts - форматы времени, которые принимает ParseTime.
+var timeLayouts = []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+// Options содержит параметры восстановления.
+type Options struct {
+	From   string    // Хранилище, из которого восстанавливать, пустое - локальный диск, затем хранилища юнита
+	At     time.Time // Восстановить последний архив, созданный не позже At, нулевое время - последний архив
+	Backup string    // Архив <YYYY-MM>/<архив> или имя архива, пустое - выбирается по At
+	Target string    // Папка, в которую распаковывается архив
+	Force  bool      // Перезаписывать существующие файлы
+	Paths  []string  // Шаблоны путей элементов архива, пустой список - весь архив, см. compress.MatchPath
+}
+
+// Report содержит отчет о восстановлении или о содержимом архива.
+type Report struct {
+	Archive      string           // Восстановленный архив: <YYYY-MM>/<архив>
+	From         string           // Хранилище, из которого взят архив, или Local
+	Verified     bool             // SHA-256 архива или восстановленных файлов сверен с манифестом
+	Ranged       bool             // Архив читался из хранилища по частям, без скачивания целиком
+	Fetched      int64            // Байт архива, полученных из хранилища при чтении по частям
+	FromManifest bool             // Список элементов взят из манифеста, а не из архива
+	Entries      []compress.Entry // Распакованные элементы архива
+}
+
+// Restorer восстанавливает резервные копии юнита.
+type Restorer struct {
+	unit    config.BackupUnit
+	remotes *remotestorages.Remotestorages
+}
+
+// source описывает найденный архив.
+type source struct {
+	storage string           // хранилище, пустое - локальный диск
+	dir     string           // папка юнита на локальном диске или в хранилище
+	backup  retention.Backup // архив
+}
+
+// New создает Restorer для юнита unit. Хранилища берутся из remotes, клиенты создаются при первом обращении.
+func New(unit config.BackupUnit, remotes *remotestorages.Remotestorages) *Restorer {
+	return &Restorer{unit: unit, remotes: remotes}
+}
+
+// ParseTime разбирает время архива для восстановления в форматах "2006-01-02 15:04", "2006-01-02T15:04"
+// или "2006-01-02". Для даты без времени используется конец дня.
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		if len(value) == len("2006-01-02") {
+			t = t.Add(24*time.Hour - time.Minute)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("некорректное время %v, ожидается ГГГГ-ММ-ДД ЧЧ:ММ или ГГГГ-ММ-ДД", value)
+}
+
+// Restore находит архив юнита по opts, скачивает его, сверяет с манифестом, расшифровывает и распаковывает в opts.Target.
+// Если заданы opts.Paths, распаковываются только подходящие элементы, а незашифрованный ZIP-архив
+// по возможности читается из хранилища по частям.
+func (r *Restorer) Restore(opts Options) (*Report, error) {
+	if opts.Target == "" {
+		return nil, fmt.Errorf("не указана папка восстановления")
+	}
+	src, err := r.locate(opts)
+	if err != nil {
+		return nil, err
+	}
+	report := newReport(src)
+	extractOpts := compress.ExtractOptions{Force: opts.Force, Paths: opts.Paths}
+
+	if len(opts.Paths) > 0 {
+		rangeReader, err := r.openRange(src)
+		if err != nil {
+			return nil, err
+		}
+		if rangeReader != nil {
+			report.Ranged = true
+			report.Entries, err = compress.ExtractZip(rangeReader, rangeReader.Size(), opts.Target, extractOpts)
+			report.Fetched = rangeReader.Fetched()
+			if err != nil {
+				return report, fmt.Errorf("ошибка распаковки архива %v: %v", report.Archive, err)
+			}
+			report.Verified, err = r.verifyEntries(src, report.Entries)
+			return report, err
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "kronoskeeper-restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать временную папку: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archive, format, err := r.prepare(src, tmpDir, report)
+	if err != nil {
+		return nil, err
+	}
+	report.Entries, err = compress.Extract(archive, format, opts.Target, extractOpts)
+	if err != nil {
+		return report, fmt.Errorf("ошибка распаковки архива %v: %v", report.Archive, err)
+	}
+	return report, nil
+}
+
+// List находит архив юнита по opts и возвращает его элементы, подходящие под opts.Paths. Архив не скачивается
+// целиком, если это возможно: у локального архива читается только список элементов, у ZIP-архива в хранилище
+// с чтением по частям - центральный каталог, для остальных архивов в хранилищах используется манифест.
+func (r *Restorer) List(opts Options) (*Report, error) {
+	src, err := r.locate(opts)
+	if err != nil {
+		return nil, err
+	}
+	report := newReport(src)
+	rangeReader, err := r.openRange(src)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case src.storage == "" && !encryption.IsEncrypted(src.backup.Name):
+		format, err := compress.FormatOf(src.backup.Name)
+		if err != nil {
+			return nil, err
+		}
+		report.Entries, err = compress.List(filepath.Join(src.dir, src.backup.YearMonth, src.backup.Name), format)
+		if err != nil {
+			return nil, err
+		}
+	case rangeReader != nil:
+		report.Ranged = true
+		report.Entries, err = compress.ListZip(rangeReader, rangeReader.Size())
+		report.Fetched = rangeReader.Fetched()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения архива %v: %v", report.Archive, err)
+		}
+	case src.backup.Manifest != "":
+		m, err := r.readManifest(src)
+		if err != nil {
+			return nil, err
+		}
+		report.FromManifest = true
+		report.Entries = m.Files
+	default:
+		tmpDir, err := os.MkdirTemp("", "kronoskeeper-restore-*")
+		if err != nil {
+			return nil, fmt.Errorf("не удалось создать временную папку: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		archive, format, err := r.prepare(src, tmpDir, report)
+		if err != nil {
+			return nil, err
+		}
+		report.Entries, err = compress.List(archive, format)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []compress.Entry
+	for _, entry := range report.Entries {
+		matched, err := compress.MatchPath(opts.Paths, entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			entries = append(entries, entry)
+		}
+	}
+	report.Entries = entries
+	return report, nil
+}
+
+// newReport создает отчет для архива src.
+func newReport(src *source) *Report {
+	report := &Report{Archive: path.Join(src.backup.YearMonth, src.backup.Name), From: src.storage}
+	if src.storage == "" {
+		report.From = Local
+	}
+	return report
+}
+
+// prepare скачивает архив src в tmpDir, сверяет его с манифестом и расшифровывает.
+// Возвращает путь к готовому для распаковки архиву и формат его сжатия.
+func (r *Restorer) prepare(src *source, tmpDir string, report *Report) (string, string, error) {
+	archive, err := r.fetch(src, tmpDir)
+	if err != nil {
+		return "", "", err
+	}
+	report.Verified, err = r.verify(src, archive)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Зашифрованный архив расшифровываем во временную папку
+	name := src.backup.Name
+	if encryption.IsEncrypted(name) {
+		if r.unit.Encryption == nil {
+			return "", "", fmt.Errorf("архив %v зашифрован, а для юнита %v не настроено шифрование", name, r.unit.Name)
+		}
+		encryptor, err := encryption.New(r.unit.Encryption)
+		if err != nil {
+			return "", "", err
+		}
+		name = encryption.TrimExtension(name)
+		decrypted := filepath.Join(tmpDir, "decrypted-"+name)
+		if err := encryptor.DecryptFile(archive, decrypted); err != nil {
+			return "", "", err
+		}
+		archive = decrypted
+	}
+
+	format, err := compress.FormatOf(name)
+	if err != nil {
+		return "", "", err
+	}
+	return archive, format, nil
+}
+
+// openRange открывает для чтения по частям незашифрованный ZIP-архив src в хранилище. Возвращает nil,
+// если архив локальный, зашифрован, не является ZIP-архивом или хранилище не умеет читать часть файла.
+func (r *Restorer) openRange(src *source) (*remotestorages.RangeReader, error) {
+	if src.storage == "" || encryption.IsEncrypted(src.backup.Name) {
+		return nil, nil
+	}
+	if format, err := compress.FormatOf(src.backup.Name); err != nil || format != "zip" {
+		return nil, nil
+	}
+	rangeReader, err := r.remotes.OpenRange(src.storage, path.Join(src.dir, src.backup.YearMonth, src.backup.Name))
+	if errors.Is(err, remotestorages.ErrRangeUnsupported) {
+		return nil, nil
+	}
+	return rangeReader, err
+}
+
+// locate находит архив для восстановления: в хранилище opts.From или, если оно не указано,
+// сначала на локальном диске, затем в хранилищах юнита по порядку.
+func (r *Restorer) locate(opts Options) (*source, error) {
+	if opts.From == "" && r.unit.OutputPath != "" {
+		dir := filepath.Join(r.unit.OutputPath, r.unit.Name)
+		months, err := retention.ListLocal(dir)
+		if err != nil {
+			return nil, err
+		}
+		if backup, ok := pick(months, opts); ok {
+			return &source{dir: dir, backup: backup}, nil
+		}
+	}
+
+	remotes := r.unit.UploadTo
+	if opts.From != "" {
+		remotes = []string{opts.From}
+	}
+	var errs []string
+	for _, name := range remotes {
+		dir := path.Join(r.unit.RemotePath, r.unit.Name)
+		months, err := r.listRemote(name, dir)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("хранилище %v: %v", name, err))
+			continue
+		}
+		if backup, ok := pick(months, opts); ok {
+			return &source{storage: name, dir: dir, backup: backup}, nil
+		}
+	}
+
+	msg := fmt.Sprintf("не найден архив юнита %v", r.unit.Name)
+	if opts.Backup != "" {
+		msg = fmt.Sprintf("не найден архив %v юнита %v", opts.Backup, r.unit.Name)
+	} else if !opts.At.IsZero() {
+		msg += fmt.Sprintf(", созданный не позже %v", opts.At.Format("2006-01-02 15:04"))
+	}
+	if len(errs) > 0 {
+		msg += ": " + strings.Join(errs, "; ")
+	}
+	return nil, errors.New(msg)
+}
+
+// listRemote возвращает содержимое папок год-месяц юнита в хранилище name.
+func (r *Restorer) listRemote(name, dir string) ([]retention.Month, error) {
+	if r.remotes == nil {
+		return nil, fmt.Errorf("удаленные хранилища не настроены")
+	}
+	storage, err := r.remotes.Storage(name)
+	if err != nil {
+		return nil, err
+	}
+	return retention.ListRemote(storage, dir)
+}
+
+// pick возвращает архив opts.Backup или самый новый архив, созданный не позже opts.At.
+// Нулевое opts.At означает самый новый архив.
+func pick(months []retention.Month, opts Options) (retention.Backup, bool) {
+	var found retention.Backup
+	ok := false
+	for _, month := range months {
+		for _, backup := range month.Backups {
+			if opts.Backup != "" && opts.Backup != backup.Name && opts.Backup != path.Join(backup.YearMonth, backup.Name) {
+				continue
+			}
+			if !opts.At.IsZero() && backup.Time.After(opts.At) {
+				continue
+			}
+			if !ok || backup.Time.After(found.Time) {
+				found, ok = backup, true
+			}
+		}
+	}
+	return found, ok
+}
+
+// fetch возвращает путь к архиву на локальном диске, скачивая его из хранилища в tmpDir.
+func (r *Restorer) fetch(src *source, tmpDir string) (string, error) {
+	if src.storage == "" {
+		return filepath.Join(src.dir, src.backup.YearMonth, src.backup.Name), nil
+	}
+	storage, err := r.remotes.Storage(src.storage)
+	if err != nil {
+		return "", err
+	}
+	archive := filepath.Join(tmpDir, src.backup.Name)
+	if err := storage.DownloadFile(src.backup.ID, archive); err != nil {
+		return "", fmt.Errorf("не удалось скачать архив %v из хранилища %v: %v", src.backup.Name, src.storage, err)
+	}
+	return archive, nil
+}
+
+// verify сверяет SHA-256 архива с манифестом, если манифест есть. Возвращает true, если архив был проверен.
+func (r *Restorer) verify(src *source, archive string) (bool, error) {
+	if src.backup.Manifest == "" {
+		return false, nil
+	}
+	m, err := r.readManifest(src)
+	if err != nil {
+		return false, err
+	}
+	if m.SHA256 == "" {
+		return false, nil
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return false, fmt.Errorf("не удалось открыть архив: %v", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return false, fmt.Errorf("не удалось прочитать архив: %v", err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != m.SHA256 {
+		return false, fmt.Errorf("архив %v поврежден: SHA-256 %v, в манифесте %v", src.backup.Name, sum, m.SHA256)
+	}
+	return true, nil
+}
+
+// verifyEntries сверяет SHA-256 распакованных файлов с манифестом, если манифест есть.
+// Возвращает true, если все файлы были проверены.
+func (r *Restorer) verifyEntries(src *source, entries []compress.Entry) (bool, error) {
+	if src.backup.Manifest == "" {
+		return false, nil
+	}
+	m, err := r.readManifest(src)
+	if err != nil {
+		return false, err
+	}
+	sums := make(map[string]string, len(m.Files))
+	for _, file := range m.Files {
+		sums[file.Path] = file.SHA256
+	}
+	verified := true
+	for _, entry := range entries {
+		if !entry.Mode.IsRegular() {
+			continue
+		}
+		sum := sums[entry.Path]
+		if sum == "" {
+			verified = false
+			continue
+		}
+		if sum != entry.SHA256 {
+			return false, fmt.Errorf("файл %v архива %v поврежден: SHA-256 %v, в манифесте %v", entry.Path, src.backup.Name, entry.SHA256, sum)
+		}
+	}
+	return verified, nil
+}
+
+// readManifest читает манифест архива src.
+func (r *Restorer) readManifest(src *source) (*manifest.Manifest, error) {
+	if src.storage == "" {
+		return manifest.ReadFile(filepath.Join(src.dir, src.backup.YearMonth, src.backup.Manifest))
+	}
+	reader, err := r.remotes.Open(src.storage, path.Join(src.dir, src.backup.YearMonth, src.backup.Manifest))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return manifest.Read(reader)
+}
@@ -0,0 +1,200 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/compress"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/encryption"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/manifest"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/local"
+)
+
+// usbDir - папка тестового хранилища usb.
+var usbDir string
+
+func init() {
+	cloudStorages.Register("restore-usb", func(*config.Storage) (cloudStorages.Storage, error) {
+		return local.New(local.Config{Path: usbDir})
+	})
+}
+
+// newUnit создает входные файлы и архив юнита с манифестом, как это делает сервис резервного копирования.
+func newUnit(t *testing.T, format string, encrypt *config.Encryption) (config.BackupUnit, *compress.CompressReport) {
+	t.Helper()
+	inputDir := filepath.Join(t.TempDir(), "nginx")
+	if err := os.MkdirAll(filepath.Join(inputDir, "conf.d"), 0755); err != nil {
+		t.Fatalf("Ошибка при создании входной директории: %v", err)
+	}
+	for name, content := range map[string]string{"nginx.conf": "server {}", "conf.d/site.conf": "location / {}"} {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Не удалось создать тестовый файл %s: %v", name, err)
+		}
+	}
+
+	unit := config.BackupUnit{
+		Name:           "nginx",
+		InputPaths:     []string{inputDir},
+		OutputPath:     t.TempDir(),
+		CompressFormat: format,
+		UploadTo:       []string{"usb"},
+		RemotePath:     "host",
+		Encryption:     encrypt,
+	}
+	c := &compress.Compress{ArchiveName: unit.Name, InputPaths: unit.InputPaths, OutputPath: unit.OutputPath}
+	if encrypt != nil {
+		encryptor, err := encryption.New(encrypt)
+		if err != nil {
+			t.Fatalf("Ошибка настроек шифрования: %v", err)
+		}
+		c.Encryptor = encryptor
+	}
+	report, err := c.Start(format)
+	if err != nil {
+		t.Fatalf("Ошибка создания архива: %v", err)
+	}
+	if _, err := manifest.New(unit, report).WriteFile(report.ArchivePath); err != nil {
+		t.Fatalf("Ошибка записи манифеста: %v", err)
+	}
+	return unit, report
+}
+
+// upload загружает архив юнита и его манифест в хранилище usb и удаляет локальную копию.
+func upload(t *testing.T, unit config.BackupUnit, report *compress.CompressReport) *remotestorages.Remotestorages {
+	t.Helper()
+	usbDir = t.TempDir()
+	storages := config.RemoteStorages{"usb": config.NewStorage("usb", "restore-usb")}
+	remotes, _ := remotestorages.New(&remotestorages.UploadConfig{}, storages)
+	storage, err := remotes.Storage("usb")
+	if err != nil {
+		t.Fatalf("Ошибка подключения к хранилищу: %v", err)
+	}
+	remoteDir := "host/nginx/" + report.YearMoth
+	for _, name := range []string{report.ArchiveName, manifest.Name(report.ArchiveName)} {
+		if err := storage.UploadFile(filepath.Join(report.ArchivePath, name), remoteDir); err != nil {
+			t.Fatalf("Ошибка загрузки %v: %v", name, err)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(unit.OutputPath, unit.Name)); err != nil {
+		t.Fatalf("Ошибка удаления локальной копии: %v", err)
+	}
+	return remotes
+}
+
+func TestRestoreLocal(t *testing.T) {
+	unit, report := newUnit(t, "tar.gz", nil)
+	target := t.TempDir()
+
+	restored, err := New(unit, nil).Restore(Options{Target: target})
+	if err != nil {
+		t.Fatalf("Ошибка восстановления: %v", err)
+	}
+	if restored.From != Local || !restored.Verified || restored.Archive != report.YearMoth+"/"+report.ArchiveName {
+		t.Errorf("Неверный отчет о восстановлении: %+v", restored)
+	}
+	if data, err := os.ReadFile(filepath.Join(target, "nginx", "conf.d", "site.conf")); err != nil || string(data) != "location / {}" {
+		t.Errorf("Неверное содержимое восстановленного файла: %q, %v", data, err)
+	}
+
+	// Существующие файлы перезаписываются только с Force
+	if _, err := New(unit, nil).Restore(Options{Target: target}); err == nil {
+		t.Errorf("Ожидалась ошибка перезаписи существующих файлов")
+	}
+	if _, err := New(unit, nil).Restore(Options{Target: target, Force: true}); err != nil {
+		t.Errorf("Ошибка восстановления с перезаписью: %v", err)
+	}
+
+	// Архивов старше указанного времени нет
+	if _, err := New(unit, nil).Restore(Options{Target: t.TempDir(), At: time.Now().Add(-time.Hour)}); err == nil {
+		t.Errorf("Ожидалась ошибка: архив, созданный час назад, не существует")
+	}
+}
+
+func TestRestoreRemote(t *testing.T) {
+	unit, report := newUnit(t, "zip", &config.Encryption{Passphrase: "secret"})
+	remotes := upload(t, unit, report)
+
+	target := t.TempDir()
+	restored, err := New(unit, remotes).Restore(Options{Target: target})
+	if err != nil {
+		t.Fatalf("Ошибка восстановления: %v", err)
+	}
+	if restored.From != "usb" || !restored.Verified {
+		t.Errorf("Неверный отчет о восстановлении: %+v", restored)
+	}
+	if data, err := os.ReadFile(filepath.Join(target, "nginx", "nginx.conf")); err != nil || string(data) != "server {}" {
+		t.Errorf("Неверное содержимое восстановленного файла: %q, %v", data, err)
+	}
+
+	// Список элементов зашифрованного архива берется из манифеста
+	listed, err := New(unit, remotes).List(Options{Paths: []string{"nginx.conf"}})
+	if err != nil || !listed.FromManifest || len(listed.Entries) != 1 {
+		t.Errorf("Неверный список элементов архива: %+v, %v", listed, err)
+	}
+
+	// Поврежденный архив не распаковывается
+	remoteArchive := filepath.Join(usbDir, "host", "nginx", report.YearMoth, report.ArchiveName)
+	if err := os.WriteFile(remoteArchive, []byte("damaged"), 0644); err != nil {
+		t.Fatalf("Ошибка при изменении архива: %v", err)
+	}
+	_, err = New(unit, remotes).Restore(Options{From: "usb", Target: t.TempDir()})
+	if err == nil || !strings.Contains(err.Error(), "поврежден") {
+		t.Errorf("Ожидалась ошибка поврежденного архива, полученно: %v", err)
+	}
+}
+
+func TestRestorePaths(t *testing.T) {
+	unit, report := newUnit(t, "zip", nil)
+	remotes := upload(t, unit, report)
+	backup := report.YearMoth + "/" + report.ArchiveName
+
+	// ZIP-архив читается из хранилища по частям, восстанавливается только выбранный файл
+	target := t.TempDir()
+	restored, err := New(unit, remotes).Restore(Options{Backup: backup, Target: target, Paths: []string{"nginx.conf"}})
+	if err != nil {
+		t.Fatalf("Ошибка восстановления: %v", err)
+	}
+	if !restored.Ranged || !restored.Verified || len(restored.Entries) != 1 {
+		t.Errorf("Неверный отчет о восстановлении: %+v", restored)
+	}
+	if data, err := os.ReadFile(filepath.Join(target, "nginx", "nginx.conf")); err != nil || string(data) != "server {}" {
+		t.Errorf("Неверное содержимое восстановленного файла: %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "nginx", "conf.d")); !os.IsNotExist(err) {
+		t.Errorf("Папка conf.d не должна была быть восстановлена")
+	}
+
+	listed, err := New(unit, remotes).List(Options{Backup: report.ArchiveName, Paths: []string{"*.conf"}})
+	if err != nil {
+		t.Fatalf("Ошибка получения списка элементов архива: %v", err)
+	}
+	if !listed.Ranged || len(listed.Entries) != 2 {
+		t.Errorf("Неверный список элементов архива: %+v", listed)
+	}
+	if _, err := New(unit, remotes).List(Options{Backup: "2000-01/" + report.ArchiveName}); err == nil {
+		t.Errorf("Ожидалась ошибка: архив не существует")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	testCases := map[string]time.Time{
+		"2024-03-15 13:37": time.Date(2024, 3, 15, 13, 37, 0, 0, time.Local),
+		"2024-03-15T13:37": time.Date(2024, 3, 15, 13, 37, 0, 0, time.Local),
+		"2024-03-15":       time.Date(2024, 3, 15, 23, 59, 0, 0, time.Local),
+	}
+	for value, expect := range testCases {
+		got, err := ParseTime(value)
+		if err != nil || !got.Equal(expect) {
+			t.Errorf("Тест не пройден ожидалось: %v, полученно: %v, %v", expect, got, err)
+		}
+	}
+	if _, err := ParseTime("15.03.2024"); err == nil {
+		t.Errorf("Ожидалась ошибка для некорректного времени")
+	}
+}
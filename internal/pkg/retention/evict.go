@@ -0,0 +1,106 @@
+package retention
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/diskusage"
+)
+
+// Evict удаляет самые старые архивы вместе с их манифестами из папки юнита unitDir, чтобы после создания нового архива
+// папка занимала не больше limit байт. Размер нового архива оценивается по размеру последнего архива.
+// Последний архив не удаляется никогда. Если даже после удаления всех остальных архивов ограничение
+// будет превышено, ничего не удаляется и в отчете возвращается ошибка.
+func Evict(unitDir string, limit int64) *Report {
+	report := &Report{}
+
+	months, err := ListLocal(unitDir)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+
+	type archive struct {
+		Backup
+		size int64
+	}
+	var archives []archive
+	for _, month := range months {
+		for _, backup := range month.Backups {
+			info, err := os.Stat(filepath.Join(unitDir, backup.YearMonth, backup.Name))
+			if err != nil {
+				report.Err = fmt.Errorf("не удалось получить размер архива %v: %v", backup.Name, err)
+				return report
+			}
+			size := info.Size()
+			if backup.Manifest != "" {
+				if info, err := os.Stat(filepath.Join(unitDir, backup.YearMonth, backup.Manifest)); err == nil {
+					size += info.Size()
+				}
+			}
+			archives = append(archives, archive{Backup: backup, size: size})
+		}
+	}
+	used, err := diskusage.DirSize(unitDir)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	if len(archives) == 0 {
+		if used > limit {
+			report.Err = fmt.Errorf("папка юнита %v занимает %v байт при ограничении %v байт, а архивов для удаления нет", unitDir, used, limit)
+		}
+		return report
+	}
+
+	// Сортируем архивы от старых к новым
+	sort.SliceStable(archives, func(i, j int) bool { return archives[i].Time.Before(archives[j].Time) })
+	latest := archives[len(archives)-1]
+	projected := used + latest.size
+	if projected <= limit {
+		return report
+	}
+
+	// Планируем удаление, не трогая последний архив
+	n := 0
+	for n < len(archives)-1 && projected > limit {
+		projected -= archives[n].size
+		n++
+	}
+	if projected > limit {
+		report.Err = fmt.Errorf("новый архив (около %v байт) не поместится в ограничение %v байт даже после удаления всех архивов, кроме последнего: папка юнита займет %v байт",
+			latest.size, limit, projected)
+		return report
+	}
+
+	deleted := map[string]int{}
+	for _, archive := range archives[:n] {
+		path := filepath.Join(unitDir, archive.YearMonth, archive.Name)
+		if err := os.Remove(path); err != nil {
+			report.Err = fmt.Errorf("не удалось удалить архив %v: %v", path, err)
+			return report
+		}
+		if err := removeManifest(unitDir, archive.Backup); err != nil {
+			report.Err = err
+			return report
+		}
+		report.Deleted = append(report.Deleted, path)
+		deleted[archive.YearMonth]++
+	}
+
+	// Удаляем папки год-месяц, в которых не осталось файлов
+	for _, month := range months {
+		if deleted[month.Name] == 0 || deleted[month.Name] != len(month.Backups) || month.Others != 0 {
+			continue
+		}
+		if err := removeMonth(unitDir, month); err != nil {
+			report.Err = err
+			return report
+		}
+		report.Deleted = append(report.Deleted, filepath.Join(unitDir, month.Name))
+	}
+
+	return report
+}
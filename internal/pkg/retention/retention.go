@@ -0,0 +1,398 @@
+// Пакет retention реализует очистку старых резервных копий согласно сроку хранения юнита.
+//
+// Время создания архива определяется по имени папки год-месяц и префиксу имени архива,
+// которые формирует пакет compress: <output>/<unit>/<YYYY-MM>/<DD-HH:MM>-<unit>.<формат>.
+// Одни и те же правила применяются к локальной папке юнита и к удаленным хранилищам.
+//
+// Политика хранения поддерживает схему дед-отец-сын: архив сохраняется, если он моложе Days дней,
+// входит в KeepLast последних архивов или является последним архивом своего дня, недели, месяца
+// или года среди KeepDaily, KeepWeekly, KeepMonthly и KeepYearly последних периодов.
+//
+// Манифест архива (<архив>.manifest.json) удаляется вместе с архивом и не считается отдельной резервной копией.
+package retention
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/compress"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/manifest"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+)
+
+// Policy описывает политику хранения резервных копий.
+type Policy struct {
+	config.Retention      // Правила хранения из настроек юнита
+	DryRun           bool // Только сообщить, какие архивы были бы удалены, ничего не удаляя
+}
+
+// Backup описывает архив резервной копии, участвующий в очистке.
+type Backup struct {
+	YearMonth  string    // Папка год-месяц, в которой лежит архив
+	Name       string    // Имя архива
+	Time       time.Time // Время создания архива
+	ID         string    // Идентификатор файла в удаленном хранилище
+	Manifest   string    // Имя файла манифеста архива, пустое если манифеста нет
+	ManifestID string    // Идентификатор файла манифеста в удаленном хранилище
+}
+
+// Month описывает содержимое папки год-месяц.
+type Month struct {
+	Name    string   // Имя папки в формате YYYY-MM
+	ID      string   // Идентификатор папки в удаленном хранилище
+	Backups []Backup // Архивы в папке
+	Others  int      // Количество других файлов и папок, которые не являются архивами
+
+	manifests map[string]string // идентификаторы манифестов по имени архива до привязки к архивам
+	temps     []string          // оставшиеся после сбоя временные файлы параллельного сжатия
+}
+
+// Report содержит отчет об очистке резервных копий.
+type Report struct {
+	Deleted []string // Удаленные архивы и папки
+	DryRun  bool     // Очистка выполнялась в режиме проверки, Deleted содержит то, что было бы удалено
+	Err     error    // Ошибка очистки
+}
+
+// Remote описывает удаленное хранилище, в котором можно выполнить очистку.
+type Remote interface {
+	cloudStorages.Lister
+	cloudStorages.Deleter
+}
+
+// IsEmpty возвращает true, если политика не ограничивает хранение резервных копий.
+func (p Policy) IsEmpty() bool {
+	return p.Days <= 0 && p.KeepLast <= 0 && p.KeepDaily <= 0 && p.KeepWeekly <= 0 &&
+		p.KeepMonthly <= 0 && p.KeepYearly <= 0
+}
+
+// Expired возвращает архивы из backups, которые нужно удалить согласно политике на момент now.
+// Архивы возвращаются в том же порядке, в котором переданы.
+func (p Policy) Expired(backups []Backup, now time.Time) []Backup {
+	if p.IsEmpty() {
+		return nil
+	}
+
+	// Сортируем индексы архивов от новых к старым
+	order := make([]int, len(backups))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return backups[order[i]].Time.After(backups[order[j]].Time)
+	})
+
+	keep := make([]bool, len(backups))
+
+	// Последние KeepLast архивов
+	for i := 0; i < p.KeepLast && i < len(order); i++ {
+		keep[order[i]] = true
+	}
+
+	// Архивы моложе Days дней
+	if p.Days > 0 {
+		deadline := now.AddDate(0, 0, -p.Days)
+		for _, i := range order {
+			if !backups[i].Time.Before(deadline) {
+				keep[i] = true
+			}
+		}
+	}
+
+	// Последний архив в каждом из последних периодов
+	buckets := []struct {
+		count  int
+		period func(time.Time) string
+	}{
+		{p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.KeepWeekly, func(t time.Time) string { year, week := t.ISOWeek(); return fmt.Sprintf("%d-%02d", year, week) }},
+		{p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{p.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, bucket := range buckets {
+		left := bucket.count
+		last := ""
+		for _, i := range order {
+			if left <= 0 {
+				break
+			}
+			period := bucket.period(backups[i].Time)
+			if period == last {
+				continue
+			}
+			keep[i] = true
+			last = period
+			left--
+		}
+	}
+
+	var expired []Backup
+	for i, backup := range backups {
+		if !keep[i] {
+			expired = append(expired, backup)
+		}
+	}
+	return expired
+}
+
+// plan определяет архивы, которые нужно удалить, и папки год-месяц, которые после этого станут пустыми.
+// Архив current не удаляется никогда. Имена архивов повторяются каждый месяц, поэтому архив
+// определяется папкой год-месяц и именем.
+func (p Policy) plan(months []Month, current Backup, now time.Time) ([]Backup, []Month) {
+	var all []Backup
+	for _, month := range months {
+		all = append(all, month.Backups...)
+	}
+
+	deleted := map[string]int{}
+	var expired []Backup
+	for _, backup := range p.Expired(all, now) {
+		if backup.YearMonth == current.YearMonth && backup.Name == current.Name {
+			continue
+		}
+		expired = append(expired, backup)
+		deleted[backup.YearMonth]++
+	}
+
+	var empty []Month
+	for _, month := range months {
+		if deleted[month.Name] > 0 && deleted[month.Name] == len(month.Backups) && month.Others == 0 {
+			empty = append(empty, month)
+		}
+	}
+	return expired, empty
+}
+
+// PruneLocal удаляет из папки юнита unitDir архивы, срок хранения которых истек, и ставшие пустыми папки год-месяц.
+// Архив current (папка год-месяц и имя только что созданного архива) не удаляется никогда.
+func PruneLocal(unitDir string, policy Policy, current Backup, now time.Time) *Report {
+	report := &Report{DryRun: policy.DryRun}
+	if policy.IsEmpty() {
+		return report
+	}
+
+	months, err := ListLocal(unitDir)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+
+	expired, empty := policy.plan(months, current, now)
+	for _, backup := range expired {
+		path := filepath.Join(unitDir, backup.YearMonth, backup.Name)
+		if !policy.DryRun {
+			if err := os.Remove(path); err != nil {
+				report.Err = fmt.Errorf("не удалось удалить архив %v: %v", path, err)
+				return report
+			}
+			if err := removeManifest(unitDir, backup); err != nil {
+				report.Err = err
+				return report
+			}
+		}
+		report.Deleted = append(report.Deleted, path)
+	}
+
+	// Удаляем папки год-месяц, в которых не осталось файлов
+	for _, month := range empty {
+		dir := filepath.Join(unitDir, month.Name)
+		if !policy.DryRun {
+			if err := removeMonth(unitDir, month); err != nil {
+				report.Err = err
+				return report
+			}
+		}
+		report.Deleted = append(report.Deleted, dir)
+	}
+
+	return report
+}
+
+// PruneRemote удаляет из папки юнита unitPath в удаленном хранилище архивы, срок хранения которых истек,
+// и ставшие пустыми папки год-месяц. Архив current не удаляется никогда.
+func PruneRemote(remote Remote, unitPath string, policy Policy, current Backup, now time.Time) *Report {
+	report := &Report{DryRun: policy.DryRun}
+	if policy.IsEmpty() {
+		return report
+	}
+
+	months, err := ListRemote(remote, unitPath)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+
+	expired, empty := policy.plan(months, current, now)
+	for _, backup := range expired {
+		remotePath := path.Join(unitPath, backup.YearMonth, backup.Name)
+		if !policy.DryRun {
+			if err := remote.DeleteFile(backup.ID); err != nil {
+				report.Err = fmt.Errorf("не удалось удалить архив %v: %v", remotePath, err)
+				return report
+			}
+			if backup.ManifestID != "" {
+				if err := remote.DeleteFile(backup.ManifestID); err != nil {
+					report.Err = fmt.Errorf("не удалось удалить манифест архива %v: %v", remotePath, err)
+					return report
+				}
+			}
+		}
+		report.Deleted = append(report.Deleted, remotePath)
+	}
+
+	for _, month := range empty {
+		remotePath := path.Join(unitPath, month.Name)
+		if !policy.DryRun {
+			// Хранилища удаляют папку вместе с содержимым, поэтому папка удаляется, только если
+			// повторный список пуст: в ней не должно быть файлов, не попавших в первый список
+			items, err := remote.ListDirItems(remotePath)
+			if err != nil {
+				report.Err = fmt.Errorf("не удалось получить список файлов в папке %v: %w", remotePath, err)
+				return report
+			}
+			if len(items) > 0 {
+				continue
+			}
+			if err := remote.DeleteDir(month.ID); err != nil {
+				report.Err = fmt.Errorf("не удалось удалить пустую папку %v: %v", remotePath, err)
+				return report
+			}
+		}
+		report.Deleted = append(report.Deleted, remotePath)
+	}
+
+	return report
+}
+
+// ListLocal возвращает содержимое папок год-месяц в папке юнита. Файлы, имя которых не соответствует
+// формату архива, учитываются как посторонние. Если папки юнита нет, возвращается пустой список.
+func ListLocal(unitDir string) ([]Month, error) {
+	items, err := os.ReadDir(unitDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать папку юнита %v: %v", unitDir, err)
+	}
+
+	var months []Month
+	for _, item := range items {
+		if !item.IsDir() || !IsYearMonth(item.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(unitDir, item.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать папку %v: %v", item.Name(), err)
+		}
+		month := Month{Name: item.Name()}
+		for _, file := range files {
+			month.add(file.Name(), "", file.IsDir())
+		}
+		month.attachManifests()
+		months = append(months, month)
+	}
+
+	return months, nil
+}
+
+// ListRemote возвращает содержимое папок год-месяц в папке юнита в удаленном хранилище.
+func ListRemote(remote cloudStorages.Lister, unitPath string) ([]Month, error) {
+	items, err := remote.ListDirItems(unitPath)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить список папок юнита %v: %v", unitPath, err)
+	}
+
+	var months []Month
+	for _, item := range items {
+		if !item.IsDir() || !IsYearMonth(item.Name) {
+			continue
+		}
+		files, err := remote.ListDirItems(path.Join(unitPath, item.Name))
+		if err != nil {
+			return nil, fmt.Errorf("не удалось получить список архивов в папке %v: %v", item.Name, err)
+		}
+		month := Month{Name: item.Name, ID: item.Id}
+		for _, file := range files {
+			month.add(file.Name, file.Id, file.IsDir())
+		}
+		month.attachManifests()
+		months = append(months, month)
+	}
+
+	sort.Slice(months, func(i, j int) bool { return months[i].Name < months[j].Name })
+	return months, nil
+}
+
+// add добавляет элемент папки к архивам, манифестам, временным файлам сжатия или к посторонним файлам.
+func (m *Month) add(name, id string, isDir bool) {
+	if !isDir {
+		if compress.IsTempFile(name) {
+			m.temps = append(m.temps, name)
+			return
+		}
+		if archive, ok := manifest.IsManifest(name); ok {
+			if m.manifests == nil {
+				m.manifests = map[string]string{}
+			}
+			m.manifests[archive] = id
+			return
+		}
+		if t, err := compress.ArchiveTime(m.Name, name); err == nil {
+			m.Backups = append(m.Backups, Backup{YearMonth: m.Name, Name: name, Time: t, ID: id})
+			return
+		}
+	}
+	m.Others++
+}
+
+// attachManifests привязывает манифесты к их архивам. Манифесты, архива которых в папке нет,
+// учитываются как посторонние файлы.
+func (m *Month) attachManifests() {
+	for i, backup := range m.Backups {
+		if id, ok := m.manifests[backup.Name]; ok {
+			m.Backups[i].Manifest = manifest.Name(backup.Name)
+			m.Backups[i].ManifestID = id
+			delete(m.manifests, backup.Name)
+		}
+	}
+	m.Others += len(m.manifests)
+	m.manifests = nil
+}
+
+// removeManifest удаляет локальный манифест архива backup из папки юнита unitDir, если он есть.
+func removeManifest(unitDir string, backup Backup) error {
+	if backup.Manifest == "" {
+		return nil
+	}
+	path := filepath.Join(unitDir, backup.YearMonth, backup.Manifest)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("не удалось удалить манифест %v: %v", path, err)
+	}
+	return nil
+}
+
+// removeMonth удаляет папку год-месяц month из папки юнита unitDir вместе с оставшимися в ней после сбоя
+// временными файлами сжатия.
+func removeMonth(unitDir string, month Month) error {
+	dir := filepath.Join(unitDir, month.Name)
+	for _, name := range month.temps {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("не удалось удалить временный файл %v: %w", name, err)
+		}
+	}
+	if err := os.Remove(dir); err != nil {
+		return fmt.Errorf("не удалось удалить пустую папку %v: %w", dir, err)
+	}
+	return nil
+}
+
+// IsYearMonth проверяет, что имя папки соответствует формату YYYY-MM.
+func IsYearMonth(name string) bool {
+	_, err := time.Parse(compress.YearMonthLayout, name)
+	return err == nil && len(name) == len(compress.YearMonthLayout)
+}
@@ -0,0 +1,284 @@
+package retention
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages/cloudStorages"
+)
+
+func TestPruneLocal(t *testing.T) {
+	unitDir := t.TempDir()
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local)
+
+	// Создаем структуру папок юнита
+	files := []string{
+		"2024-01/10-13:37-nginx.zip",               // старше 30 дней, папка станет пустой
+		"2024-01/10-13:37-nginx.zip.manifest.json", // манифест удаляется вместе с архивом
+		"2024-01/.kk-123.deflate",                  // временный файл сжатия, оставшийся после сбоя
+		"2024-02/01-13:37-nginx.zip",               // старше 30 дней
+		"2024-02/20-13:37-nginx.zip",               // моложе 30 дней
+		"2024-03/15-12:00-nginx.zip",               // только что созданный архив
+		"2023-12/01-10:00-nginx.zip",               // старый, но передан как текущий и не должен удаляться
+		"2023-10/01-10:00-nginx.zip",               // то же имя в другом месяце удаляется
+		"2023-11/notes.txt",                        // посторонний файл
+		"2023-11/01-10:00-nginx.tar.gz",            // старый архив рядом с посторонним файлом
+	}
+	for _, file := range files {
+		path := filepath.Join(unitDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Ошибка при создании папки: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Ошибка при создании файла: %v", err)
+		}
+	}
+
+	current := Backup{YearMonth: "2023-12", Name: "01-10:00-nginx.zip"}
+	report := PruneLocal(unitDir, Policy{Retention: config.Retention{Days: 30}}, current, now)
+	if report.Err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", report.Err)
+	}
+
+	expectDeleted := []string{
+		filepath.Join(unitDir, "2023-10/01-10:00-nginx.zip"),
+		filepath.Join(unitDir, "2023-11/01-10:00-nginx.tar.gz"),
+		filepath.Join(unitDir, "2024-01/10-13:37-nginx.zip"),
+		filepath.Join(unitDir, "2024-02/01-13:37-nginx.zip"),
+		filepath.Join(unitDir, "2023-10"),
+		filepath.Join(unitDir, "2024-01"),
+	}
+	if !reflect.DeepEqual(report.Deleted, expectDeleted) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", expectDeleted, report.Deleted)
+	}
+
+	if _, err := os.Stat(filepath.Join(unitDir, "2024-01/10-13:37-nginx.zip.manifest.json")); !os.IsNotExist(err) {
+		t.Errorf("Манифест должен был быть удален вместе с архивом: %v", err)
+	}
+
+	expectKept := []string{"2024-02/20-13:37-nginx.zip", "2024-03/15-12:00-nginx.zip", "2023-12/01-10:00-nginx.zip", "2023-11/notes.txt"}
+	for _, file := range expectKept {
+		if _, err := os.Stat(filepath.Join(unitDir, file)); err != nil {
+			t.Errorf("Файл %v не должен был быть удален: %v", file, err)
+		}
+	}
+}
+
+func TestPruneLocalDisabled(t *testing.T) {
+	unitDir := t.TempDir()
+	path := filepath.Join(unitDir, "2020-01", "01-10:00-nginx.zip")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Ошибка при создании папки: %v", err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("Ошибка при создании файла: %v", err)
+	}
+
+	// Нулевой срок хранения означает бессрочное хранение
+	report := PruneLocal(unitDir, Policy{}, Backup{}, time.Now())
+	if report.Err != nil || len(report.Deleted) != 0 {
+		t.Errorf("При нулевом сроке хранения ничего не должно удаляться: %+v", report)
+	}
+}
+
+// fakeRemote - удаленное хранилище в памяти: путь папки -> список элементов.
+type fakeRemote struct {
+	dirs    map[string][]cloudStorages.File
+	late    map[string][]cloudStorages.File // элементы, которые появляются только в повторном списке папки
+	listed  map[string]int
+	deleted []string
+}
+
+func (f *fakeRemote) ListDirItems(remotePath string) ([]cloudStorages.File, error) {
+	items, ok := f.dirs[remotePath]
+	if !ok {
+		return nil, fmt.Errorf("папка %s не найдена", remotePath)
+	}
+	if f.listed == nil {
+		f.listed = map[string]int{}
+	}
+	f.listed[remotePath]++
+	if f.listed[remotePath] > 1 {
+		items = append(items, f.late[remotePath]...)
+	}
+	return items, nil
+}
+
+func (f *fakeRemote) DeleteFile(fileID string) error {
+	f.deleted = append(f.deleted, fileID)
+	for dir, items := range f.dirs {
+		var kept []cloudStorages.File
+		for _, item := range items {
+			if item.Id != fileID {
+				kept = append(kept, item)
+			}
+		}
+		f.dirs[dir] = kept
+	}
+	return nil
+}
+
+func (f *fakeRemote) DeleteDir(dirID string) error {
+	f.deleted = append(f.deleted, dirID)
+	return nil
+}
+
+func TestPruneRemote(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local)
+	folder := "application/vnd.google-apps.folder"
+
+	newRemote := func() *fakeRemote {
+		return &fakeRemote{dirs: map[string][]cloudStorages.File{
+			"host/nginx": {
+				{Id: "dir-2024-01", Name: "2024-01", MimeType: folder},
+				{Id: "dir-2024-03", Name: "2024-03", MimeType: folder},
+				{Id: "other", Name: "other", MimeType: folder},
+			},
+			"host/nginx/2024-01": {
+				{Id: "m1", Name: "10-13:37-nginx.zip.manifest.json"},
+				{Id: "f1", Name: "10-13:37-nginx.zip"},
+				{Id: "f2", Name: "11-13:37-nginx.zip"},
+			},
+			"host/nginx/2024-03": {
+				{Id: "f3", Name: "01-13:37-nginx.zip"},
+				{Id: "f4", Name: "15-12:00-nginx.zip"},
+			},
+		}}
+	}
+
+	current := Backup{YearMonth: "2024-03", Name: "15-12:00-nginx.zip"}
+
+	// Обычная очистка: удаляются старые архивы и ставшая пустой папка
+	remote := newRemote()
+	report := PruneRemote(remote, "host/nginx", Policy{Retention: config.Retention{Days: 30}}, current, now)
+	if report.Err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", report.Err)
+	}
+	expectDeleted := []string{"f1", "m1", "f2", "dir-2024-01"}
+	if !reflect.DeepEqual(remote.deleted, expectDeleted) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", expectDeleted, remote.deleted)
+	}
+	expectReport := []string{"host/nginx/2024-01/10-13:37-nginx.zip", "host/nginx/2024-01/11-13:37-nginx.zip", "host/nginx/2024-01"}
+	if !reflect.DeepEqual(report.Deleted, expectReport) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", expectReport, report.Deleted)
+	}
+
+	// Папка, в которой при повторном списке нашелся файл, не удаляется
+	remote = newRemote()
+	remote.late = map[string][]cloudStorages.File{"host/nginx/2024-01": {{Id: "f5", Name: "12-13:37-nginx.zip"}}}
+	report = PruneRemote(remote, "host/nginx", Policy{Retention: config.Retention{Days: 30}}, current, now)
+	if report.Err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", report.Err)
+	}
+	expectDeleted = []string{"f1", "m1", "f2"}
+	if !reflect.DeepEqual(remote.deleted, expectDeleted) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", expectDeleted, remote.deleted)
+	}
+	if !reflect.DeepEqual(report.Deleted, expectReport[:2]) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", expectReport[:2], report.Deleted)
+	}
+
+	// В режиме dry-run отчет тот же, но ничего не удаляется
+	remote = newRemote()
+	report = PruneRemote(remote, "host/nginx", Policy{Retention: config.Retention{Days: 30}, DryRun: true}, current, now)
+	if report.Err != nil || !report.DryRun {
+		t.Fatalf("Неверный отчет dry-run: %+v", report)
+	}
+	if len(remote.deleted) != 0 {
+		t.Errorf("В режиме dry-run ничего не должно удаляться, удалено: %v", remote.deleted)
+	}
+	if !reflect.DeepEqual(report.Deleted, expectReport) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", expectReport, report.Deleted)
+	}
+}
+
+func TestExpiredGFS(t *testing.T) {
+	now := time.Date(2024, 3, 15, 23, 0, 0, 0, time.Local)
+
+	// Архивы каждые 12 часов за последние 400 дней
+	var backups []Backup
+	for i := 0; i < 800; i++ {
+		backupTime := now.Add(-time.Duration(i) * 12 * time.Hour)
+		backups = append(backups, Backup{Name: backupTime.Format("2006-01-02 15:04"), Time: backupTime})
+	}
+
+	policy := Policy{Retention: config.Retention{KeepLast: 3, KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 12, KeepYearly: 3}}
+	expired := map[string]bool{}
+	for _, backup := range policy.Expired(backups, now) {
+		expired[backup.Name] = true
+	}
+
+	var kept []string
+	for _, backup := range backups {
+		if !expired[backup.Name] {
+			kept = append(kept, backup.Name)
+		}
+	}
+
+	expectKept := []string{
+		"2024-03-15 23:00", "2024-03-15 11:00", "2024-03-14 23:00", // последние 3 и дневные
+		"2024-03-13 23:00", "2024-03-12 23:00", "2024-03-11 23:00", "2024-03-10 23:00", "2024-03-09 23:00", // дневные
+		"2024-03-03 23:00", "2024-02-25 23:00", // недельные (воскресенья)
+		"2024-02-29 23:00", "2024-01-31 23:00", "2023-12-31 23:00", "2023-11-30 23:00", "2023-10-31 23:00", // месячные и годовые
+		"2023-09-30 23:00", "2023-08-31 23:00", "2023-07-31 23:00", "2023-06-30 23:00", "2023-05-31 23:00", "2023-04-30 23:00",
+	}
+	sortStrings := func(items []string) []string {
+		sorted := append([]string(nil), items...)
+		sort.Strings(sorted)
+		return sorted
+	}
+	if !reflect.DeepEqual(sortStrings(kept), sortStrings(expectKept)) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", sortStrings(expectKept), sortStrings(kept))
+	}
+}
+
+func TestEvict(t *testing.T) {
+	unitDir := t.TempDir()
+	files := []string{
+		"2024-01/10-13:37-nginx.zip",
+		"2024-02/01-13:37-nginx.zip",
+		"2024-02/20-13:37-nginx.zip",
+		"2024-03/10-13:37-nginx.zip", // последний архив, по нему оценивается размер нового
+	}
+	for _, file := range files {
+		path := filepath.Join(unitDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Ошибка при создании папки: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatalf("Ошибка при создании файла: %v", err)
+		}
+	}
+
+	// Освободить место нельзя: даже последний архив и новый займут 200 байт
+	report := Evict(unitDir, 100)
+	if report.Err == nil || len(report.Deleted) != 0 {
+		t.Errorf("Ожидалась ошибка без удаления архивов, полученно: %+v", report)
+	}
+
+	// 400 байт архивов и 100 байт нового архива при ограничении 300 байт
+	report = Evict(unitDir, 300)
+	if report.Err != nil {
+		t.Fatalf("Не ожидалась ошибка, но она произошла: %v", report.Err)
+	}
+	expectDeleted := []string{
+		filepath.Join(unitDir, "2024-01/10-13:37-nginx.zip"),
+		filepath.Join(unitDir, "2024-02/01-13:37-nginx.zip"),
+		filepath.Join(unitDir, "2024-01"),
+	}
+	if !reflect.DeepEqual(report.Deleted, expectDeleted) {
+		t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", expectDeleted, report.Deleted)
+	}
+
+	// Ограничение соблюдается, ничего больше не удаляется
+	report = Evict(unitDir, 300)
+	if report.Err != nil || len(report.Deleted) != 0 {
+		t.Errorf("Ничего не должно удаляться: %+v", report)
+	}
+}
@@ -0,0 +1,166 @@
+// Пакет retry реализует повтор операций с удаленными хранилищами при временных ошибках.
+//
+// Пауза перед повтором растет экспоненциально от BaseDelay до MaxDelay и случайно отклоняется на долю Jitter,
+// чтобы несколько юнитов не повторяли запросы к хранилищу одновременно. Повторяются только временные ошибки:
+// таймауты, обрывы соединения, ошибки DNS, ответы 408, 429 и 5xx, превышение ограничения частоты запросов
+// Google API и временные отказы FTP сервера (4xx). Остальные ошибки, например неверные учетные данные
+// или отсутствующий файл, возвращаются сразу. Ошибка определяется по типу, а не по тексту.
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/textproto"
+	"syscall"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/sftp"
+	"google.golang.org/api/googleapi"
+)
+
+// Настройки повторов по умолчанию.
+const (
+	DefaultMaxAttempts = 3
+	DefaultBaseDelay   = 2 * time.Second
+	DefaultMaxDelay    = time.Minute
+	DefaultJitter      = 0.2
+)
+
+// sleep приостанавливает выполнение перед повтором, в тестах подменяется.
+var sleep = time.Sleep
+
+// Policy описывает политику повторов операции.
+type Policy struct {
+	MaxAttempts int           // Максимальное количество попыток
+	BaseDelay   time.Duration // Пауза перед первым повтором
+	MaxDelay    time.Duration // Максимальная пауза между попытками
+	Jitter      float64       // Случайное отклонение паузы в долях от нее
+}
+
+// NewPolicy создает политику повторов по настройкам conf, подставляя значения по умолчанию для незаданных параметров.
+func NewPolicy(conf config.Retry) Policy {
+	policy := Policy{
+		MaxAttempts: conf.MaxAttempts,
+		BaseDelay:   conf.BaseDelay,
+		MaxDelay:    conf.MaxDelay,
+		Jitter:      conf.Jitter,
+	}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = DefaultMaxAttempts
+	}
+	if policy.BaseDelay == 0 {
+		policy.BaseDelay = DefaultBaseDelay
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = DefaultMaxDelay
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	if policy.Jitter == 0 {
+		policy.Jitter = DefaultJitter
+	}
+	return policy
+}
+
+// Do выполняет fn, повторяя ее при временных ошибках, пока не закончатся попытки.
+// Возвращает количество выполненных попыток и ошибку последней из них.
+func (p Policy) Do(fn func() error) (int, error) {
+	attempt := 1
+	for ; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !Retryable(err) {
+			return attempt, err
+		}
+		sleep(p.Delay(attempt))
+	}
+}
+
+// Delay возвращает паузу после неудачной попытки с номером attempt, начиная с 1.
+func (p Policy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+// statusCoder описывает ошибку с HTTP статусом ответа сервера, например ошибку хранилища WebDAV.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// rateLimitReasons - причины ответа 403 Google API, означающие превышение ограничения частоты запросов.
+var rateLimitReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+}
+
+// Retryable сообщает, является ли ошибка временной, то есть имеет ли смысл повторить операцию.
+// Ошибка определяется по типу, поэтому хранилища оборачивают ошибки библиотек через %w.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	// EOF при обращении к хранилищу означает, что сервер закрыл соединение
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == http.StatusForbidden {
+			for _, item := range apiErr.Errors {
+				if rateLimitReasons[item.Reason] {
+					return true
+				}
+			}
+		}
+		return retryableStatus(apiErr.Code)
+	}
+	var s3Err minio.ErrorResponse
+	if errors.As(err, &s3Err) {
+		return retryableStatus(s3Err.StatusCode) || s3Err.Code == "SlowDown"
+	}
+	var statusErr statusCoder
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode())
+	}
+	// Ответы FTP 4xx означают временный отказ: 421 сервис недоступен, 425 и 426 ошибка соединения данных
+	var ftpErr *textproto.Error
+	if errors.As(err, &ftpErr) {
+		return ftpErr.Code >= 400 && ftpErr.Code < 500
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary || dnsErr.IsNotFound
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE, syscall.ETIMEDOUT,
+		syscall.ENETUNREACH, syscall.EHOSTUNREACH} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, sftp.ErrSSHFxConnectionLost)
+}
+
+// retryableStatus сообщает, является ли HTTP статус признаком временной ошибки.
+func retryableStatus(code int) bool {
+	return code == 408 || code == 429 || code >= 500
+}
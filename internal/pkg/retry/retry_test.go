@@ -0,0 +1,120 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/sftp"
+	"google.golang.org/api/googleapi"
+)
+
+func TestDo(t *testing.T) {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { sleep = time.Sleep }()
+
+	policy := Policy{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: 3 * time.Second}
+	transient := &googleapi.Error{Code: 503, Message: "Service Unavailable"}
+
+	testCases := []struct {
+		name         string
+		errs         []error
+		expectCalls  int
+		expectErr    bool
+		expectDelays []time.Duration
+	}{
+		{"успех с первой попытки", nil, 1, false, nil},
+		{"успех после временных ошибок", []error{transient, transient}, 3, false, []time.Duration{time.Second, 2 * time.Second}},
+		{"постоянная ошибка не повторяется", []error{os.ErrPermission}, 1, true, nil},
+		{"попытки закончились", []error{transient, transient, transient, transient}, 4, true, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			delays = nil
+			calls := 0
+			attempts, err := policy.Do(func() error {
+				calls++
+				if calls <= len(testCase.errs) {
+					return testCase.errs[calls-1]
+				}
+				return nil
+			})
+			if attempts != testCase.expectCalls || calls != testCase.expectCalls {
+				t.Errorf("Тест не пройден ожидалось: %v попыток, полученно: %v (вызовов %v)", testCase.expectCalls, attempts, calls)
+			}
+			if (err != nil) != testCase.expectErr {
+				t.Errorf("Тест не пройден ожидалась ошибка: %v, полученно: %v", testCase.expectErr, err)
+			}
+			if fmt.Sprint(delays) != fmt.Sprint(testCase.expectDelays) {
+				t.Errorf("Тест не пройден ожидалось: %v, полученно: %v", testCase.expectDelays, delays)
+			}
+		})
+	}
+}
+
+func TestDelayJitter(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if delay := policy.Delay(2); delay < 1600*time.Millisecond || delay > 2400*time.Millisecond {
+			t.Fatalf("Пауза %v вне допустимого отклонения от 2s", delay)
+		}
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	policy := NewPolicy(config.Retry{MaxAttempts: 5})
+	expect := Policy{MaxAttempts: 5, BaseDelay: DefaultBaseDelay, MaxDelay: DefaultMaxDelay, Jitter: DefaultJitter}
+	if policy != expect {
+		t.Errorf("Тест не пройден ожидалось: %+v, полученно: %+v", expect, policy)
+	}
+}
+
+// statusError - ошибка с HTTP статусом, как у хранилища WebDAV.
+type statusError int
+
+func (e statusError) Error() string   { return fmt.Sprintf("сервер ответил %d", int(e)) }
+func (e statusError) StatusCode() int { return int(e) }
+
+func TestRetryable(t *testing.T) {
+	testCases := []struct {
+		err    error
+		expect bool
+	}{
+		{&googleapi.Error{Code: 503}, true},
+		{&googleapi.Error{Code: 429}, true},
+		{fmt.Errorf("ошибка загрузки: %w", &googleapi.Error{Code: 404}), false},
+		{&net.DNSError{Err: "no such host", Name: "www.googleapis.com", IsNotFound: true}, true},
+		{fmt.Errorf("sftp: %w", syscall.ECONNRESET), true},
+		{fmt.Errorf("ошибка загрузки файла: %w", &url.Error{Op: "Post", URL: "https://www.googleapis.com/upload", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "www.googleapis.com", IsNotFound: true}}}), true},
+		{fmt.Errorf("ошибка загрузки файла: %w", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}), true},
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "insufficientPermissions"}}}, false},
+		{fmt.Errorf("s3: %w", minio.ErrorResponse{Code: "SlowDown", StatusCode: 503}), true},
+		{fmt.Errorf("s3: %w", minio.ErrorResponse{Code: "NoSuchKey", StatusCode: 404}), false},
+		{fmt.Errorf("webdav: %w", statusError(502)), true},
+		{fmt.Errorf("webdav: %w", statusError(401)), false},
+		{fmt.Errorf("ftp: ошибка записи файла a.part: %w", io.EOF), true},
+		{fmt.Errorf("ftp: %w", &textproto.Error{Code: 421, Msg: "Too many connections"}), true},
+		{fmt.Errorf("ftp: %w", &textproto.Error{Code: 550, Msg: "Permission denied"}), false},
+		{fmt.Errorf("sftp: %w", sftp.ErrSSHFxConnectionLost), true},
+		{errors.New("sftp: не удалось подключиться к nas:22: ssh: handshake failed: ssh: unable to authenticate"), false},
+		// Ошибка без типа, например обернутая через %v, не считается временной
+		{errors.New("write tcp 127.0.0.1:443: connection reset by peer"), false},
+		{context.Canceled, false},
+	}
+	for _, testCase := range testCases {
+		if got := Retryable(testCase.err); got != testCase.expect {
+			t.Errorf("Тест не пройден для %q ожидалось: %v, полученно: %v", testCase.err, testCase.expect, got)
+		}
+	}
+}
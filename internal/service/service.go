@@ -3,24 +3,36 @@ package service
 
 import (
 	"fmt"
+	"io"
 	"path/filepath"
 	"time"
 
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/compress"
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/config"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/diskusage"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/encryption"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/manifest"
 	"github.com/Erikqwerty/KronosKeeper/internal/pkg/remotestorages"
+	"github.com/Erikqwerty/KronosKeeper/internal/pkg/retention"
 )
 
-// Backup представляет собой структуру, объединяющую компрессию и удаленное хранилище
+// Backup представляет собой сервис резервного копирования. Демон использует один Backup для всех юнитов,
+// задания которых выполняются одновременно, поэтому подключения к хранилищам создаются на каждый запуск
+// и не хранятся в Backup.
 type Backup struct {
 	*compress.Compress
-	*remotestorages.Remotestorages
 }
 
 // BackupReport содержит отчет о создании резервной копии
 type BackupReport struct {
-	Local       *compress.CompressReport      // отчет о локальное резервной копии
-	Remote      *remotestorages.UploadReports // отчет о загрузки в удаленные хранилеща
+	Local       *compress.CompressReport     // отчет о локальное резервной копии, при потоковой отправке ArchivePath пустой
+	ArchiveName string                       // имя архива, заполняется и при ошибке потокового создания, когда Local пустой
+	Remote      remotestorages.UploadReports // отчеты о загрузки в удаленные хранилеща по имени хранилища
+	Evicted     *retention.Report            // отчет об удалении старых локальных архивов для соблюдения maxDiskUsage
+	LocalPrune  *retention.Report            // отчет об удалении локальных архивов с истекшим сроком хранения
+	RemotePrune remotestorages.PruneReports  // отчеты об удалении архивов с истекшим сроком хранения по имени хранилища
+	Manifest    string                       // путь к локальному манифесту архива, пустой при потоковой отправке
+	ManifestErr error                        // ошибка сохранения локального манифеста
 	CurrentTime string
 }
 
@@ -29,8 +41,9 @@ func NewBackup() *Backup {
 	return &Backup{}
 }
 
-// CreateBackup создает резервную копию согласно конфигурации unit и загружает ее в удаленное хранилище, если remote не равно nil
-func (b *Backup) CreateBackup(unit config.BackupUnit, remote *config.RemoteStorages) (*BackupReport, error) {
+// CreateBackup создает резервную копию согласно конфигурации unit и загружает ее в удаленное хранилище, если у юнита указаны хранилища.
+// Рядом с архивом локально и в хранилищах сохраняется его манифест <архив>.manifest.json.
+func (b *Backup) CreateBackup(unit config.BackupUnit, remote config.RemoteStorages) (*BackupReport, error) {
 	backupReport := &BackupReport{
 		Local:       nil,
 		Remote:      nil,
@@ -41,6 +54,26 @@ func (b *Backup) CreateBackup(unit config.BackupUnit, remote *config.RemoteStora
 		InputPaths:  unit.InputPaths,
 		OutputPath:  unit.OutputPath,
 		ExludeFile:  unit.CompressExclude,
+		Level:       unit.CompressLevel,
+		Workers:     unit.CompressWorkers,
+	}
+
+	// Шифруем архив во время создания, если это указано в настройках юнита
+	if unit.Encryption != nil {
+		encryptor, err := encryption.New(unit.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("CreateBackup, ошибка настроек шифрования, текст: %v", err)
+		}
+		c.Encryptor = encryptor
+	}
+
+	if unit.Streaming {
+		return b.streamBackup(c, unit, remote, backupReport)
+	}
+
+	// Освобождаем место под новый архив, если у юнита ограничено использование диска
+	if err := b.enforceDiskUsage(unit, backupReport); err != nil {
+		return backupReport, fmt.Errorf("CreateBackup, превышено ограничение maxDiskUsage, текст: %v", err)
 	}
 
 	cReport, err := c.Start(unit.CompressFormat)
@@ -48,9 +81,15 @@ func (b *Backup) CreateBackup(unit config.BackupUnit, remote *config.RemoteStora
 		return nil, fmt.Errorf("CreateBackup, ошибка при создание архива, текст: %v", err)
 	}
 	backupReport.Local = cReport
+	backupReport.ArchiveName = cReport.ArchiveName
 
-	if remote != nil {
-		b.Remotestorages, err = remotestorages.New(&remotestorages.UploadConfig{
+	// Сохраняем манифест рядом с архивом, даже если загрузка в хранилища не удастся
+	m := manifest.New(unit, cReport)
+	backupReport.Manifest, backupReport.ManifestErr = m.WriteFile(cReport.ArchivePath)
+
+	var remotes *remotestorages.Remotestorages
+	if len(unit.UploadTo) > 0 {
+		remotes, err = remotestorages.New(&remotestorages.UploadConfig{
 			UploadTO:   unit.UploadTo,                                               // Передаем в какие удаленные хранилеща делать push
 			LocalPath:  filepath.Join(cReport.ArchivePath, cReport.ArchiveName),     // указываем путь к архиву и имени архива
 			RemotePath: filepath.Join(unit.RemotePath, unit.Name, cReport.YearMoth), // Передаем путь на удаленном хранилище какой должен быть
@@ -58,8 +97,107 @@ func (b *Backup) CreateBackup(unit config.BackupUnit, remote *config.RemoteStora
 		if err != nil {
 			return backupReport, err
 		}
-		backupReport.Remote = b.UploadBackups()
+		// Закрываем подключения к хранилищам после загрузки и очистки
+		defer remotes.Close()
+		backupReport.Remote = remotes.UploadBackups()
+		remotes.VerifyUploads(backupReport.Remote, cReport.ArchiveName, checksums(cReport))
+		uploadManifest(remotes, m, backupReport)
+	}
+
+	prune(remotes, unit, cReport, backupReport)
+	return backupReport, nil
+}
+
+// prune удаляет локальные и удаленные архивы юнита, срок хранения которых истек, не трогая только что созданный архив.
+// Удаленные архивы очищаются в хранилищах remotes, nil - только локальные.
+func prune(remotes *remotestorages.Remotestorages, unit config.BackupUnit, cReport *compress.CompressReport, backupReport *BackupReport) {
+	policy := retention.Policy{Retention: unit.Retention, DryRun: unit.RetentionDryRun}
+	if policy.IsEmpty() {
+		return
+	}
+	current := retention.Backup{YearMonth: cReport.YearMoth, Name: cReport.ArchiveName}
+	if unit.OutputPath != "" {
+		backupReport.LocalPrune = retention.PruneLocal(filepath.Join(unit.OutputPath, unit.Name), policy, current, time.Now())
+	}
+	if backupReport.Remote != nil && remotes != nil {
+		backupReport.RemotePrune = remotes.PruneBackups(backupReport.Remote, filepath.Join(unit.RemotePath, unit.Name), policy, current)
+	}
+}
+
+// enforceDiskUsage проверяет, что с новым архивом папка юнита не превысит maxDiskUsage, и при необходимости
+// удаляет самые старые архивы. Если освободить место нельзя, возвращается ошибка и архив не создается.
+func (b *Backup) enforceDiskUsage(unit config.BackupUnit, backupReport *BackupReport) error {
+	limit, err := diskusage.ParseLimit(unit.MaxDiskUsage)
+	if err != nil || limit.IsEmpty() {
+		return err
+	}
+	unitDir := filepath.Join(unit.OutputPath, unit.Name)
+	bytes, err := limit.Resolve(unitDir)
+	if err != nil {
+		return err
+	}
+	backupReport.Evicted = retention.Evict(unitDir, bytes)
+	return backupReport.Evicted.Err
+}
+
+// streamBackup создает архив и передает его в удаленные хранилища потоком, не сохраняя на локальный диск.
+// Размер и контрольная сумма архива записываются в отчет так же, как при локальном создании.
+func (b *Backup) streamBackup(c *compress.Compress, unit config.BackupUnit, remote config.RemoteStorages, backupReport *BackupReport) (*BackupReport, error) {
+	if len(unit.UploadTo) == 0 {
+		return nil, fmt.Errorf("CreateBackup, для потоковой отправки юниту %v нужны удаленные хранилища", unit.Name)
+	}
+
+	var remotes *remotestorages.Remotestorages
+	var stream *remotestorages.Stream
+	// Закрываем подключения к хранилищам после загрузки и очистки
+	defer func() {
+		if remotes != nil {
+			remotes.Close()
+		}
+	}()
+	cReport, err := c.Stream(unit.CompressFormat, func(report *compress.CompressReport) (io.Writer, error) {
+		var err error
+		remotes, err = remotestorages.New(&remotestorages.UploadConfig{
+			UploadTO:   unit.UploadTo,                                              // Передаем в какие удаленные хранилеща делать push
+			RemotePath: filepath.Join(unit.RemotePath, unit.Name, report.YearMoth), // Передаем путь на удаленном хранилище какой должен быть
+		}, remote)
+		if err != nil {
+			return nil, err
+		}
+		backupReport.ArchiveName = report.ArchiveName
+		stream = remotes.OpenStream(report.ArchiveName)
+		return stream, nil
+	})
+	if err != nil {
+		if stream != nil {
+			backupReport.Remote = stream.CloseWithError(err)
+		}
+		// Отчеты хранилищ возвращаются вместе с ошибкой, чтобы их результаты попали в лог и уведомления
+		return backupReport, fmt.Errorf("CreateBackup, ошибка при потоковом создании архива, текст: %v", err)
 	}
 
+	backupReport.Local = cReport
+	backupReport.Remote = stream.Close()
+	remotes.VerifyUploads(backupReport.Remote, cReport.ArchiveName, checksums(cReport))
+	uploadManifest(remotes, manifest.New(unit, cReport), backupReport)
+	prune(remotes, unit, cReport, backupReport)
 	return backupReport, nil
 }
+
+// uploadManifest загружает манифест m в хранилища remotes, в которые загружен архив. Ошибки загрузки
+// записываются в отчеты хранилищ.
+func uploadManifest(remotes *remotestorages.Remotestorages, m *manifest.Manifest, backupReport *BackupReport) {
+	data, err := m.Marshal()
+	if err != nil {
+		for _, report := range backupReport.Remote {
+			report.ManifestErr = err
+		}
+		return
+	}
+	remotes.UploadManifest(backupReport.Remote, manifest.Name(m.Archive), data)
+}
+
+// checksums возвращает размер и контрольные суммы архива для проверки его копий в удаленных хранилищах.
+func checksums(cReport *compress.CompressReport) remotestorages.Checksums {
+	return remotestorages.Checksums{Size: cReport.Size, SHA256: cReport.SHA256, MD5: cReport.MD5}
+}